@@ -9,11 +9,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -31,10 +29,13 @@ type DirectoryInfo struct {
 	lenMS        int                             // The length of the master secret of the directory.
 	tlfID        sserver1.FolderID               // The TLF ID of the directory.
 	tlfInfo      sserver1.TlfInfo                // The TLF information of the directory.
+	analyzer     libsearch.Analyzer              // The analyzer recorded in the TLF information, with the current normalization scheme.
 	keyGenLock   sync.RWMutex                    // The RWMutex to protect the `keyGen`, `indexer` and `pathnameKeys` variables`.
 	keyGen       libkbfs.KeyGen                  // The lastest key generation of this directory.
 	indexers     []*libsearch.SecureIndexBuilder // The indexers for the directory.
 	pathnameKeys []libsearch.PathnameKeyType     // The keys to encrypt and decrypt the pathname to/from document IDs.
+	manifest     *manifest                       // The local record of the indexes uploaded for the directory.
+	policy       IndexPolicy                     // The policy deciding which files of the directory are indexed.
 }
 
 // Client contains all the necessary information for a KBFS Search Client.
@@ -107,13 +108,22 @@ func getNormalizedKeyIndex(keyGen libkbfs.KeyGen) int {
 // getLatestKeyIndex is the goroutine-safe helper function that calculates the
 // index of the key to use for building the index or trapdoor word.
 func (d *DirectoryInfo) getLatestKeyIndex() int {
+	_, keyIndex := d.getLatestKeyGen()
+	return keyIndex
+}
+
+// getLatestKeyGen is the goroutine-safe helper function that returns the
+// latest key generation along with the index of its key, read together so
+// that they match even if the keys are being updated.
+func (d *DirectoryInfo) getLatestKeyGen() (libkbfs.KeyGen, int) {
 	d.keyGenLock.RLock()
 	defer d.keyGenLock.RUnlock()
 	keyGen := d.keyGen
+	keyIndex := getNormalizedKeyIndex(keyGen)
 	if keyGen == libkbfs.PublicKeyGen {
-		keyGen = libkbfs.FirstValidKeyGen
+		keyIndex = getNormalizedKeyIndex(libkbfs.FirstValidKeyGen)
 	}
-	return int(getNormalizedKeyIndex(keyGen))
+	return keyGen, keyIndex
 }
 
 // getIndexer is the goroutine-safe getter for a specific indexer with `index`.
@@ -131,21 +141,66 @@ func (d *DirectoryInfo) getPathnameKey(index int) libsearch.PathnameKeyType {
 	return d.pathnameKeys[index]
 }
 
-// CreateClient creates a new `Client` instance with the parameters and returns
-// a pointer the the instance.  Returns an error on any failure.
-func CreateClient(ctx context.Context, ipAddr string, port int, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64, verbose bool) (*Client, error) {
+// ClientOptions are the parameters of a `Client`.  The parameters of the TLFs
+// are only recorded for the TLFs registered by the client, while the TLFs that
+// already exist keep theirs.
+type ClientOptions struct {
+	ManifestDir     string                 // The directory under which the manifests of the uploaded indexes are kept.
+	LenMS           int                    // The length of the master secrets.
+	LenSalt         int                    // The length of the salts of the TLFs.
+	FpRate          float64                // The false positive rate of the indexes of the TLFs.
+	NumUniqWords    uint64                 // The expected number of unique words in a file of the TLFs.
+	Analyzer        string                 // The ID of the analyzer of the TLFs.
+	StripDiacritics bool                   // Whether the diacritics are removed from the keywords of the TLFs.
+	Index           libsearch.IndexOptions // What is indexed besides the keywords of the files of the TLFs.
+	Verbose         bool                   // Whether the logs of the RPC connection are printed out.
+}
+
+// registerArg returns the argument registering `tlfID` with the parameters of
+// `opts`.
+func (opts ClientOptions) registerArg(tlfID sserver1.FolderID) sserver1.RegisterTlfIfNotExistsArg {
+	return sserver1.RegisterTlfIfNotExistsArg{
+		TlfID:           tlfID,
+		LenSalt:         opts.LenSalt,
+		FpRate:          opts.FpRate,
+		NumUniqWords:    int64(opts.NumUniqWords),
+		Analyzer:        opts.Analyzer,
+		StripDiacritics: opts.StripDiacritics,
+		MaxPrefixLen:    opts.Index.MaxPrefixLen,
+		Fuzzy:           opts.Index.Fuzzy,
+		Phrases:         opts.Index.Phrases,
+		Paths:           opts.Index.Paths,
+		Metadata:        opts.Index.Metadata,
+	}
+}
+
+// CreateClient creates a new `Client` instance for the `directories` with the
+// server at `ipAddr` and `port`, and returns a pointer to the instance.
+// Returns an error on any failure.
+func CreateClient(ctx context.Context, ipAddr string, port int, directories []string, opts ClientOptions) (*Client, error) {
 	serverAddr := fmt.Sprintf("%s:%d", ipAddr, port)
-	conn := rpc.NewTLSConnection(serverAddr, libsearch.GetRootCerts(serverAddr), libkb.ErrorUnwrapper{}, &Client{}, true, rpc.NewSimpleLogFactory(logOutput{verbose: verbose}, nil), libkb.WrapError, logOutput{verbose: verbose}, logTags)
+	conn := rpc.NewTLSConnection(serverAddr, libsearch.GetRootCerts(serverAddr), libkb.ErrorUnwrapper{}, &Client{}, true, rpc.NewSimpleLogFactory(logOutput{verbose: opts.Verbose}, nil), libkb.WrapError, logOutput{verbose: opts.Verbose}, logTags)
 
 	searchCli := sserver1.SearchServerClient{Cli: conn.GetClient()}
 
-	return createClientWithClient(ctx, searchCli, directories, lenMS, lenSalt, fpRate, numUniqWords)
+	return createClientWithClient(ctx, searchCli, directories, opts)
 }
 
 // createClient creates a new `Client` with a given SearchServerInterface.
 // Should only be used internally and for tests.
-func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServerInterface, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64) (*Client, error) {
+func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServerInterface, directories []string, opts ClientOptions) (cli *Client, err error) {
+	if _, err := libsearch.GetAnalyzer(opts.Analyzer); err != nil {
+		return nil, err
+	}
+
 	directoryInfos := make(map[string]*DirectoryInfo)
+	defer func() {
+		if err != nil {
+			for _, dirInfo := range directoryInfos {
+				dirInfo.manifest.close()
+			}
+		}
+	}()
 
 	// Initializes the info for each directory.
 	for _, directory := range directories {
@@ -160,51 +215,64 @@ func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServer
 			return nil, err
 		}
 
-		tlfInfo, err := searchCli.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: lenSalt, FpRate: fpRate, NumUniqWords: int64(numUniqWords)})
+		tlfInfo, err := searchCli.RegisterTlfIfNotExists(ctx, opts.registerArg(tlfID))
 		if err != nil {
 			return nil, err
 		}
 
+		analyzer, err := libsearch.GetAnalyzer(tlfInfo.Analyzer)
+		if err != nil {
+			return nil, err
+		}
+		analyzer = analyzer.WithNormalization(libsearch.Normalization{Version: libsearch.CurrentNormalizationVersion, StripDiacritics: tlfInfo.StripDiacritics})
+
 		var indexers []*libsearch.SecureIndexBuilder
 		var pathnameKeys []libsearch.PathnameKeyType
 
 		// Sets up the indexers and pathname keys
 		if keyGen == libkbfs.PublicKeyGen {
-			masterSecret, err := fetchMasterSecret(directory, keyGen, lenMS)
+			masterSecret, err := fetchMasterSecret(directory, keyGen, opts.LenMS)
 			if err != nil {
 				return nil, err
 			}
 			indexers = make([]*libsearch.SecureIndexBuilder, 1)
 			pathnameKeys = make([]libsearch.PathnameKeyType, 1)
-			indexers[0] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
+			indexers[0] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size), analyzer, libsearch.IndexOptionsFromTlfInfo(tlfInfo))
 			copy(pathnameKeys[0][:], masterSecret[0:32])
 		} else if keyGen >= libkbfs.FirstValidKeyGen {
 			indexers = make([]*libsearch.SecureIndexBuilder, keyGen)
 			pathnameKeys = make([]libsearch.PathnameKeyType, keyGen)
 			for i := libkbfs.KeyGen(libkbfs.FirstValidKeyGen); i <= keyGen; i++ {
-				masterSecret, err := fetchMasterSecret(directory, i, lenMS)
+				masterSecret, err := fetchMasterSecret(directory, i, opts.LenMS)
 				if err != nil {
 					return nil, err
 				}
-				indexers[getNormalizedKeyIndex(i)] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
+				indexers[getNormalizedKeyIndex(i)] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size), analyzer, libsearch.IndexOptionsFromTlfInfo(tlfInfo))
 				copy(pathnameKeys[getNormalizedKeyIndex(i)][:], masterSecret[0:32])
 			}
 		} else {
 			return nil, errors.New("invalid key generation")
 		}
 
+		manifest, err := openManifest(filepath.Join(opts.ManifestDir, tlfID.String()))
+		if err != nil {
+			return nil, err
+		}
+
 		directoryInfos[absDir] = &DirectoryInfo{
 			absDir:       absDir,
-			lenMS:        lenMS,
+			lenMS:        opts.LenMS,
 			tlfID:        tlfID,
 			tlfInfo:      tlfInfo,
+			analyzer:     analyzer,
 			keyGen:       keyGen,
 			indexers:     indexers,
 			pathnameKeys: pathnameKeys,
+			manifest:     manifest,
 		}
 	}
 
-	cli := &Client{
+	cli = &Client{
 		searchCli:      searchCli,
 		directoryInfos: directoryInfos,
 	}
@@ -215,6 +283,17 @@ func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServer
 	return cli, nil
 }
 
+// Close closes the manifests of all the directories of the client.
+func (c *Client) Close() error {
+	var firstErr error
+	for _, dirInfo := range c.directoryInfos {
+		if err := dirInfo.manifest.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // getDirectoryInfo is a helper function that gets the DirectoryInfo for
 // `directory`.  Returns an error if the `directory` provided is invalid or
 // not present in the current client.
@@ -232,52 +311,139 @@ func (c *Client) getDirectoryInfo(directory string) (*DirectoryInfo, error) {
 	return dirInfo, nil
 }
 
-// AddFile indexes a file in `directory` with the given `pathname` and writes
-// the index to the server.
-func (c *Client) AddFile(directory, pathname string) error {
-	dirInfo, err := c.getDirectoryInfo(directory)
+// builtIndex is an index built for a file, waiting to be written to the
+// server.
+type builtIndex struct {
+	relPath string             // The path of the file relative to the directory.
+	item    sserver1.IndexItem // The index and the term frequency sketch to write.
+	entry   manifestEntry      // The manifest entry to record once written.
+}
+
+// buildIndex indexes the file at `pathname` in the directory of `d`.
+func (d *DirectoryInfo) buildIndex(pathname string) (builtIndex, error) {
+	relPath, err := relPathStrict(d.absDir, pathname)
 	if err != nil {
-		return err
+		return builtIndex{}, err
 	}
 
-	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	keyGen, keyIndex := d.getLatestKeyGen()
+
+	docID, err := libsearch.PathnameToDocID(keyGen, relPath, d.getPathnameKey(keyIndex))
 	if err != nil {
-		return err
+		return builtIndex{}, err
 	}
 
-	keyIndex := dirInfo.getLatestKeyIndex()
+	file, err := os.Open(pathname)
+	if err != nil {
+		return builtIndex{}, err
+	}
+	defer file.Close()
 
-	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, dirInfo.getPathnameKey(keyIndex))
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return err
+		return builtIndex{}, err
 	}
 
-	file, err := os.Open(pathname)
+	hash, err := hashFile(file)
+	if err != nil {
+		return builtIndex{}, err
+	}
+
+	indexer := d.getIndexer(keyIndex)
+	var metadata *libsearch.Metadata
+	if indexer.Metadata() {
+		fileMetadata, err := readMetadata(file, fileInfo)
+		if err != nil {
+			return builtIndex{}, err
+		}
+		metadata = &fileMetadata
+	}
+
+	text, textLen, err := extractText(file, fileInfo.Size())
+	if err != nil {
+		return builtIndex{}, err
+	}
+
+	// The text extracted from a compressed document can be longer than the
+	// document itself, and the index is blinded for the longer of the two.
+	paddedLen := fileInfo.Size()
+	if textLen > paddedLen {
+		paddedLen = textLen
+	}
+	secIndex, tfSketch, err := indexer.BuildSecureIndexStreamWithFields(text, relPath, metadata, paddedLen)
+	if err != nil {
+		return builtIndex{}, err
+	}
+
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		return builtIndex{}, err
+	}
+
+	tfSketchBytes, err := tfSketch.MarshalBinary()
+	if err != nil {
+		return builtIndex{}, err
+	}
+
+	return builtIndex{
+		relPath: relPath,
+		item:    sserver1.IndexItem{DocID: docID, SecureIndex: secIndexBytes, TfSketch: tfSketchBytes},
+		entry:   manifestEntry{Hash: hash, Size: fileInfo.Size(), ModTime: fileInfo.ModTime().UnixNano(), KeyGen: keyGen, DocID: docID, Normalization: d.analyzer.Normalization().Version, Extraction: extractionVersion},
+	}, nil
+}
+
+// commitIndex records `built` in the manifest once it has been written to
+// the server.  The index previously written for the file with an older key
+// generation, if any, is deleted.
+func (c *Client) commitIndex(dirInfo *DirectoryInfo, built builtIndex) error {
+	oldEntry, ok, err := dirInfo.manifest.get(built.relPath)
 	if err != nil {
 		return err
 	}
+	if ok && oldEntry.DocID != built.entry.DocID {
+		if err := c.searchCli.DeleteIndex(context.TODO(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: oldEntry.DocID}); err != nil {
+			return err
+		}
+	}
 
-	fileInfo, err := file.Stat()
+	return dirInfo.manifest.put(built.relPath, built.entry)
+}
+
+// AddFile indexes a file in `directory` with the given `pathname`, writes the
+// index to the server and records it in the manifest.  The index previously
+// written for the file with an older key generation, if any, is deleted.
+func (c *Client) AddFile(directory, pathname string) error {
+	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
 		return err
 	}
 
-	secIndex, err := dirInfo.getIndexer(keyIndex).BuildSecureIndex(file, fileInfo.Size())
+	built, err := dirInfo.buildIndex(pathname)
 	if err != nil {
 		return err
 	}
 
-	secIndexBytes, err := secIndex.MarshalBinary()
+	results, err := c.searchCli.WriteIndexes(context.TODO(), sserver1.WriteIndexesArg{TlfID: dirInfo.tlfID, Indexes: []sserver1.IndexItem{built.item}})
 	if err != nil {
 		return err
 	}
+	if len(results) != 1 {
+		return errors.New("unexpected number of results from the server")
+	}
+	if results[0].Error != "" {
+		return errors.New(results[0].Error)
+	}
 
-	return c.searchCli.WriteIndex(context.TODO(), sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndexBytes, DocID: docID})
+	return c.commitIndex(dirInfo, built)
 }
 
 // RenameFile is called when a file in `directory` has been renamed from `orig`
-// to `curr`.  This will rename their corresponding indexes.  Returns an error
-// if the filenames are invalid.
+// to `curr`.  This will rename their corresponding indexes.  If the index of
+// `orig` was built with an older key generation or normalization scheme, or
+// if the words of the pathnames or the metadata, which includes the type given
+// by the extension, are indexed for the TLF, the file is indexed again
+// instead, as it is if `orig` has no index yet.  Returns an error if the
+// filenames are invalid.
 func (c *Client) RenameFile(directory string, orig, curr string) error {
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
@@ -294,23 +460,39 @@ func (c *Client) RenameFile(directory string, orig, curr string) error {
 		return err
 	}
 
-	keyIndex := dirInfo.getLatestKeyIndex()
+	keyGen, keyIndex := dirInfo.getLatestKeyGen()
+
+	entry, ok, err := dirInfo.manifest.get(relOrig)
+	if err != nil {
+		return err
+	}
+	if !ok || dirInfo.tlfInfo.Paths || dirInfo.tlfInfo.Metadata || !entry.isCurrent(keyGen, dirInfo.analyzer.Normalization().Version) {
+		if err := c.DeleteFile(directory, orig); err != nil {
+			return err
+		}
+		return c.AddFile(directory, curr)
+	}
 
-	origDocID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relOrig, dirInfo.getPathnameKey(keyIndex))
+	origDocID, err := libsearch.PathnameToDocID(keyGen, relOrig, dirInfo.getPathnameKey(keyIndex))
 	if err != nil {
 		return err
 	}
 
-	currDocID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relCurr, dirInfo.getPathnameKey(keyIndex))
+	currDocID, err := libsearch.PathnameToDocID(keyGen, relCurr, dirInfo.getPathnameKey(keyIndex))
 	if err != nil {
 		return err
 	}
 
-	return c.searchCli.RenameIndex(context.TODO(), sserver1.RenameIndexArg{TlfID: dirInfo.tlfID, Orig: origDocID, Curr: currDocID})
+	if err := c.searchCli.RenameIndex(context.TODO(), sserver1.RenameIndexArg{TlfID: dirInfo.tlfID, Orig: origDocID, Curr: currDocID}); err != nil {
+		return err
+	}
+
+	entry.DocID = currDocID
+	return dirInfo.manifest.rename(relOrig, relCurr, entry)
 }
 
 // DeleteFile deletes the index on the server associated with `pathname` in
-// `directory`.
+// `directory`, and its entry in the manifest.
 func (c *Client) DeleteFile(directory string, pathname string) error {
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
@@ -322,22 +504,150 @@ func (c *Client) DeleteFile(directory string, pathname string) error {
 		return err
 	}
 
-	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, dirInfo.getPathnameKey(dirInfo.getLatestKeyIndex()))
+	entry, ok, err := dirInfo.manifest.get(relPath)
 	if err != nil {
 		return err
 	}
 
-	return c.searchCli.DeleteIndex(context.Background(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID})
+	docID := entry.DocID
+	if !ok {
+		keyGen, keyIndex := dirInfo.getLatestKeyGen()
+		docID, err = libsearch.PathnameToDocID(keyGen, relPath, dirInfo.getPathnameKey(keyIndex))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.searchCli.DeleteIndex(context.Background(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID}); err != nil {
+		return err
+	}
+
+	return dirInfo.manifest.delete(relPath)
+}
+
+// getIndexersForKeyGens returns the indexers for each of the `keyGens` that
+// this client has the keys for, keyed by the key generations in the form used
+// by the search server.
+func (d *DirectoryInfo) getIndexersForKeyGens(keyGens []int) map[string]*libsearch.SecureIndexBuilder {
+	indexers := make(map[string]*libsearch.SecureIndexBuilder)
+	for _, keyGen := range keyGens {
+		origKeyGen := keyGen
+		if keyGen == int(libkbfs.PublicKeyGen) {
+			keyGen = libkbfs.FirstValidKeyGen
+		}
+		if keyGen < 0 || getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)) > d.getLatestKeyIndex() {
+			continue
+		}
+		indexers[strconv.Itoa(origKeyGen)] = d.getIndexer(getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)))
+	}
+	return indexers
+}
+
+// docIDToFilename decrypts `docID` into the absolute pathname of the file.
+func (d *DirectoryInfo) docIDToFilename(docID sserver1.DocumentID) (string, error) {
+	d.keyGenLock.RLock()
+	defer d.keyGenLock.RUnlock()
+	pathname, err := libsearch.DocIDToPathname(docID, d.pathnameKeys)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.absDir, pathname), nil
+}
+
+// docIDsToFilenames decrypts the `docIDs` into the absolute pathnames of the
+// files, and returns them in sorted order.
+func (d *DirectoryInfo) docIDsToFilenames(docIDs []sserver1.DocumentID) ([]string, error) {
+	filenames := make([]string, len(docIDs))
+	for i, docID := range docIDs {
+		filename, err := d.docIDToFilename(docID)
+		if err != nil {
+			return nil, err
+		}
+		filenames[i] = filename
+	}
+
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// computeQueryTrapdoors computes the trapdoors of the terms of `query` for each
+// of the `keyGens` that this client has the keys for.
+func (d *DirectoryInfo) computeQueryTrapdoors(query *Query, keyGens []int) map[string][]sserver1.Trapdoor {
+	trapdoorMap := make(map[string][]sserver1.Trapdoor)
+	for keyGen, indexer := range d.getIndexersForKeyGens(keyGens) {
+		trapdoors := make([]sserver1.Trapdoor, len(query.terms))
+		for i, term := range query.terms {
+			if isPhrase(term) {
+				// The server requires all the codewords of a trapdoor to
+				// be found, so the biwords of the phrase are joined into a
+				// conjunction.
+				keywords := phraseKeywords(term)
+				var codewords [][]byte
+				for j := 1; j < len(keywords); j++ {
+					codewords = append(codewords, indexer.ComputeBiwordTrapdoors(keywords[j-1], keywords[j])...)
+				}
+				trapdoors[i] = sserver1.Trapdoor{Codeword: codewords}
+			} else if field, keyword, ok := splitFieldTerm(term); ok {
+				trapdoors[i] = sserver1.Trapdoor{Codeword: indexer.ComputeFieldTrapdoors(field, fieldValue(keyword))}
+			} else if isWildcardTerm(term) {
+				trapdoors[i] = sserver1.Trapdoor{Codeword: indexer.ComputePrefixTrapdoors(wildcardPrefix(term))}
+			} else {
+				trapdoors[i] = sserver1.Trapdoor{Codeword: indexer.ComputeKeywordTrapdoors(term)}
+			}
+		}
+		trapdoorMap[keyGen] = trapdoors
+	}
+	return trapdoorMap
+}
+
+// getAnalyzedQuery returns the information of `directory` along with `query`
+// analyzed with the analyzer of the directory.
+func (c *Client) getAnalyzedQuery(directory string, query *Query) (*DirectoryInfo, *Query, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	analyzed, err := query.analyze(dirInfo.analyzer)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, term := range analyzed.terms {
+		if isPhrase(term) {
+			if !dirInfo.tlfInfo.Phrases {
+				return nil, nil, errors.New("phrase search is not enabled for the TLF")
+			}
+		} else if field, _, ok := splitFieldTerm(term); ok {
+			if libsearch.IsField(field) && !dirInfo.tlfInfo.Paths {
+				return nil, nil, errors.New("field search is not enabled for the TLF")
+			}
+			if libsearch.IsMetadataField(field) && !dirInfo.tlfInfo.Metadata {
+				return nil, nil, errors.New("metadata search is not enabled for the TLF")
+			}
+		} else if isWildcardTerm(term) && dirInfo.tlfInfo.MaxPrefixLen == 0 {
+			return nil, nil, errors.New("prefix search is not enabled for the TLF")
+		}
+	}
+	return dirInfo, analyzed, nil
 }
 
 // SearchWord performs a search request on the search server and returns the
-// list of filenames in `directory` possibly containing the `word`.
+// list of filenames in `directory` possibly containing the `word`.  A word
+// with several keywords is searched for as the conjunction of its keywords.
+// If the prefix search is enabled for the TLF, a word with wildcards such as
+// "config*" matches the keywords starting with its prefix.  If the field
+// search is enabled, a word such as "name:invoice" matches the files with the
+// keyword in that field of their pathnames instead.
 // NOTE: False positives are possible.
 func (c *Client) SearchWord(directory, word string) ([]string, error) {
-	dirInfo, err := c.getDirectoryInfo(directory)
+	query := new(Query)
+	query.appendTerm(word)
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
 	if err != nil {
 		return nil, err
 	}
+	if len(analyzed.terms) != 1 || isWildcardTerm(analyzed.terms[0]) || isPhrase(analyzed.terms[0]) || isFieldTerm(analyzed.terms[0]) {
+		return c.searchQuery(dirInfo, analyzed)
+	}
 
 	// TODO: cache the key generations and update when the server notifies the
 	// client of new key generations
@@ -347,16 +657,8 @@ func (c *Client) SearchWord(directory, word string) ([]string, error) {
 	}
 
 	trapdoorMap := make(map[string]sserver1.Trapdoor)
-	for _, keyGen := range keyGens {
-		origKeyGen := keyGen
-		if keyGen == int(libkbfs.PublicKeyGen) {
-			keyGen = libkbfs.FirstValidKeyGen
-		}
-		if keyGen < 0 || getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)) > dirInfo.getLatestKeyIndex() {
-			continue
-		}
-		indexer := dirInfo.getIndexer(getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)))
-		trapdoorMap[strconv.Itoa(origKeyGen)] = sserver1.Trapdoor{Codeword: indexer.ComputeTrapdoors(word)}
+	for keyGen, indexer := range dirInfo.getIndexersForKeyGens(keyGens) {
+		trapdoorMap[keyGen] = sserver1.Trapdoor{Codeword: indexer.ComputeKeywordTrapdoors(analyzed.terms[0])}
 	}
 
 	documents, err := c.searchCli.SearchWord(context.TODO(), sserver1.SearchWordArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMap})
@@ -364,40 +666,158 @@ func (c *Client) SearchWord(directory, word string) ([]string, error) {
 		return nil, err
 	}
 
-	filenames := make([]string, len(documents))
-	for i, docID := range documents {
-		dirInfo.keyGenLock.RLock()
-		pathname, err := libsearch.DocIDToPathname(docID, dirInfo.pathnameKeys)
-		dirInfo.keyGenLock.RUnlock()
+	return dirInfo.docIDsToFilenames(documents)
+}
+
+// SearchWordStrict is similar to `SearchWord`, but it reads the candidate
+// files locally to eliminate the possible false positives.  The files must
+// contain all the keywords of `word`.  If some of the files cannot be read,
+// the verified filenames are returned along with a `*VerificationError`.
+func (c *Client) SearchWordStrict(directory, word string) ([]string, error) {
+	query := new(Query)
+	query.appendTerm(word)
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
+	if err != nil {
+		return nil, err
+	}
+	return c.searchQueryStrict(dirInfo, analyzed)
+}
+
+// SearchQuery performs a search request for the boolean `query` on the search
+// server and returns the list of filenames in `directory` possibly matching
+// the query.  The whole query is evaluated by the server in one round trip.
+// NOTE: False positives are possible.
+func (c *Client) SearchQuery(directory string, query *Query) ([]string, error) {
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
+	if err != nil {
+		return nil, err
+	}
+	return c.searchQuery(dirInfo, analyzed)
+}
+
+// searchQuery performs a search request for the analyzed `query` in the
+// directory of `dirInfo`.
+func (c *Client) searchQuery(dirInfo *DirectoryInfo, query *Query) ([]string, error) {
+	keyGens, err := c.searchCli.GetKeyGens(context.TODO(), dirInfo.tlfID)
+	if err != nil {
+		return nil, err
+	}
+
+	trapdoorMap := dirInfo.computeQueryTrapdoors(query, keyGens)
+	documents, err := c.searchCli.SearchQuery(context.TODO(), sserver1.SearchQueryArg{TlfID: dirInfo.tlfID, Query: query.tokens, Trapdoors: trapdoorMap})
+	if err != nil {
+		return nil, err
+	}
+
+	return dirInfo.docIDsToFilenames(documents)
+}
+
+// SearchQueryStrict is similar to `SearchQuery`, but it reads the candidate
+// files locally and evaluates the query on their contents to eliminate the
+// possible false positives.  If some of the files cannot be read, the verified
+// filenames are returned along with a `*VerificationError`.
+func (c *Client) SearchQueryStrict(directory string, query *Query) ([]string, error) {
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
+	if err != nil {
+		return nil, err
+	}
+	return c.searchQueryStrict(dirInfo, analyzed)
+}
+
+// searchQueryStrict is similar to `searchQuery`, but eliminates the possible
+// false positives as `SearchQueryStrict` does.
+func (c *Client) searchQueryStrict(dirInfo *DirectoryInfo, query *Query) ([]string, error) {
+	files, err := c.searchQuery(dirInfo, query)
+	if err != nil {
+		return nil, err
+	}
+	results, verifyErr := verifyFiles(dirInfo.absDir, files, query.terms, dirInfo.analyzer)
+
+	var filenames []string
+	for _, file := range files {
+		found, ok := results[file]
+		if !ok {
+			continue
+		}
+		matched, err := query.evaluate(func(term string) bool {
+			return found[term]
+		})
 		if err != nil {
 			return nil, err
 		}
-		filenames[i] = filepath.Join(dirInfo.absDir, pathname)
+		if matched {
+			filenames = append(filenames, file)
+		}
 	}
 
-	sort.Strings(filenames)
-	return filenames, nil
+	return filenames, verifyErr
 }
 
-// SearchWordStrict is similar to `SearchWord`, but it uses a `grep` command to
-// eliminate the possible false positives.  The `word` must have an exact match
-// (cases ignored) in the file.
-func (c *Client) SearchWordStrict(directory, word string) ([]string, error) {
-	files, err := c.SearchWord(directory, word)
+// SearchQueryHits is similar to `SearchQueryStrict`, but instead of the bare
+// filenames it returns the lines of each file containing the query terms,
+// along with up to `numContext` lines of context around each of them.  If some
+// of the files cannot be read, the hits of the other files are returned along
+// with a `*VerificationError`.
+func (c *Client) SearchQueryHits(directory string, query *Query, numContext int) ([]Hit, error) {
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
 	if err != nil {
 		return nil, err
 	}
-	args := make([]string, len(files)+2)
-	args[0] = "-ilZw"
-	args[1] = word
-	copy(args[2:], files[:])
-	output, _ := exec.Command("grep", args...).Output()
-	filenames := strings.Split(string(output), "\x00")
-	filenames = filenames[:len(filenames)-1]
 
-	sort.Strings(filenames)
+	files, err := c.searchQueryStrict(dirInfo, analyzed)
+	verifyErr, ok := err.(*VerificationError)
+	if err != nil && !ok {
+		return nil, err
+	}
 
-	return filenames, nil
+	hits, err := findHits(files, analyzed, dirInfo.analyzer, numContext)
+	if hitErr, ok := err.(*VerificationError); ok {
+		if verifyErr == nil {
+			verifyErr = new(VerificationError)
+		}
+		verifyErr.Errors = append(verifyErr.Errors, hitErr.Errors...)
+	}
+	if verifyErr != nil {
+		return hits, verifyErr
+	}
+	return hits, nil
+}
+
+// SearchQueryRanked performs a ranked search request for the boolean `query`
+// on the search server and returns at most `numRerank` files in `directory`
+// matching the query, in decreasing order of relevance.  The server orders the
+// files by the coarse term frequencies in their sketches, then the top
+// `numRerank` files are read locally, verified, and re-ranked by their true
+// TF-IDF scores.  The rest of the files are left out, as they are neither
+// verified nor scored in the same way.  If some of the top files cannot be
+// read, they are left out of the results and reported in a
+// `*VerificationError`.
+func (c *Client) SearchQueryRanked(directory string, query *Query, numRerank int) ([]RankedFile, error) {
+	dirInfo, analyzed, err := c.getAnalyzedQuery(directory, query)
+	if err != nil {
+		return nil, err
+	}
+
+	keyGens, err := c.searchCli.GetKeyGens(context.TODO(), dirInfo.tlfID)
+	if err != nil {
+		return nil, err
+	}
+
+	trapdoorMap := dirInfo.computeQueryTrapdoors(analyzed, keyGens)
+	result, err := c.searchCli.SearchRanked(context.TODO(), sserver1.SearchRankedArg{TlfID: dirInfo.tlfID, Query: analyzed.tokens, Trapdoors: trapdoorMap})
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, len(result.Documents))
+	for i, document := range result.Documents {
+		filenames[i], err = dirInfo.docIDToFilename(document.DocID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rerankFiles(dirInfo.absDir, filenames, analyzed, dirInfo.analyzer, result.DocFrequencies, result.NumDocuments, numRerank)
 }
 
 // updateKeys fetches the new master secrets from `currKeyGen` to `newKeyGen`.
@@ -409,7 +829,7 @@ func (c *Client) updateKeys(dirInfo *DirectoryInfo, newKeyGen, currKeyGen libkbf
 		if err != nil {
 			return
 		}
-		dirInfo.indexers = append(dirInfo.indexers, libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, dirInfo.tlfInfo.Salts, uint64(dirInfo.tlfInfo.Size)))
+		dirInfo.indexers = append(dirInfo.indexers, libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, dirInfo.tlfInfo.Salts, uint64(dirInfo.tlfInfo.Size), dirInfo.analyzer, libsearch.IndexOptionsFromTlfInfo(dirInfo.tlfInfo)))
 		var pathnameKey [32]byte
 		copy(pathnameKey[:], masterSecret[0:32])
 		dirInfo.pathnameKeys = append(dirInfo.pathnameKeys, pathnameKey)
@@ -0,0 +1,419 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/keybase/search/client"
+	"golang.org/x/net/context"
+)
+
+// indexDirectory reconciles the indexes of `clientDir` with its files, or
+// rebuilds all of them if `force` is true.  Stops early if `ctx` is cancelled.
+func indexDirectory(ctx context.Context, cli *client.Client, clientDir string, force bool) indexOutput {
+	started := time.Now()
+	var result client.ReconcileResult
+	var err error
+	if force {
+		result, err = cli.Reindex(ctx, clientDir, *numWorkers)
+	} else {
+		result, err = cli.Reconcile(ctx, clientDir, *numWorkers)
+	}
+
+	output := indexOutput{
+		Directory: clientDir,
+		Started:   started.Format(time.RFC3339),
+		Seconds:   time.Since(started).Seconds(),
+		Written:   result.Written,
+		Renamed:   result.Renamed,
+		Deleted:   result.Deleted,
+		Unchanged: result.Unchanged,
+		Skipped:   make([]skippedOutput, len(result.Skipped)),
+		Errors:    fileErrorOutputs(err),
+	}
+	for i, skipped := range result.Skipped {
+		output.Skipped[i] = skippedOutput{Filename: skipped.Filename, Reason: skipped.Reason}
+	}
+	return output
+}
+
+// periodicIndex reconciles the client directories with the search server
+// every minute, until `ctx` is cancelled.
+func periodicIndex(ctx context.Context, cli *client.Client, clientDirs []string) {
+	for {
+		for _, clientDir := range clientDirs {
+			output := indexDirectory(ctx, cli, clientDir, false)
+			if ctx.Err() != nil {
+				return
+			}
+			printIndexOutput(output, *verbose)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// watchDirectories keeps the indexes of the client directories up to date by
+// watching them for changes, after indexing the files modified since they were
+// last indexed, until `ctx` is cancelled.  A directory is scanned again
+// whenever some of its changes are lost.  Returns the error that stopped any
+// of the watchers.
+func watchDirectories(ctx context.Context, cli *client.Client, clientDirs []string) error {
+	numRunning := 0
+	runErrs := make(chan error, len(clientDirs))
+	var watchers []*client.Watcher
+	defer func() {
+		for _, watcher := range watchers {
+			watcher.Close()
+		}
+		for ; numRunning > 0; numRunning-- {
+			<-runErrs
+		}
+	}()
+
+	for _, clientDir := range clientDirs {
+		clientDir := clientDir
+		reconcile := func() error {
+			output := indexDirectory(ctx, cli, clientDir, false)
+			if ctx.Err() == nil {
+				printIndexOutput(output, *verbose)
+			}
+			return nil
+		}
+		watcher, err := client.CreateWatcher(cli, clientDir, *debounce, reconcile, func(err error) {
+			if skipped, ok := err.(client.SkippedFile); ok {
+				if *verbose {
+					printSkippedOutput(clientDir, skippedOutput{Filename: skipped.Filename, Reason: skipped.Reason})
+				}
+				return
+			}
+			printError("Cannot update the index", err)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot watch directory \"%s\": %s", clientDir, err)
+		}
+		watchers = append(watchers, watcher)
+		numRunning++
+		go func() {
+			if err := watcher.Run(); err != nil {
+				runErrs <- fmt.Errorf("cannot watch directory \"%s\": %s", clientDir, err)
+				return
+			}
+			runErrs <- nil
+		}()
+
+		// The directory is only scanned once it is watched, so that no change
+		// is missed in between.
+		reconcile()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-runErrs:
+		numRunning--
+		if err == nil {
+			err = errors.New("watcher stopped unexpectedly")
+		}
+		return err
+	}
+}
+
+// runDaemon runs the `daemon` command, which keeps the indexes of the client
+// directories up to date until it is interrupted.
+func runDaemon(args []string) int {
+	clientDirs, err := commandDirs(args, false)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		if *gc {
+			for _, clientDir := range clientDirs {
+				numDeleted, err := cli.GarbageCollect(clientDir)
+				if err != nil || *verbose {
+					printDeleteOutput(deleteOutput{Directory: clientDir, Deleted: numDeleted, Errors: fileErrorOutputs(err)}, "garbage collect")
+				}
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		// The indexing in progress is stopped and waited for before the
+		// client is closed.
+		var wg sync.WaitGroup
+		watchErr := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if *watch {
+				watchErr <- watchDirectories(ctx, cli, clientDirs)
+			} else {
+				periodicIndex(ctx, cli, clientDirs)
+			}
+		}()
+
+		code := exitOK
+		select {
+		case <-signals:
+		case err := <-watchErr:
+			printError("Cannot keep the indexes up to date", err)
+			code = exitError
+		}
+		cancel()
+		wg.Wait()
+		return code
+	})
+}
+
+// runIndex runs the `index` command, which indexes the files of the client
+// directories modified since they were last indexed, or all of them if
+// `force` is true for the `reindex` command.
+func runIndex(args []string, force bool) int {
+	clientDirs, err := commandDirs(args, false)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		code := exitOK
+		for _, clientDir := range clientDirs {
+			output := indexDirectory(context.Background(), cli, clientDir, force)
+			printIndexOutput(output, true)
+			code = worseExitCode(code, errorsExitCode(output.Errors))
+		}
+		return code
+	})
+}
+
+// parseQuery parses `input` as a boolean query restricted by `filter`.
+func parseQuery(input string, filter client.Filter) (*client.Query, error) {
+	query, err := client.ParseQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	return client.FilterQuery(query, filter)
+}
+
+// searchInput searches for `input` in the `clientDirs` in the search mode
+// given by the flags: as a single word with up to `max_edits` typos, or as a
+// boolean query restricted by `filter`, with the matching lines if
+// `show_lines` is set and ranked if `ranked` is set.
+// TODO: Parallelize the search on different TLFs for performance optimization.
+func searchInput(cli *client.Client, clientDirs []string, input string, filter client.Filter) searchOutput {
+	output := searchOutput{Query: input, Files: []fileOutput{}, Errors: []errorOutput{}}
+	// addErrors adds the errors of `err` to the output, and returns whether
+	// the search can go on.
+	addErrors := func(err error) bool {
+		if err == nil {
+			return true
+		}
+		output.Errors = append(output.Errors, fileErrorOutputs(err)...)
+		_, ok := err.(*client.VerificationError)
+		return ok
+	}
+
+	if *maxEdits > 0 {
+		for _, clientDir := range clientDirs {
+			files, err := cli.SearchWordFuzzy(clientDir, input, *maxEdits)
+			if !addErrors(err) {
+				return output
+			}
+			for _, file := range files {
+				distance := file.Distance
+				output.Files = append(output.Files, fileOutput{Filename: file.Filename, Directory: clientDir, Keyword: file.Keyword, Distance: &distance})
+			}
+		}
+		return output
+	}
+
+	query, err := parseQuery(input, filter)
+	if err != nil {
+		output.Errors = append(output.Errors, errorOutput{Message: fmt.Sprintf("invalid query: %s", err)})
+		return output
+	}
+	output.Query = query.String()
+	for _, clientDir := range clientDirs {
+		switch {
+		case *showLines:
+			hits, err := cli.SearchQueryHits(clientDir, query, *numContext)
+			if !addErrors(err) {
+				return output
+			}
+			for _, hit := range hits {
+				lines := make([]lineOutput, len(hit.Lines))
+				for i, line := range hit.Lines {
+					lines[i] = lineOutput{Number: line.Number, Text: line.Text, Matches: make([]matchOutput, len(line.Matches))}
+					for j, match := range line.Matches {
+						lines[i].Matches[j] = matchOutput{Start: match[0], End: match[1]}
+					}
+				}
+				output.Files = append(output.Files, fileOutput{Filename: hit.Filename, Directory: clientDir, Lines: lines})
+			}
+		case *ranked:
+			files, err := cli.SearchQueryRanked(clientDir, query, *numRerank)
+			if !addErrors(err) {
+				return output
+			}
+			for _, file := range files {
+				score := file.Score
+				output.Files = append(output.Files, fileOutput{Filename: file.Filename, Directory: clientDir, Score: &score})
+			}
+		default:
+			filenames, err := cli.SearchQueryStrict(clientDir, query)
+			if !addErrors(err) {
+				return output
+			}
+			for _, filename := range filenames {
+				output.Files = append(output.Files, fileOutput{Filename: filename, Directory: clientDir})
+			}
+		}
+	}
+	return output
+}
+
+// isTerminal returns whether `file` is a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runSearch runs the `search` command, which searches for the query given by
+// the arguments, or else for each line of the standard input.
+func runSearch(args []string) int {
+	clientDirs, err := commandDirs(nil, false)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	filter, err := parseFilter()
+	if err != nil {
+		printError("Invalid filter", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		search := func(input string) int {
+			output := searchInput(cli, clientDirs, input, filter)
+			printSearchOutput(output)
+			code := errorsExitCode(output.Errors)
+			if code == exitOK && len(output.Files) == 0 {
+				code = exitNoMatch
+			}
+			return code
+		}
+		if len(args) > 0 {
+			return search(strings.Join(args, " "))
+		}
+
+		prompt := !*jsonOutput && isTerminal(os.Stdin)
+		code := exitOK
+		scanner := bufio.NewScanner(os.Stdin)
+		for {
+			if prompt {
+				fmt.Fprint(os.Stderr, "Please enter a query to search for, using AND, OR, NOT, parentheses, * wildcards, \"quoted phrases\" and name:, path: or ext: fields (Ctrl-D to exit): ")
+			}
+			if !scanner.Scan() {
+				break
+			}
+			if input := strings.TrimSpace(scanner.Text()); input != "" {
+				code = worseExitCode(code, search(input))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			printError("Cannot read the queries", err)
+			return exitError
+		}
+		return code
+	})
+}
+
+// runStatus runs the `status` command, which prints out the status of the
+// indexes of the client directories.
+func runStatus(args []string) int {
+	clientDirs, err := commandDirs(args, false)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		code := exitOK
+		for _, clientDir := range clientDirs {
+			status, err := cli.Status(clientDir)
+			output := statusOutput{
+				Directory:       clientDir,
+				TlfID:           status.TlfID,
+				KeyGen:          int(status.KeyGen),
+				Analyzer:        status.Analyzer,
+				StripDiacritics: status.StripDiacritics,
+				MaxPrefixLen:    status.MaxPrefixLen,
+				Fuzzy:           status.Fuzzy,
+				Phrases:         status.Phrases,
+				Paths:           status.Paths,
+				Metadata:        status.Metadata,
+				Indexed:         status.NumIndexed,
+				Outdated:        status.NumOutdated,
+				Errors:          fileErrorOutputs(err),
+			}
+			printStatusOutput(output)
+			code = worseExitCode(code, errorsExitCode(output.Errors))
+		}
+		return code
+	})
+}
+
+// runGC runs the `gc` command, which deletes the indexes of the files deleted
+// from the client directories.
+func runGC(args []string) int {
+	clientDirs, err := commandDirs(args, false)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		code := exitOK
+		for _, clientDir := range clientDirs {
+			numDeleted, err := cli.GarbageCollect(clientDir)
+			output := deleteOutput{Directory: clientDir, Deleted: numDeleted, Errors: fileErrorOutputs(err)}
+			printDeleteOutput(output, "garbage collect")
+			code = worseExitCode(code, errorsExitCode(output.Errors))
+		}
+		return code
+	})
+}
+
+// runForget runs the `forget` command, which deletes all the indexes of the
+// directories given as arguments.
+func runForget(args []string) int {
+	clientDirs, err := commandDirs(args, true)
+	if err != nil {
+		printError("Invalid arguments", err)
+		return exitError
+	}
+	return withClient(clientDirs, func(cli *client.Client) int {
+		code := exitOK
+		for _, clientDir := range clientDirs {
+			numDeleted, err := cli.Forget(clientDir)
+			output := deleteOutput{Directory: clientDir, Deleted: numDeleted, Errors: fileErrorOutputs(err)}
+			printDeleteOutput(output, "forget")
+			code = worseExitCode(code, errorsExitCode(output.Errors))
+		}
+		return code
+	})
+}
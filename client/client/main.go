@@ -5,139 +5,269 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/keybase/search/client"
+	"github.com/keybase/search/libsearch"
 	"golang.org/x/net/context"
 )
 
 var lenSalt = flag.Int("len_salt", 8, "the length of the salts used to generate the PRFs")
 var fpRate = flag.Float64("fp_rate", 0.000001, "the desired false positive rate for searchable encryption")
+var analyzerID = flag.String("analyzer", libsearch.DefaultAnalyzerID, "the analyzer turning the words into keywords for the TLFs registered by this client, one of \"prose\", \"code\", \"identifier\" and \"cjk\"")
+var stripDiacritics = flag.Bool("strip_diacritics", false, "whether the diacritics are removed from the keywords of the TLFs registered by this client, so that \"café\" matches \"cafe\"")
+var maxPrefixLen = flag.Int("max_prefix_len", 0, "the maximum length of the word prefixes indexed for the prefix and wildcard searches in the TLFs registered by this client, or 0 to disable them")
+var fuzzy = flag.Bool("fuzzy", false, "whether the word trigrams are indexed for the fuzzy searches in the TLFs registered by this client")
+var phrases = flag.Bool("phrases", false, "whether the pairs of adjacent words are indexed for the quoted phrase searches in the TLFs registered by this client")
+var metadata = flag.Bool("metadata", false, "whether the sizes, modification times and types of the files are indexed for the metadata filters in the TLFs registered by this client")
+var paths = flag.Bool("paths", false, "whether the words of the file paths are indexed for the name:, path: and ext: searches in the TLFs registered by this client")
+var maxEdits = flag.Int("max_edits", 0, "if positive, the input is searched for as a single word with up to this many typos, in the TLFs with `fuzzy` set")
 var numUniqWords = flag.Uint64("num_words", uint64(100000), "the expected number of unique words in all the documents within one TLF")
 var clientDirectories = flag.String("client_dirs", "", "the keybase directories for the client where the files should be indexed, separated by ';'")
 var port = flag.Int("port", 8022, "the port that the search server is listening on")
 var ipAddr = flag.String("ip_addr", "127.0.0.1", "the IP address that the search server is listening on")
 var lenMS = flag.Int("len_ms", 64, "the length of the master secret")
+var manifestDir = flag.String("manifest_dir", defaultManifestDir(), "the directory where the manifests of the uploaded indexes are kept")
 var verbose = flag.Bool("v", false, "whether log outputs should be printed out")
+var jsonOutput = flag.Bool("json", false, "whether the output should be printed out as JSON objects, one per line, instead of text")
+var showLines = flag.Bool("show_lines", false, "whether the matching lines should be printed out in a grep-like format instead of the bare filenames")
+var numContext = flag.Int("context", 0, "the number of context lines to print out around each matching line when `show_lines` is set")
+var color = flag.Bool("color", true, "whether the matched words should be highlighted with colors when `show_lines` is set")
+var gc = flag.Bool("gc", false, "whether the indexes of the deleted files should be garbage collected from the search server when the daemon starts")
+var watch = flag.Bool("watch", false, "whether the daemon should watch the client directories for changes instead of scanning them every minute")
+var debounce = flag.Duration("debounce", 500*time.Millisecond, "the quiet period to wait for before indexing the changes when `watch` is set")
+var numWorkers = flag.Int("workers", runtime.NumCPU(), "the number of files indexed concurrently")
+var maxFileSize = flag.Int64("max_file_size", 64<<20, "the maximum size in bytes of the indexed files, or 0 for no maximum")
+var indexBinary = flag.Bool("index_binary", false, "whether the files with binary content are indexed")
+var allowExtensions = flag.String("allow_ext", "", "if set, only the files with these extensions are indexed, separated by ','")
+var denyExtensions = flag.String("deny_ext", "", "the extensions of the files never indexed, separated by ','")
+var useGitignore = flag.Bool("use_gitignore", false, "whether the files matched by the .gitignore files are left out of the indexes along with those matched by the .searchignore files")
+var fileTypes = flag.String("type", "", "if set, only the files of these types are searched for, separated by ',', as extensions such as \"pdf\" or MIME types such as \"image/*\", in the TLFs with `metadata` set")
+var minSize = flag.Int64("min_size", 0, "the minimum size in bytes of the files searched for, in the TLFs with `metadata` set")
+var maxSize = flag.Int64("max_size", 0, "if positive, the maximum size in bytes of the files searched for, in the TLFs with `metadata` set")
+var modifiedAfter = flag.String("modified-after", "", "if set, only the files modified on or after this date, as YYYY-MM-DD, or within this duration, such as \"168h\", are searched for, in the TLFs with `metadata` set")
+var modifiedBefore = flag.String("modified-before", "", "if set, only the files modified before this date, as YYYY-MM-DD, or more than this duration ago, are searched for, in the TLFs with `metadata` set")
+var ranked = flag.Bool("ranked", false, "whether the matching files should be printed out in decreasing order of relevance")
+var numRerank = flag.Int("rerank", 20, "the number of top files to verify, re-rank locally and print out when `ranked` is set")
 
-// addAllFiles adds all the non-hidden files that have been modified after
-// `lastIndexed`.
-func addAllFiles(cli *client.Client, clientDir string, lastIndexed time.Time) filepath.WalkFunc {
-	return func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() && (info.Name()[0] == '.' || info.ModTime().Before(lastIndexed)) {
-			return filepath.SkipDir
-		} else if !info.IsDir() && info.Name()[0] != '.' {
-			if info.ModTime().After(lastIndexed) {
-				cli.AddFile(clientDir, path)
-				if *verbose {
-					fmt.Println("Added:", path)
-				}
-			}
-		}
-		return nil
+// The ANSI escape sequences to highlight the matched words.
+const highlightStart = "\x1b[1;31m"
+const highlightEnd = "\x1b[0m"
+
+// The exit codes of the commands.
+const (
+	exitOK      = 0 // The command succeeded, and the search found some files.
+	exitNoMatch = 1 // The search found no file.
+	exitError   = 2 // The command failed, or was used incorrectly.
+	exitPartial = 3 // The command succeeded except for some of the files, which cannot be indexed or verified.
+)
+
+// exitSeverities ranks the exit codes from the least to the most severe.
+var exitSeverities = map[int]int{exitOK: 0, exitNoMatch: 1, exitPartial: 2, exitError: 3}
+
+// worseExitCode returns the more severe of the exit codes `a` and `b`.
+func worseExitCode(a, b int) int {
+	if exitSeverities[b] > exitSeverities[a] {
+		return b
 	}
+	return a
 }
 
-// periodicAdd scans the files in the client directories every minute and adds
-// the updated files to the search server.
-func periodicAdd(cli *client.Client, clientDirs []string) {
-	for {
-		for _, clientDir := range clientDirs {
-			currTime := time.Now()
-
-			var lastIndexed time.Time
-
-			lastIndexedJSON, err := ioutil.ReadFile(filepath.Join(clientDir, ".search_kbfs_timestamp"))
-			if err == nil {
-				if err := lastIndexed.UnmarshalJSON(lastIndexedJSON); err != nil {
-					panic(fmt.Sprintf("Error when accessing the last indexed timestamp: %s", err))
-				}
-			} else if !os.IsNotExist(err) {
-				panic(fmt.Sprintf("Error when accessing the last indexed timestamp: %s", err))
-			}
-
-			if err := filepath.Walk(clientDir, addAllFiles(cli, clientDir, lastIndexed)); err != nil {
-				panic(fmt.Sprintf("Error when indexing the files: %s", err))
-			}
-
-			currTimeJSON, err := currTime.MarshalJSON()
-			if err != nil {
-				panic(fmt.Sprintf("Error when writing the timestamp: %s", err))
-			}
-			if err := ioutil.WriteFile(filepath.Join(clientDir, ".search_kbfs_timestamp"), currTimeJSON, 0666); err != nil {
-				panic(fmt.Sprintf("Error when writing the timestamp: %s", err))
-			}
-
-			if *verbose {
-				fmt.Printf("\n[%s]: All files under directory \"%s\" indexed in %s\n", currTime.Format("2006-01-02 15:04:05"), clientDir, time.Since(currTime))
-			}
+// errorsExitCode returns the exit code of a command that has run into the
+// `errors`: `exitPartial` if they all concern single files, and `exitError`
+// otherwise.
+func errorsExitCode(errors []errorOutput) int {
+	code := exitOK
+	for _, errOutput := range errors {
+		if errOutput.Filename == "" {
+			return exitError
 		}
-		time.Sleep(time.Second * 60)
+		code = exitPartial
 	}
+	return code
 }
 
-// performSearchWord searches for the word `keyword` on `cli`, and prints out
-// the results.
-// TODO: Parallelize the search on different TLFs for performance optimization.
-func performSearchWord(cli *client.Client, clientDirs []string, keyword string) {
-	var allFiles []string
-	for _, clientDir := range clientDirs {
-		filenames, err := cli.SearchWordStrict(clientDir, keyword)
-		if err != nil {
-			fmt.Printf("Error when searching word %s: %s", keyword, err)
-			return
-		}
-		allFiles = append(allFiles, filenames...)
-	}
-	if len(allFiles) == 0 {
-		fmt.Printf("No file contains the word \"%s\".\n", keyword)
-	} else {
-		fmt.Printf("Files containing the word \"%s\":\n", keyword)
-		for _, filename := range allFiles {
-			fmt.Printf("\t%s\n", filename)
-		}
-	}
-	fmt.Println()
+// command is a subcommand of the client.
+type command struct {
+	args        string                  // The synopsis of the arguments of the command.
+	description string                  // The description of the command printed out by the usage.
+	run         func(args []string) int // The function running the command with its arguments, which returns the exit code.
 }
 
-func main() {
-	flag.Parse()
+// commands are the subcommands of the client, keyed by their names.
+var commands = map[string]command{
+	"daemon":  {"[dir ...]", "keeps the indexes up to date until interrupted, by scanning the directories every minute or watching them with `--watch`", runDaemon},
+	"index":   {"[dir ...]", "indexes the files modified since they were last indexed, and deletes the indexes of the removed files", func(args []string) int { return runIndex(args, false) }},
+	"search":  {"[query]", "searches for the query, or for each line of the standard input if there is none, in the directories of `--client_dirs`", runSearch},
+	"status":  {"[dir ...]", "prints out the options of the TLFs and the numbers of indexed files", runStatus},
+	"reindex": {"[dir ...]", "rebuilds the indexes of all the files, even those that are up to date", func(args []string) int { return runIndex(args, true) }},
+	"gc":      {"[dir ...]", "deletes the indexes of the files that no longer exist, even without a manifest", runGC},
+	"forget":  {"dir ...", "deletes all the indexes of the directories, which are no longer searchable until they are indexed again", runForget},
+}
+
+// commandNames are the names of the subcommands in the order of the usage.
+var commandNames = []string{"daemon", "index", "search", "status", "reindex", "gc", "forget"}
 
+// usage prints out the usage of the client, with its commands and flags.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [flags] [arguments]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, name := range commandNames {
+		fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, commands[name].args, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nThe directories default to those of `--client_dirs`.  The exit code is %d on success, %d if the search found no file, %d on failure and %d if some of the files cannot be indexed or verified.\n\nFlags:\n", exitOK, exitNoMatch, exitError, exitPartial)
+	flag.PrintDefaults()
+}
+
+// commandDirs returns the directories given as the arguments `args` of a
+// command, or else those of the `client_dirs` flag unless `required` is true.
+func commandDirs(args []string, required bool) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	if required {
+		return nil, errors.New("please provide at least one directory")
+	}
 	if *clientDirectories == "" {
-		fmt.Printf("Please provide at least one client directory.\n")
-		os.Exit(1)
+		return nil, errors.New("please provide at least one client directory")
+	}
+	return strings.Split(*clientDirectories, ";"), nil
+}
+
+// withClient creates a client for the `clientDirs` with the options and the
+// index policy given by the flags, runs `f` with it, and closes it.  Returns
+// the exit code returned by `f`, or `exitError` if the client cannot be
+// created.
+func withClient(clientDirs []string, f func(cli *client.Client) int) int {
+	cli, err := client.CreateClient(context.TODO(), *ipAddr, *port, clientDirs, client.ClientOptions{
+		ManifestDir:     *manifestDir,
+		LenMS:           *lenMS,
+		LenSalt:         *lenSalt,
+		FpRate:          *fpRate,
+		NumUniqWords:    *numUniqWords,
+		Analyzer:        *analyzerID,
+		StripDiacritics: *stripDiacritics,
+		Index: libsearch.IndexOptions{
+			MaxPrefixLen: *maxPrefixLen,
+			Fuzzy:        *fuzzy,
+			Phrases:      *phrases,
+			Paths:        *paths,
+			Metadata:     *metadata,
+		},
+		Verbose: *verbose,
+	})
+	if err != nil {
+		printError("Cannot initialize the client", err)
+		return exitError
+	}
+
+	code := exitError
+	policy := parseIndexPolicy()
+	for _, clientDir := range clientDirs {
+		if err = cli.SetIndexPolicy(clientDir, policy); err != nil {
+			printError(fmt.Sprintf("Cannot set the index policy of directory \"%s\"", clientDir), err)
+			break
+		}
+	}
+	if err == nil {
+		code = f(cli)
 	}
 
-	clientDirs := strings.Split(*clientDirectories, ";")
+	if err := cli.Close(); err != nil {
+		printError("Cannot close the client", err)
+		code = worseExitCode(code, exitError)
+	}
+	return code
+}
 
-	// Initiate the search client
-	cli, err := client.CreateClient(context.TODO(), *ipAddr, *port, clientDirs, *lenMS, *lenSalt, *fpRate, *numUniqWords, *verbose)
+// defaultManifestDir returns the directory under the user's config directory
+// where the manifests are kept by default.
+func defaultManifestDir() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(configDir, "keybase_search", "manifests")
+}
+
+// parseTimeFlag parses `value` as a date in the local time zone, or else as a
+// duration before now.  Returns the zero time if `value` is empty.
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	duration, err := time.ParseDuration(value)
 	if err != nil {
-		fmt.Printf("Cannot initialize the client: %s\n", err)
-		os.Exit(1)
+		return time.Time{}, fmt.Errorf("invalid date or duration \"%s\"", value)
 	}
+	return time.Now().Add(-duration), nil
+}
 
-	go periodicAdd(cli, clientDirs)
+// parseFilter returns the metadata filter given by the command line flags.
+// Returns an error if a filter is given along with `max_edits`, as the fuzzy
+// search cannot be filtered.
+func parseFilter() (client.Filter, error) {
+	filter := client.Filter{MinSize: *minSize, MaxSize: *maxSize}
+	if *maxEdits > 0 && (*fileTypes != "" || *minSize > 0 || *maxSize > 0 || *modifiedAfter != "" || *modifiedBefore != "") {
+		return filter, errors.New("the filters cannot be combined with `max_edits`")
+	}
+	if *fileTypes != "" {
+		filter.Types = strings.Split(*fileTypes, ",")
+	}
+	var err error
+	if filter.ModifiedAfter, err = parseTimeFlag(*modifiedAfter); err != nil {
+		return filter, err
+	}
+	if filter.ModifiedBefore, err = parseTimeFlag(*modifiedBefore); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
 
-	reader := bufio.NewReader(os.Stdin)
+// parseIndexPolicy returns the index policy given by the command line flags.
+func parseIndexPolicy() client.IndexPolicy {
+	policy := client.IndexPolicy{MaxFileSize: *maxFileSize, SkipBinary: !*indexBinary, UseGitignore: *useGitignore}
+	if *allowExtensions != "" {
+		policy.AllowExtensions = strings.Split(*allowExtensions, ",")
+	}
+	if *denyExtensions != "" {
+		policy.DenyExtensions = strings.Split(*denyExtensions, ",")
+	}
+	return policy
+}
 
-	for {
-		fmt.Print("Please enter words to search for separated by spaces (enter to exit): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimRight(input, "\n")
-		if input == "" {
-			break
-		}
-		keywords := strings.Split(input, " ")
-		for _, keyword := range keywords {
-			performSearchWord(cli, clientDirs, keyword)
-		}
+// runCommand runs the command named by the first of the `args`, with the
+// flags and the arguments that follow it, and returns its exit code.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitError
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command \"%s\".\n\n", args[0])
+		usage()
+		return exitError
 	}
+
+	// The flags can also follow the command.
+	if err := flag.CommandLine.Parse(args[1:]); err != nil {
+		return exitError
+	}
+	return cmd.run(flag.Args())
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	os.Exit(runCommand(flag.Args()))
 }
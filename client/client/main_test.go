@@ -0,0 +1,137 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestWorseExitCode tests the `worseExitCode` function.  Checks that the exit
+// codes are ranked by severity rather than by value.
+func TestWorseExitCode(t *testing.T) {
+	testCases := []struct {
+		a, b     int
+		expected int
+	}{
+		{exitOK, exitOK, exitOK},
+		{exitOK, exitNoMatch, exitNoMatch},
+		{exitNoMatch, exitPartial, exitPartial},
+		{exitPartial, exitNoMatch, exitPartial},
+		{exitError, exitPartial, exitError},
+		{exitPartial, exitError, exitError},
+		{exitNoMatch, exitOK, exitNoMatch},
+	}
+	for _, testCase := range testCases {
+		if actual := worseExitCode(testCase.a, testCase.b); actual != testCase.expected {
+			t.Fatalf("incorrect exit code for %d and %d: expected %d actual %d", testCase.a, testCase.b, testCase.expected, actual)
+		}
+	}
+}
+
+// TestErrorsExitCode tests the `errorsExitCode` function.  Checks that the
+// errors of single files are partial failures, and that any other error is a
+// failure of the command.
+func TestErrorsExitCode(t *testing.T) {
+	testCases := []struct {
+		errors   []errorOutput
+		expected int
+	}{
+		{nil, exitOK},
+		{[]errorOutput{}, exitOK},
+		{[]errorOutput{{Filename: "/a", Message: "cannot read"}}, exitPartial},
+		{[]errorOutput{{Filename: "/a", Message: "cannot read"}, {Filename: "/b", Message: "cannot read"}}, exitPartial},
+		{[]errorOutput{{Filename: "/a", Message: "cannot read"}, {Message: "server down"}}, exitError},
+		{[]errorOutput{{Message: "server down"}}, exitError},
+	}
+	for i, testCase := range testCases {
+		if actual := errorsExitCode(testCase.errors); actual != testCase.expected {
+			t.Fatalf("incorrect exit code for test case %d: expected %d actual %d", i, testCase.expected, actual)
+		}
+	}
+}
+
+// TestRunCommand tests the `runCommand` function.  Checks that every command
+// is listed by the usage, that the missing and unknown commands are rejected,
+// and that the flags following a command are parsed before it is run.
+func TestRunCommand(t *testing.T) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	listed := append([]string(nil), commandNames...)
+	sort.Strings(listed)
+	if !reflect.DeepEqual(names, listed) {
+		t.Fatalf("commands not listed by the usage: expected %v actual %v", names, listed)
+	}
+
+	if code := runCommand(nil); code != exitError {
+		t.Fatalf("incorrect exit code without a command: expected %d actual %d", exitError, code)
+	}
+	if code := runCommand([]string{"unknown"}); code != exitError {
+		t.Fatalf("incorrect exit code for an unknown command: expected %d actual %d", exitError, code)
+	}
+
+	var buf bytes.Buffer
+	defer func(writer io.Writer, clientDirs string) {
+		jsonWriter = writer
+		*jsonOutput = false
+		*clientDirectories = clientDirs
+	}(jsonWriter, *clientDirectories)
+	jsonWriter = &buf
+	*clientDirectories = ""
+
+	// The directories of `forget` are required, and those of the other
+	// commands default to `--client_dirs`, so each of them fails before the
+	// client is created.
+	for _, name := range []string{"forget", "status", "index", "gc"} {
+		buf.Reset()
+		if code := runCommand([]string{name, "--json"}); code != exitError {
+			t.Fatalf("incorrect exit code for \"%s\" without directories: expected %d actual %d", name, exitError, code)
+		}
+		var output errorsOutput
+		if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+			t.Fatalf("error when decoding the output of \"%s\": %s", name, err)
+		}
+		if output.Type != "errors" || len(output.Errors) != 1 {
+			t.Fatalf("incorrect output for \"%s\" without directories: %s", name, buf.String())
+		}
+	}
+}
+
+// TestParseFilter tests the `parseFilter` function.  Checks that the filters
+// are rejected along with `max_edits`, and accepted otherwise.
+func TestParseFilter(t *testing.T) {
+	defer func() {
+		*maxEdits = 0
+		*minSize = 0
+		*fileTypes = ""
+	}()
+
+	*minSize = 10
+	*fileTypes = "pdf,image/*"
+	filter, err := parseFilter()
+	if err != nil {
+		t.Fatalf("error when parsing the filter: %s", err)
+	}
+	if filter.MinSize != 10 || !reflect.DeepEqual(filter.Types, []string{"pdf", "image/*"}) {
+		t.Fatalf("incorrect filter: %+v", filter)
+	}
+
+	*maxEdits = 1
+	if _, err := parseFilter(); err == nil {
+		t.Fatalf("no error returned for a filter along with `max_edits`")
+	}
+	*minSize = 0
+	*fileTypes = ""
+	if _, err := parseFilter(); err != nil {
+		t.Fatalf("error when parsing the filter: %s", err)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/keybase/search/client"
+)
+
+// The JSON output of the commands is a stream of JSON objects, one per line:
+// one per directory for `index`, `reindex`, `status`, `gc` and `forget`, one
+// per query for `search`, and one per reconciliation, skipped file or batch of
+// errors for `daemon`.  The failures that concern neither a directory nor a
+// query are written as an `errorsOutput` object.  Every object has a `type`
+// field telling which of them it is: "errors" for an `errorsOutput`, "search"
+// for a `searchOutput`, "index" for an `indexOutput`, "skipped" for a
+// `skippedFileOutput`, "status" for a `statusOutput` and "delete" for a
+// `deleteOutput`.  The fields are never removed or renamed, and the arrays are
+// empty rather than null.
+
+// errorOutput is an error in the JSON output.
+type errorOutput struct {
+	Filename string `json:"filename,omitempty"` // The absolute path of the file the error is about, if any.
+	Message  string `json:"message"`            // The error message.
+}
+
+// errorsOutput is the JSON output of the failures that concern neither a
+// directory nor a query.
+type errorsOutput struct {
+	Type   string        `json:"type"`   // Always "errors".
+	Errors []errorOutput `json:"errors"` // The errors that occurred.
+}
+
+// matchOutput is the byte range of a matched word in the text of a line.
+type matchOutput struct {
+	Start int `json:"start"` // The offset of the first byte of the word.
+	End   int `json:"end"`   // The offset of the byte after the word.
+}
+
+// lineOutput is a matching or context line of a file.
+type lineOutput struct {
+	Number  int           `json:"number"`  // The 1-based line number.
+	Text    string        `json:"text"`    // The content of the line, without the line terminator.
+	Matches []matchOutput `json:"matches"` // The matched words of the line, empty for context lines.
+}
+
+// fileOutput is a file matching a search.  Only the fields of the search mode
+// are set.
+type fileOutput struct {
+	Filename  string       `json:"filename"`           // The absolute path of the file.
+	Directory string       `json:"directory"`          // The client directory of the file.
+	Score     *float64     `json:"score,omitempty"`    // The relevance score of the file, with `--ranked`.
+	Keyword   string       `json:"keyword,omitempty"`  // The word of the file closest to the searched word, with `--max_edits`.
+	Distance  *int         `json:"distance,omitempty"` // The edit distance between the keyword and the searched word, with `--max_edits`.
+	Lines     []lineOutput `json:"lines,omitempty"`    // The matching lines of the file and their context lines, with `--show_lines`.
+}
+
+// searchOutput is the JSON output of a search.
+type searchOutput struct {
+	Type   string        `json:"type"`   // Always "search".
+	Query  string        `json:"query"`  // The query as it was understood, or the input if it is invalid.
+	Files  []fileOutput  `json:"files"`  // The matching files, in the order of the search mode within each client directory.
+	Errors []errorOutput `json:"errors"` // The errors of the files that cannot be verified, or of the whole search.
+}
+
+// skippedOutput is a file skipped by the index policy.
+type skippedOutput struct {
+	Filename string `json:"filename"` // The absolute path of the file.
+	Reason   string `json:"reason"`   // The reason why the file is skipped.
+}
+
+// skippedFileOutput is the JSON output of a file skipped by the index policy
+// while a daemon watches its directory.
+type skippedFileOutput struct {
+	Type          string `json:"type"`      // Always "skipped".
+	Directory     string `json:"directory"` // The client directory of the file.
+	skippedOutput        // The file and the reason why it is skipped.
+}
+
+// indexOutput is the JSON output of the indexing of a directory.
+type indexOutput struct {
+	Type      string          `json:"type"`      // Always "index".
+	Directory string          `json:"directory"` // The client directory.
+	Started   string          `json:"started"`   // The time the indexing started at, in RFC 3339 format.
+	Seconds   float64         `json:"seconds"`   // The duration of the indexing in seconds.
+	Written   int             `json:"written"`   // The number of indexes written for new or modified files.
+	Renamed   int             `json:"renamed"`   // The number of indexes renamed for moved files.
+	Deleted   int             `json:"deleted"`   // The number of indexes deleted for removed files.
+	Unchanged int             `json:"unchanged"` // The number of files whose indexes are already up to date.
+	Skipped   []skippedOutput `json:"skipped"`   // The files skipped by the index policy.
+	Errors    []errorOutput   `json:"errors"`    // The errors of the files that cannot be indexed, or of the whole directory.
+}
+
+// statusOutput is the JSON output of the status of a directory.
+type statusOutput struct {
+	Type            string        `json:"type"`             // Always "status".
+	Directory       string        `json:"directory"`        // The client directory.
+	TlfID           string        `json:"tlf_id"`           // The TLF ID of the directory.
+	KeyGen          int           `json:"key_gen"`          // The latest key generation of the directory.
+	Analyzer        string        `json:"analyzer"`         // The ID of the analyzer of the TLF.
+	StripDiacritics bool          `json:"strip_diacritics"` // Whether the diacritics are removed from the keywords.
+	MaxPrefixLen    int           `json:"max_prefix_len"`   // The maximum length of the indexed word prefixes, or 0.
+	Fuzzy           bool          `json:"fuzzy"`            // Whether the word trigrams are indexed.
+	Phrases         bool          `json:"phrases"`          // Whether the pairs of adjacent words are indexed.
+	Paths           bool          `json:"paths"`            // Whether the words of the pathnames are indexed.
+	Metadata        bool          `json:"metadata"`         // Whether the metadata of the files are indexed.
+	Indexed         int           `json:"indexed"`          // The number of indexed files.
+	Outdated        int           `json:"outdated"`         // The number of indexed files whose indexes are rebuilt by the next indexing.
+	Errors          []errorOutput `json:"errors"`           // The error of the directory, if any.
+}
+
+// deleteOutput is the JSON output of `gc` and `forget` for a directory.
+type deleteOutput struct {
+	Type      string        `json:"type"`      // Always "delete".
+	Directory string        `json:"directory"` // The client directory.
+	Deleted   int           `json:"deleted"`   // The number of indexes deleted.
+	Errors    []errorOutput `json:"errors"`    // The error of the directory, if any.
+}
+
+// outputLock serializes the output of the concurrent indexing of a daemon.
+var outputLock sync.Mutex
+
+// jsonWriter is where the JSON output is written to, the standard output
+// unless it is replaced by the tests.
+var jsonWriter io.Writer = os.Stdout
+
+// writeJSON writes `v` as a single line of JSON to `jsonWriter`.
+func writeJSON(v interface{}) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if err := json.NewEncoder(jsonWriter).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write the output: %s\n", err)
+	}
+}
+
+// printError prints out `err`, either as an `errorsOutput` object in JSON or
+// as a line on the standard error, after `prefix`.
+func printError(prefix string, err error) {
+	if *jsonOutput {
+		writeJSON(errorsOutput{Type: "errors", Errors: []errorOutput{{Message: fmt.Sprintf("%s: %s", prefix, err)}}})
+		return
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, err)
+}
+
+// fileErrorOutputs returns the errors of the files among `err`, which can be
+// a `*client.VerificationError`, a `*client.ReconcileError` or another error.
+// Returns an empty slice if `err` is nil.
+func fileErrorOutputs(err error) []errorOutput {
+	var fileErrs []client.FileError
+	switch err := err.(type) {
+	case nil:
+		return []errorOutput{}
+	case *client.VerificationError:
+		fileErrs = err.Errors
+	case *client.ReconcileError:
+		fileErrs = err.Errors
+	default:
+		return []errorOutput{{Message: err.Error()}}
+	}
+	outputs := make([]errorOutput, len(fileErrs))
+	for i, fileErr := range fileErrs {
+		outputs[i] = errorOutput{Filename: fileErr.Filename, Message: fileErr.Err.Error()}
+	}
+	return outputs
+}
+
+// printErrorOutputs prints out the `errors` on the standard error, each after
+// `prefix`.
+func printErrorOutputs(prefix string, errors []errorOutput) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	for _, errOutput := range errors {
+		if errOutput.Filename != "" {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", prefix, errOutput.Filename, errOutput.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, errOutput.Message)
+		}
+	}
+}
+
+// printSearchOutput prints out `output` in JSON, or else in the text format of
+// the search mode, with the errors on the standard error.
+func printSearchOutput(output searchOutput) {
+	if *jsonOutput {
+		output.Type = "search"
+		writeJSON(output)
+		return
+	}
+	printErrorOutputs("Cannot search", output.Errors)
+	if len(output.Files) == 0 && len(output.Errors) == 0 {
+		fmt.Fprintf(os.Stderr, "No file matches the query %s.\n", output.Query)
+	}
+	for _, file := range output.Files {
+		switch {
+		case file.Distance != nil:
+			fmt.Printf("%d  %-20s  %s\n", *file.Distance, file.Keyword, file.Filename)
+		case file.Score != nil:
+			fmt.Printf("%8.3f  %s\n", *file.Score, file.Filename)
+		case file.Lines != nil:
+			printLines(file.Filename, file.Lines)
+		default:
+			fmt.Println(file.Filename)
+		}
+	}
+}
+
+// printLines prints out the `lines` of the file at `filename` in a grep-like
+// format, with ":" after the line numbers of matching lines, "-" after those
+// of context lines, and "--" between non-adjacent groups of lines.
+func printLines(filename string, lines []lineOutput) {
+	prevNumber := 0
+	for _, line := range lines {
+		if prevNumber > 0 && line.Number > prevNumber+1 {
+			fmt.Println("--")
+		}
+		prevNumber = line.Number
+		if len(line.Matches) == 0 {
+			fmt.Printf("%s-%d-%s\n", filename, line.Number, line.Text)
+			continue
+		}
+		text := line.Text
+		if *color {
+			matches := make([][2]int, len(line.Matches))
+			for i, match := range line.Matches {
+				matches[i] = [2]int{match.Start, match.End}
+			}
+			text = client.Line{Text: line.Text, Matches: matches}.Highlight(highlightStart, highlightEnd)
+		}
+		fmt.Printf("%s:%d:%s\n", filename, line.Number, text)
+	}
+}
+
+// printIndexOutput prints out `output` in JSON, or else the errors on the
+// standard error, along with the skipped files and a summary if `summary` is
+// true.
+func printIndexOutput(output indexOutput, summary bool) {
+	if *jsonOutput {
+		output.Type = "index"
+		writeJSON(output)
+		return
+	}
+	printErrorOutputs("Cannot index", output.Errors)
+	if !summary {
+		return
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if *verbose {
+		for _, skipped := range output.Skipped {
+			fmt.Printf("Skipped %s: %s\n", skipped.Filename, skipped.Reason)
+		}
+	}
+	fmt.Printf("[%s]: Directory \"%s\" indexed in %.3fs (%d written, %d renamed, %d deleted, %d unchanged, %d skipped)\n", output.Started, output.Directory, output.Seconds, output.Written, output.Renamed, output.Deleted, output.Unchanged, len(output.Skipped))
+}
+
+// printSkippedOutput prints out `skipped`, a file of `clientDir` skipped by the
+// index policy, in JSON or else as text.
+func printSkippedOutput(clientDir string, skipped skippedOutput) {
+	if *jsonOutput {
+		writeJSON(skippedFileOutput{Type: "skipped", Directory: clientDir, skippedOutput: skipped})
+		return
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	fmt.Printf("Skipped %s: %s\n", skipped.Filename, skipped.Reason)
+}
+
+// printStatusOutput prints out `output` in JSON or else as text.
+func printStatusOutput(output statusOutput) {
+	if *jsonOutput {
+		output.Type = "status"
+		writeJSON(output)
+		return
+	}
+	if len(output.Errors) > 0 {
+		printErrorOutputs(fmt.Sprintf("Cannot get the status of directory \"%s\"", output.Directory), output.Errors)
+		return
+	}
+	features := []string{}
+	if output.StripDiacritics {
+		features = append(features, "diacritics stripped")
+	}
+	if output.MaxPrefixLen > 0 {
+		features = append(features, fmt.Sprintf("prefixes up to %d characters", output.MaxPrefixLen))
+	}
+	for _, feature := range []struct {
+		enabled bool
+		name    string
+	}{{output.Fuzzy, "fuzzy"}, {output.Phrases, "phrases"}, {output.Paths, "paths"}, {output.Metadata, "metadata"}} {
+		if feature.enabled {
+			features = append(features, feature.name)
+		}
+	}
+	fmt.Printf("%s\n", output.Directory)
+	fmt.Printf("\tTLF ID:          %s\n", output.TlfID)
+	fmt.Printf("\tkey generation:  %d\n", output.KeyGen)
+	fmt.Printf("\tanalyzer:        %s\n", output.Analyzer)
+	if len(features) == 0 {
+		features = append(features, "none")
+	}
+	fmt.Printf("\tfeatures:        %s\n", strings.Join(features, ", "))
+	fmt.Printf("\tindexed files:   %d (%d outdated)\n", output.Indexed, output.Outdated)
+}
+
+// printDeleteOutput prints out `output` in JSON or else as text, with `verb`
+// describing the deletion.
+func printDeleteOutput(output deleteOutput, verb string) {
+	if *jsonOutput {
+		output.Type = "delete"
+		writeJSON(output)
+		return
+	}
+	if len(output.Errors) > 0 {
+		printErrorOutputs(fmt.Sprintf("Cannot %s directory \"%s\"", verb, output.Directory), output.Errors)
+		return
+	}
+	fmt.Printf("%s: %d index(es) deleted\n", output.Directory, output.Deleted)
+}
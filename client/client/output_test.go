@@ -0,0 +1,60 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/search/client"
+)
+
+// TestJSONOutput tests the JSON output of the print functions.  Checks that
+// each object is written on its own line with its `type`, that the empty
+// arrays are not null, and that the fields of the other search modes are left
+// out.
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(writer io.Writer) {
+		jsonWriter = writer
+		*jsonOutput = false
+	}(jsonWriter)
+	jsonWriter = &buf
+	*jsonOutput = true
+
+	score := 1.5
+	printError("Cannot search", errors.New("server down"))
+	printSearchOutput(searchOutput{Query: "word", Files: []fileOutput{{Filename: "/dir/a", Directory: "/dir", Score: &score}}, Errors: []errorOutput{}})
+	printIndexOutput(indexOutput{Directory: "/dir", Skipped: []skippedOutput{{Filename: "/dir/b", Reason: "binary"}}, Errors: fileErrorOutputs(nil)}, true)
+	printSkippedOutput("/dir", skippedOutput{Filename: "/dir/c", Reason: "too large"})
+	printStatusOutput(statusOutput{Directory: "/dir", TlfID: "tlf", Errors: []errorOutput{}})
+	printDeleteOutput(deleteOutput{Directory: "/dir", Deleted: 2, Errors: fileErrorOutputs(&client.ReconcileError{Errors: []client.FileError{{Filename: "/dir/d", Err: errors.New("cannot delete")}}})}, "forget")
+
+	expected := []map[string]interface{}{
+		{"type": "errors", "errors": []interface{}{map[string]interface{}{"message": "Cannot search: server down"}}},
+		{"type": "search", "query": "word", "files": []interface{}{map[string]interface{}{"filename": "/dir/a", "directory": "/dir", "score": 1.5}}, "errors": []interface{}{}},
+		{"type": "index", "directory": "/dir", "started": "", "seconds": 0.0, "written": 0.0, "renamed": 0.0, "deleted": 0.0, "unchanged": 0.0, "skipped": []interface{}{map[string]interface{}{"filename": "/dir/b", "reason": "binary"}}, "errors": []interface{}{}},
+		{"type": "skipped", "directory": "/dir", "filename": "/dir/c", "reason": "too large"},
+		{"type": "status", "directory": "/dir", "tlf_id": "tlf", "key_gen": 0.0, "analyzer": "", "strip_diacritics": false, "max_prefix_len": 0.0, "fuzzy": false, "phrases": false, "paths": false, "metadata": false, "indexed": 0.0, "outdated": 0.0, "errors": []interface{}{}},
+		{"type": "delete", "directory": "/dir", "deleted": 2.0, "errors": []interface{}{map[string]interface{}{"filename": "/dir/d", "message": "cannot delete"}}},
+	}
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != len(expected) {
+		t.Fatalf("incorrect number of output lines: expected %d actual %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		var actual map[string]interface{}
+		if err := json.Unmarshal(line, &actual); err != nil {
+			t.Fatalf("error when decoding the output line %s: %s", line, err)
+		}
+		if !reflect.DeepEqual(expected[i], actual) {
+			t.Fatalf("incorrect output: expected %v actual %v", expected[i], actual)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package client
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -12,67 +13,23 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
 	sserver1 "github.com/keybase/search/protocol/sserver"
+	"github.com/keybase/search/server"
 	"golang.org/x/net/context"
 )
 
-// FakeServerClient implements a fake SearchServerInterface.
-type FakeServerClient struct {
-	docIDs      []sserver1.DocumentID // The list of document IDs added.
-	searchCount int                   // The number of times `SearchWord` has been called.  Needed to return the expected results.
-}
-
-func (c *FakeServerClient) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) error {
-	c.docIDs = append(c.docIDs, arg.DocID)
-	return nil
-}
-
-func (c *FakeServerClient) RenameIndex(_ context.Context, arg sserver1.RenameIndexArg) error {
-	for i, docID := range c.docIDs {
-		if docID == arg.Orig {
-			c.docIDs[i] = arg.Curr
-		}
-	}
-	return nil
-}
-
-func (c *FakeServerClient) DeleteIndex(_ context.Context, arg sserver1.DeleteIndexArg) error {
-	for i, docID := range c.docIDs {
-		if docID == arg.DocID {
-			c.docIDs = append(c.docIDs[:i], c.docIDs[i+1:]...)
-		}
-	}
-	return nil
-}
-
-func (c *FakeServerClient) GetKeyGens(_ context.Context, _ sserver1.FolderID) ([]int, error) {
-	return []int{1}, nil
-}
-
-func (c *FakeServerClient) SearchWord(_ context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
-	c.searchCount++
-	if c.searchCount == 1 {
-		expected := []sserver1.DocumentID{c.docIDs[1], c.docIDs[3]}
-		return expected, nil
-	} else if c.searchCount == 2 {
-		return nil, nil
-	} else {
-		return c.docIDs, nil
-	}
-}
-
-func (c *FakeServerClient) RegisterTlfIfNotExists(_ context.Context, _ sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
-	return sserver1.TlfInfo{Salts: nil, Size: 10000}, nil
-}
-
 // startTestClient creates an instance of a test client and returns a pointer to
 // the instance, as well as the name of the client's temporary directory.  Need
 // to later manually clean up the directory.  If `dir` is set, initializes the
-// client at `dir` instead of creating a temporary directory
-func startTestClient(t *testing.T, cliDir string) (*Client, string) {
+// client at `dir` instead of creating a temporary directory.  If `searchCli`
+// is nil, a new in-memory search server is created for the client.
+func startTestClient(t testing.TB, cliDir string, searchCli sserver1.SearchServerInterface) (*Client, string) {
 	var err error
 	if cliDir == "" {
 		cliDir, err = ioutil.TempDir("", "TestClient")
@@ -93,9 +50,18 @@ func startTestClient(t *testing.T, cliDir string) (*Client, string) {
 		t.Fatalf("error when writing the TLF status: %s", err)
 	}
 
-	searchCli := &FakeServerClient{docIDs: make([]sserver1.DocumentID, 0, 5)}
+	if searchCli == nil {
+		searchCli = server.CreateMemoryServer()
+	}
 
-	cli, err := createClientWithClient(context.Background(), searchCli, []string{cliDir}, 64, 8, 0.000001, 1000)
+	// Each client gets its own manifest, which is cleaned up along with the
+	// client directory.
+	manifestDir, err := ioutil.TempDir(cliDir, ".manifest")
+	if err != nil {
+		t.Fatalf("error when creating the manifest directory: %s", err)
+	}
+
+	cli, err := createClientWithClient(context.Background(), searchCli, []string{cliDir}, ClientOptions{ManifestDir: manifestDir, LenMS: 64, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Analyzer: libsearch.DefaultAnalyzerID})
 	if err != nil {
 		t.Fatalf("Error when creating the client: %s", err)
 	}
@@ -107,9 +73,10 @@ func startTestClient(t *testing.T, cliDir string) (*Client, string) {
 // be successfully created and that two clients have the same `indexer` and
 // `pathnameKey` if created with the same master secret.
 func TestCreateClient(t *testing.T) {
-	client1, dir := startTestClient(t, "")
-	defer os.Remove(dir)
-	client2, _ := startTestClient(t, dir)
+	searchCli := server.CreateMemoryServer()
+	client1, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	client2, _ := startTestClient(t, dir, searchCli)
 
 	if !reflect.DeepEqual(client1.directoryInfos[dir].indexers[0].ComputeTrapdoors("test"), client2.directoryInfos[dir].indexers[0].ComputeTrapdoors("test")) {
 		t.Fatalf("clients with different indexer created with the same master secret")
@@ -123,7 +90,7 @@ func TestCreateClient(t *testing.T) {
 // written by the server, and that errors are properly returned when the file is
 // not valid.
 func TestAddFile(t *testing.T) {
-	client, dir := startTestClient(t, "")
+	client, dir := startTestClient(t, "", nil)
 	defer os.RemoveAll(dir)
 
 	content := "This is a random test string, it is quite long, or not really"
@@ -151,9 +118,10 @@ func TestAddFile(t *testing.T) {
 }
 
 // TestRenameFile tests the `RenameFile` function.  Checks the indexes are
-// properly renamed and errors returned when necessary.
+// properly renamed, that a file without an index is indexed under its new
+// path, and errors returned when necessary.
 func TestRenameFile(t *testing.T) {
-	client, dir := startTestClient(t, "")
+	client, dir := startTestClient(t, "", nil)
 	defer os.RemoveAll(dir)
 
 	content := "a random content"
@@ -165,21 +133,39 @@ func TestRenameFile(t *testing.T) {
 		t.Fatalf("error when adding the file: %s", err)
 	}
 
+	if err := os.Rename(filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
 	if err := client.RenameFile(dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
 		t.Fatalf("error when renaming file: %s", err)
 	}
 
-	// Doing the renaming second time should still succeed, even though nothing
-	// real has been done.
+	// Doing the renaming second time should still succeed, the file being
+	// indexed again under its new path.
 	if err := client.RenameFile(dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
 		t.Fatalf("error when renaming a non-existing file: %s", err)
 	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "testUnindexed"), []byte("an unindexed content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client.RenameFile(dir, filepath.Join(dir, "testNeverIndexed"), filepath.Join(dir, "testUnindexed")); err != nil {
+		t.Fatalf("error when renaming a file without an index: %s", err)
+	}
+	expected := []string{filepath.Join(dir, "testUnindexed")}
+	actual, err := client.SearchWord(dir, "unindexed")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
 }
 
 // TestDeleteFile tests the `DeleteFile` function.  Checks the indexes are
 // properly deleted and errors returned when necessary.
 func TestDeleteFile(t *testing.T) {
-	client, dir := startTestClient(t, "")
+	client, dir := startTestClient(t, "", nil)
 	defer os.RemoveAll(dir)
 
 	content := "a random content"
@@ -204,7 +190,7 @@ func TestDeleteFile(t *testing.T) {
 // testSearchWordHelper tests the provided 'searchFunc' function.  Checks that
 // the correct set of filenames are returned.
 func testSearchWordHelper(t *testing.T, searchFunc func(*Client, string, string) ([]string, error)) {
-	client, dir := startTestClient(t, "")
+	client, dir := startTestClient(t, "", nil)
 	defer os.RemoveAll(dir)
 
 	contents := []string{
@@ -276,3 +262,712 @@ func TestSearchWord(t *testing.T) {
 func TestSearchWordStrict(t *testing.T) {
 	testSearchWordHelper(t, searchWordStrictWrapper)
 }
+
+// testSearchQueryHelper tests the provided 'searchFunc' function.  Checks that
+// the correct set of filenames are returned for boolean queries.
+func testSearchQueryHelper(t *testing.T, searchFunc func(*Client, string, *Query) ([]string, error)) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+
+	contents := []string{
+		"The quick brown fox",
+		"The lazy brown dog",
+		"A quick red fox",
+		"A lazy red cat",
+	}
+	filenames := make([]string, len(contents))
+
+	for i, fileContent := range contents {
+		filenames[i] = filepath.Join(dir, "testQueryFile"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filenames[i], []byte(fileContent), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(dir, filenames[i]); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{"quick fox", []string{filenames[0], filenames[2]}},
+		{"brown AND NOT fox", []string{filenames[1]}},
+		{"dog OR cat", []string{filenames[1], filenames[3]}},
+		{"(lazy OR quick) AND red", []string{filenames[2], filenames[3]}},
+		{"NOT the", []string{filenames[2], filenames[3]}},
+		{"quick AND lazy", nil},
+	}
+	for _, testCase := range testCases {
+		query, err := ParseQuery(testCase.input)
+		if err != nil {
+			t.Fatalf("error when parsing query: %s", err)
+		}
+		actual, err := searchFunc(client, dir, query)
+		if err != nil {
+			t.Fatalf("error when searching query: %s", err)
+		}
+		if len(testCase.expected) == 0 && len(actual) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(testCase.expected, actual) {
+			t.Fatalf("incorrect search result for \"%s\": expected \"%s\" actual \"%s\"", testCase.input, testCase.expected, actual)
+		}
+	}
+}
+
+// TestSearchQuery tests the 'SearchQuery' function.  Checks that the correct
+// set of filenames are returned.
+func TestSearchQuery(t *testing.T) {
+	testSearchQueryHelper(t, (*Client).SearchQuery)
+}
+
+// TestSearchQueryStrict tests the 'SearchQueryStrict' function.  Checks that
+// the correct set of filenames are returned.
+func TestSearchQueryStrict(t *testing.T) {
+	testSearchQueryHelper(t, (*Client).SearchQueryStrict)
+}
+
+// TestSearchQueryHits tests the 'SearchQueryHits' function.  Checks that the
+// hits are returned for the verified files only, with the right lines.
+func TestSearchQueryHits(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+
+	contents := []string{
+		"first line\nsecond line with keyword\nthird line",
+		"no match here\nnothing",
+	}
+	for i, fileContent := range contents {
+		filename := filepath.Join(dir, "testHitsFile"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filename, []byte(fileContent), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	query, err := ParseQuery("keyword")
+	if err != nil {
+		t.Fatalf("error when parsing query: %s", err)
+	}
+	hits, err := client.SearchQueryHits(dir, query, 1)
+	if err != nil {
+		t.Fatalf("error when searching query: %s", err)
+	}
+	if len(hits) != 1 || hits[0].Filename != filepath.Join(dir, "testHitsFile0") {
+		t.Fatalf("incorrect hits: %v", hits)
+	}
+	if len(hits[0].Lines) != 3 || hits[0].Lines[1].Number != 2 || len(hits[0].Lines[1].Matches) != 1 {
+		t.Fatalf("incorrect lines in hit: %v", hits[0].Lines)
+	}
+}
+
+// TestSearchQueryRanked tests the 'SearchQueryRanked' function.  Checks that
+// the files are returned in decreasing order of relevance, and that only the
+// re-ranked files are returned.
+func TestSearchQueryRanked(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+
+	contents := []string{
+		"a fox",
+		"a fox and another fox and yet another fox and the last fox",
+		"a dog",
+		"a fox and another fox",
+	}
+	filenames := make([]string, len(contents))
+	for i, fileContent := range contents {
+		filenames[i] = filepath.Join(dir, "testRankedFile"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filenames[i], []byte(fileContent), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(dir, filenames[i]); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	query, err := ParseQuery("fox")
+	if err != nil {
+		t.Fatalf("error when parsing query: %s", err)
+	}
+	expected := []string{filenames[1], filenames[3], filenames[0]}
+	for _, numRerank := range []int{0, 1, 2, 10} {
+		files, err := client.SearchQueryRanked(dir, query, numRerank)
+		if err != nil {
+			t.Fatalf("error when searching query: %s", err)
+		}
+		actual := make([]string, len(files))
+		for i, file := range files {
+			actual[i] = file.Filename
+		}
+		top := expected
+		if numRerank < len(top) {
+			top = top[:numRerank]
+		}
+		if !reflect.DeepEqual(top, actual) {
+			t.Fatalf("incorrect ranking with %d re-ranked files: expected \"%s\" actual \"%s\"", numRerank, top, actual)
+		}
+	}
+}
+
+// TestSearchWithCodeAnalyzer tests the searches in a TLF registered with the
+// code analyzer.  Checks that the client picks up the analyzer of the existing
+// TLF, and that the identifiers can be found by their parts.
+func TestSearchWithCodeAnalyzer(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Analyzer: libsearch.CodeAnalyzerID}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	filename := filepath.Join(dir, "code.go")
+	if err := ioutil.WriteFile(filename, []byte("func parseHTTPRequest(max_body_size int) {}"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client.AddFile(dir, filename); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+
+	for _, word := range []string{"parseHTTPRequest", "request", "body", "maxBodySize", "int"} {
+		actual, err := client.SearchWordStrict(dir, word)
+		if err != nil {
+			t.Fatalf("error when searching word \"%s\": %s", word, err)
+		}
+		if !reflect.DeepEqual(actual, []string{filename}) {
+			t.Fatalf("incorrect search result for \"%s\": %v", word, actual)
+		}
+	}
+
+	query, err := ParseQuery("http AND NOT response")
+	if err != nil {
+		t.Fatalf("error when parsing query: %s", err)
+	}
+	hits, err := client.SearchQueryHits(dir, query, 0)
+	if err != nil {
+		t.Fatalf("error when searching query: %s", err)
+	}
+	if len(hits) != 1 || len(hits[0].Lines) != 1 || !reflect.DeepEqual(hits[0].Lines[0].Matches, [][2]int{{5, 35}}) {
+		t.Fatalf("incorrect hits: %v", hits)
+	}
+}
+
+// TestSearchWithCJKAnalyzer tests the searches in a TLF registered with the
+// CJK analyzer.  Checks that the words of the scripts written without spaces
+// can be found by any part of a sentence, but not by a sequence of characters
+// that does not appear in it.
+func TestSearchWithCJKAnalyzer(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Analyzer: libsearch.CJKAnalyzerID}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	chinese := filepath.Join(dir, "chinese")
+	if err := ioutil.WriteFile(chinese, []byte("我们在KBFS里搜索文件。"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	japanese := filepath.Join(dir, "japanese")
+	if err := ioutil.WriteFile(japanese, []byte("東京都に住んでいます"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{chinese, japanese} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testCases := map[string][]string{
+		"搜索文件": {chinese},
+		"kbfs": {chinese},
+		"文搜":   nil,
+		"住んで":  {japanese},
+		"東京":   {japanese},
+		"京":    {japanese},
+	}
+	for word, expected := range testCases {
+		actual, err := client.SearchWordStrict(dir, word)
+		if err != nil {
+			t.Fatalf("error when searching word \"%s\": %s", word, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect search result for \"%s\": expected %v actual %v", word, expected, actual)
+		}
+	}
+
+	query, err := ParseQuery("東京 AND NOT 大阪")
+	if err != nil {
+		t.Fatalf("error when parsing query: %s", err)
+	}
+	hits, err := client.SearchQueryHits(dir, query, 0)
+	if err != nil {
+		t.Fatalf("error when searching query: %s", err)
+	}
+	if len(hits) != 1 || hits[0].Filename != japanese {
+		t.Fatalf("incorrect hits: %v", hits)
+	}
+}
+
+// TestSearchPrefix tests the prefix searches.  Checks that the words with
+// wildcards are found in a TLF registered with prefixes, but are rejected in
+// a TLF registered without them.
+func TestSearchPrefix(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, MaxPrefixLen: 4}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	config := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(config, []byte("The configuration of the server"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	conform := filepath.Join(dir, "conform")
+	if err := ioutil.WriteFile(conform, []byte("Conform to the configs"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{config, conform} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testCases := map[string][]string{
+		"conf*":      {config, conform},
+		"config*":    {config, conform},
+		"configu*":   {config},
+		"con*ion":    {config},
+		"serv*":      {config},
+		"config":     nil,
+		"configure*": nil,
+	}
+	for word, expected := range testCases {
+		actual, err := client.SearchWordStrict(dir, word)
+		if err != nil {
+			t.Fatalf("error when searching word \"%s\": %s", word, err)
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect search result for \"%s\": expected %v actual %v", word, expected, actual)
+		}
+	}
+
+	// The unverified search only uses the truncated prefix.
+	actual, err := client.SearchWord(dir, "configure*")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(actual, []string{config, conform}) {
+		t.Fatalf("incorrect unverified search result: %v", actual)
+	}
+
+	noPrefixClient, noPrefixDir := startTestClient(t, "", nil)
+	defer os.RemoveAll(noPrefixDir)
+	defer noPrefixClient.Close()
+	if _, err := noPrefixClient.SearchWord(noPrefixDir, "conf*"); err == nil {
+		t.Fatalf("no error returned for a prefix search without prefixes")
+	}
+}
+
+// TestSearchPhrase tests the phrase searches.  Checks that the unverified
+// search finds the files with all the biwords of a phrase, that the strict
+// search only keeps the files with the whole phrase, and that the phrases are
+// rejected in a TLF registered without biwords.
+func TestSearchPhrase(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Phrases: true}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	phrase := filepath.Join(dir, "phrase")
+	if err := ioutil.WriteFile(phrase, []byte("The quick\nbrown fox jumps"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	apart := filepath.Join(dir, "apart")
+	if err := ioutil.WriteFile(apart, []byte("quick brown dogs and a brown fox"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	reversed := filepath.Join(dir, "reversed")
+	if err := ioutil.WriteFile(reversed, []byte("brown quick fox"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{phrase, apart, reversed} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		input      string
+		unverified []string
+		strict     []string
+	}{
+		{"\"Quick Brown fox\"", []string{apart, phrase}, []string{phrase}},
+		{"\"brown fox\" NOT jumps", []string{apart}, []string{apart}},
+		{"\"fox\"", []string{apart, phrase, reversed}, []string{apart, phrase, reversed}},
+		{"\"fox brown\"", []string{}, nil},
+	}
+	for _, testCase := range testCases {
+		query, err := ParseQuery(testCase.input)
+		if err != nil {
+			t.Fatalf("error when parsing the query: %s", err)
+		}
+		unverified, err := client.SearchQuery(dir, query)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", query, err)
+		}
+		if !reflect.DeepEqual(unverified, testCase.unverified) {
+			t.Fatalf("incorrect unverified result for %s: expected %v actual %v", query, testCase.unverified, unverified)
+		}
+		strict, err := client.SearchQueryStrict(dir, query)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", query, err)
+		}
+		if !reflect.DeepEqual(strict, testCase.strict) {
+			t.Fatalf("incorrect strict result for %s: expected %v actual %v", query, testCase.strict, strict)
+		}
+	}
+
+	noPhraseClient, noPhraseDir := startTestClient(t, "", nil)
+	defer os.RemoveAll(noPhraseDir)
+	defer noPhraseClient.Close()
+	if _, err := noPhraseClient.SearchWord(noPhraseDir, "\"quick brown\""); err == nil {
+		t.Fatalf("no error returned for a phrase search without biwords")
+	}
+}
+
+// TestSearchFields tests the field searches.  Checks that the words of the
+// pathnames are only matched by the field terms, that the verification reads
+// the fields from the pathnames, and that renaming a file indexes it again.
+func TestSearchFields(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Paths: true}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	if err := os.MkdirAll(filepath.Join(dir, "invoices", "2024"), 0777); err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	report := filepath.Join(dir, "invoices", "2024", "q3.pdf")
+	if err := ioutil.WriteFile(report, []byte("Quarterly totals"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	notes := filepath.Join(dir, "invoice.txt")
+	if err := ioutil.WriteFile(notes, []byte("See the invoices folder"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{report, notes} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testQuery := func(input string, expected []string) {
+		query, err := ParseQuery(input)
+		if err != nil {
+			t.Fatalf("error when parsing the query: %s", err)
+		}
+		unverified, err := client.SearchQuery(dir, query)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", query, err)
+		}
+		if !reflect.DeepEqual(unverified, expected) {
+			t.Fatalf("incorrect unverified result for %s: expected %v actual %v", query, expected, unverified)
+		}
+		strict, err := client.SearchQueryStrict(dir, query)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", query, err)
+		}
+		if len(expected) == 0 {
+			expected = nil
+		}
+		if !reflect.DeepEqual(strict, expected) {
+			t.Fatalf("incorrect strict result for %s: expected %v actual %v", query, expected, strict)
+		}
+	}
+	testQuery("path:Invoices", []string{report})
+	testQuery("invoices", []string{notes})
+	testQuery("name:invoice", []string{notes})
+	testQuery("ext:txt OR ext:pdf", []string{notes, report})
+	testQuery("path:invoices/2024 quarterly", []string{report})
+	testQuery("name:invoices", []string{})
+
+	files, err := client.SearchWord(dir, "name:q3")
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if !reflect.DeepEqual(files, []string{report}) {
+		t.Fatalf("incorrect search result: expected %v actual %v", []string{report}, files)
+	}
+
+	renamed := filepath.Join(dir, "q3.pdf")
+	if err := os.Rename(report, renamed); err != nil {
+		t.Fatalf("error when renaming the file: %s", err)
+	}
+	if err := client.RenameFile(dir, report, renamed); err != nil {
+		t.Fatalf("error when renaming the index: %s", err)
+	}
+	testQuery("path:invoices", []string{})
+	testQuery("name:q3 quarterly", []string{renamed})
+
+	noPathClient, noPathDir := startTestClient(t, "", nil)
+	defer os.RemoveAll(noPathDir)
+	defer noPathClient.Close()
+	if _, err := noPathClient.SearchWord(noPathDir, "name:invoice"); err == nil {
+		t.Fatalf("no error returned for a field search without indexed paths")
+	}
+}
+
+// TestSearchMetadata tests the filtered searches.  Checks that the metadata
+// buckets narrow down the candidates, that the strict searches check the exact
+// ranges, and that the filters are rejected for a TLF without metadata.
+func TestSearchMetadata(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Metadata: true}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	now := time.Now()
+	small := filepath.Join(dir, "small.txt")
+	large := filepath.Join(dir, "large.txt")
+	old := filepath.Join(dir, "old.html")
+	contents := map[string]string{
+		small: "A short report",
+		large: "A long report " + strings.Repeat("padding ", 20),
+		old:   "An old report page",
+	}
+	for _, filename := range []string{small, large, old} {
+		if err := ioutil.WriteFile(filename, []byte(contents[filename]), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	lastYear := now.AddDate(-1, 0, 0)
+	if err := os.Chtimes(old, lastYear, lastYear); err != nil {
+		t.Fatalf("error when changing the modification time: %s", err)
+	}
+	for _, filename := range []string{small, large, old} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testFilter := func(input string, filter Filter, unverified, strict []string) {
+		var query *Query
+		if input != "" {
+			var err error
+			if query, err = ParseQuery(input); err != nil {
+				t.Fatalf("error when parsing the query: %s", err)
+			}
+		}
+		filtered, err := FilterQuery(query, filter)
+		if err != nil {
+			t.Fatalf("error when filtering the query: %s", err)
+		}
+		files, err := client.SearchQuery(dir, filtered)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", filtered, err)
+		}
+		if !reflect.DeepEqual(files, unverified) {
+			t.Fatalf("incorrect unverified result for %s: expected %v actual %v", filtered, unverified, files)
+		}
+		files, err = client.SearchQueryStrict(dir, filtered)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", filtered, err)
+		}
+		if !reflect.DeepEqual(files, strict) {
+			t.Fatalf("incorrect strict result for %s: expected %v actual %v", filtered, strict, files)
+		}
+	}
+	testFilter("report", Filter{Types: []string{"txt"}}, []string{large, small}, []string{large, small})
+	testFilter("", Filter{Types: []string{"text/*"}, ModifiedBefore: now.AddDate(0, -1, 0)}, []string{old}, []string{old})
+	testFilter("report", Filter{MinSize: 100}, []string{large}, []string{large})
+	// The size of the short report shares its bucket with the old report.
+	testFilter("report", Filter{MaxSize: 16}, []string{old, small}, []string{small})
+
+	if err := os.Chtimes(small, lastYear, lastYear); err != nil {
+		t.Fatalf("error when changing the modification time: %s", err)
+	}
+	if err := client.AddFile(dir, small); err != nil {
+		t.Fatalf("error when updating the file: %s", err)
+	}
+	testFilter("report", Filter{ModifiedAfter: now.AddDate(0, 0, -7)}, []string{large}, []string{large})
+
+	noMetadataClient, noMetadataDir := startTestClient(t, "", nil)
+	defer os.RemoveAll(noMetadataDir)
+	defer noMetadataClient.Close()
+	filtered, err := FilterQuery(nil, Filter{Types: []string{"txt"}})
+	if err != nil {
+		t.Fatalf("error when filtering the query: %s", err)
+	}
+	if _, err := noMetadataClient.SearchQuery(noMetadataDir, filtered); err == nil {
+		t.Fatalf("no error returned for a filtered search without indexed metadata")
+	}
+}
+
+// TestSearchExtractedText tests the searches of the documents whose text is
+// extracted.  Checks that the text is found but not the markup, and that the
+// strict searches and the hits read the same text.
+func TestSearchExtractedText(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	page := filepath.Join(dir, "page.html")
+	pageContent := "<html><body><p class=\"intro\">Quarterly figures</p><script>var hidden;</script></body></html>"
+	if err := ioutil.WriteFile(page, []byte(pageContent), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	report := filepath.Join(dir, "report.docx")
+	reportContent := createZip(t, map[string]string{
+		"word/document.xml": `<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>Quarterly report</w:t></w:r></w:p></w:body></w:document>`,
+	})
+	if err := ioutil.WriteFile(report, reportContent, 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{page, report} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testQuery := func(input string, expected []string) {
+		query, err := ParseQuery(input)
+		if err != nil {
+			t.Fatalf("error when parsing the query: %s", err)
+		}
+		files, err := client.SearchQueryStrict(dir, query)
+		if err != nil {
+			t.Fatalf("error when searching the query %s: %s", query, err)
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Fatalf("incorrect result for %s: expected %v actual %v", query, expected, files)
+		}
+	}
+	testQuery("quarterly", []string{page, report})
+	testQuery("report", []string{report})
+	testQuery("intro OR hidden OR document", nil)
+
+	query, err := ParseQuery("figures")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	hits, err := client.SearchQueryHits(dir, query, 0)
+	if err != nil {
+		t.Fatalf("error when searching the query: %s", err)
+	}
+	if len(hits) != 1 || len(hits[0].Lines) != 1 || hits[0].Lines[0].Text != "Quarterly figures" {
+		t.Fatalf("incorrect hits: %v", hits)
+	}
+}
+
+// TestSearchLegacyIndex tests the migration from the legacy normalization
+// scheme.  Checks that an index built under the legacy scheme can still be
+// found, and that reconciling rebuilds it under the current scheme.
+func TestSearchLegacyIndex(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	filename := filepath.Join(dir, "legacy")
+	if err := ioutil.WriteFile(filename, []byte("Straße"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	// Builds the index as a client from before the versioning would.
+	dirInfo := client.directoryInfos[dir]
+	masterSecret, err := fetchMasterSecret(dir, dirInfo.keyGen, dirInfo.lenMS)
+	if err != nil {
+		t.Fatalf("error when fetching the master secret: %s", err)
+	}
+	analyzer, indexer := dirInfo.analyzer, dirInfo.indexers[0]
+	dirInfo.analyzer = analyzer.WithNormalization(libsearch.LegacyNormalization)
+	dirInfo.indexers[0] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, dirInfo.tlfInfo.Salts, uint64(dirInfo.tlfInfo.Size), dirInfo.analyzer, libsearch.IndexOptionsFromTlfInfo(dirInfo.tlfInfo))
+	err = client.AddFile(dir, filename)
+	dirInfo.analyzer, dirInfo.indexers[0] = analyzer, indexer
+	if err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+
+	testWord := func(word string, expected []string) {
+		actual, err := client.SearchWord(dir, word)
+		if err != nil {
+			t.Fatalf("error when searching word \"%s\": %s", word, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect search result for \"%s\": expected %v actual %v", word, expected, actual)
+		}
+	}
+	testWord("Straße", []string{filename})
+	testWord("STRASSE", []string{})
+
+	result, err := client.Reconcile(context.Background(), dir, 1)
+	if err != nil {
+		t.Fatalf("error when reconciling: %s", err)
+	}
+	if result.Written != 1 {
+		t.Fatalf("legacy index not rebuilt: %+v", result)
+	}
+	entry, _, err := dirInfo.manifest.get("legacy")
+	if err != nil || entry.Normalization != libsearch.CurrentNormalizationVersion {
+		t.Fatalf("incorrect manifest entry after reconciling: %+v", entry)
+	}
+	testWord("Straße", []string{filename})
+	testWord("STRASSE", []string{filename})
+}
+
+// TestBuildIndexDuringRekey tests the `buildIndex` function while the keys are
+// being updated.  Checks that every document ID is encrypted with the
+// pathname key of its own key generation, so that it can be decrypted.
+func TestBuildIndexDuringRekey(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	filename := filepath.Join(dir, "rekeyed")
+	if err := ioutil.WriteFile(filename, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	dirInfo := client.directoryInfos[dir]
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for keyGen := libkbfs.KeyGen(2); keyGen <= 20; keyGen++ {
+			client.updateKeys(dirInfo, keyGen, keyGen-1)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		built, err := dirInfo.buildIndex(filename)
+		if err != nil {
+			t.Fatalf("error when building the index: %s", err)
+		}
+		dirInfo.keyGenLock.RLock()
+		relPath, err := libsearch.DocIDToPathname(built.item.DocID, dirInfo.pathnameKeys)
+		dirInfo.keyGenLock.RUnlock()
+		if err != nil || relPath != "rekeyed" {
+			t.Fatalf("document ID built with a mismatched key: %s", err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// The text of a document is extracted before it is indexed, so that the
+// markup of the HTML pages, the compressed XML of the office documents and the
+// encoded streams of the PDFs are not indexed as words.  The extractor is
+// chosen by the MIME type sniffed from the leading bytes of the document,
+// except for the source and markdown files, which are indexed as they are.
+// The strict searches read the documents through the same extractors, so that
+// they check the same text as the one indexed.
+
+// extractionVersion is the version of the text extraction.  The indexes built
+// with an older version are rebuilt by the next scan.
+const extractionVersion = 1
+
+// The limits of the extraction, which keep a small compressed document from
+// exhausting the memory.  The content decompressed from a document and the text
+// extracted from it can each be at most `maxExtractionRatio` times as long as
+// the document, and never longer than `maxExtractedLen` bytes.
+const (
+	maxExtractionRatio = 100
+	maxExtractedLen    = 256 << 20
+)
+
+// errExtractionLimit is returned for the documents whose decompressed content
+// or extracted text exceeds the limits of the extraction.
+var errExtractionLimit = errors.New("document exceeds the extraction limit")
+
+// extractionLimit returns the maximum length of the content decompressed from
+// a document of `size` bytes, and of the text extracted from it.
+func extractionLimit(size int64) int64 {
+	if size > maxExtractedLen/maxExtractionRatio {
+		return maxExtractedLen
+	}
+	return size * maxExtractionRatio
+}
+
+// readLimited reads all of `r`, a decompressed part of a document, and charges
+// its length to the remaining `budget` of the document.  Returns
+// `errExtractionLimit` if the part is longer than the budget.
+func readLimited(r io.Reader, budget *int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, *budget+1))
+	if int64(len(data)) > *budget {
+		return nil, errExtractionLimit
+	}
+	*budget -= int64(len(data))
+	return data, err
+}
+
+// Extractor returns a reader of the plain text of the document of `size` bytes
+// read from `r`.
+type Extractor func(r io.ReaderAt, size int64) (io.Reader, error)
+
+// The MIME types of the documents with built-in extractors.
+const (
+	HTMLMimeType = "text/html"
+	ZipMimeType  = "application/zip"
+	PDFMimeType  = "application/pdf"
+)
+
+// extractorsLock protects `extractors`.
+var extractorsLock sync.RWMutex
+
+// extractors is the map from the sniffed MIME types to the registered
+// extractors.  The documents of the other types are indexed as they are.
+var extractors = map[string]Extractor{
+	HTMLMimeType: ExtractHTML,
+	ZipMimeType:  ExtractOffice,
+	PDFMimeType:  ExtractPDF,
+}
+
+// textExtensions are the extensions of the source and markdown files, which
+// are indexed as they are whatever their content looks like.
+var textExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cs": true, ".css": true, ".go": true,
+	".h": true, ".hpp": true, ".java": true, ".js": true, ".json": true, ".jsx": true,
+	".kt": true, ".m": true, ".markdown": true, ".md": true, ".php": true, ".pl": true,
+	".py": true, ".rb": true, ".rs": true, ".rst": true, ".scala": true, ".sh": true,
+	".sql": true, ".swift": true, ".tex": true, ".toml": true, ".ts": true, ".tsx": true,
+	".txt": true, ".xml": true, ".yaml": true, ".yml": true,
+}
+
+// RegisterExtractor makes `extractor` extract the text of the documents
+// sniffed as `mimeType`.  Returns an error if the MIME type already has an
+// extractor.
+func RegisterExtractor(mimeType string, extractor Extractor) error {
+	extractorsLock.Lock()
+	defer extractorsLock.Unlock()
+	if mimeType == "" {
+		return errors.New("empty MIME type")
+	}
+	if _, ok := extractors[mimeType]; ok {
+		return errors.New("MIME type already has an extractor")
+	}
+	extractors[mimeType] = extractor
+	return nil
+}
+
+// getExtractor returns the extractor of the document at `pathname` with the
+// leading bytes `head`, or nil if the document is indexed as it is.
+func getExtractor(pathname string, head []byte) Extractor {
+	if textExtensions[strings.ToLower(filepath.Ext(pathname))] {
+		return nil
+	}
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return nil
+	}
+	extractorsLock.RLock()
+	defer extractorsLock.RUnlock()
+	return extractors[mimeType]
+}
+
+// extractText returns a reader of the text of `file` of `size` bytes, which is
+// extracted by the extractor of its type if any, along with the length of the
+// text.  The text of an extractor is read into memory unless the extractor
+// already has, and is an error if longer than `extractionLimit(size)`.  The
+// file is read at explicit offsets, so its current offset is left unchanged.
+func extractText(file *os.File, size int64) (io.Reader, int64, error) {
+	head := make([]byte, fileTypeHeadLen)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	extractor := getExtractor(file.Name(), head[:n])
+	if extractor == nil {
+		return io.NewSectionReader(file, 0, size), size, nil
+	}
+	text, err := extractor(file, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf, ok := text.(*bytes.Buffer)
+	if !ok {
+		limit := extractionLimit(size)
+		textBytes, err := readLimited(text, &limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		buf = bytes.NewBuffer(textBytes)
+	}
+	if int64(buf.Len()) > extractionLimit(size) {
+		return nil, 0, errExtractionLimit
+	}
+	return buf, int64(buf.Len()), nil
+}
+
+// textFile is a file opened for reading its extracted text.
+type textFile struct {
+	io.Reader          // The extracted text.
+	file      *os.File // The underlying file.
+}
+
+// Close closes the underlying file.
+func (f *textFile) Close() error {
+	return f.file.Close()
+}
+
+// openText opens the file at `filename` for reading its extracted text.
+func openText(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	text, _, err := extractText(file, fileInfo.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &textFile{Reader: text, file: file}, nil
+}
+
+// htmlBlockElements are the HTML elements whose text is put on lines of its
+// own, so that the words of adjacent blocks are not joined.
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true, "th": true,
+	"title": true, "tr": true, "ul": true,
+}
+
+// writeHTMLText writes the text of the contents of `s` to `buf`.
+func writeHTMLText(buf *bytes.Buffer, s *goquery.Selection) {
+	s.Contents().Each(func(_ int, child *goquery.Selection) {
+		node := child.Get(0)
+		switch node.Type {
+		case html.TextNode:
+			buf.WriteString(node.Data)
+		case html.ElementNode:
+			block := htmlBlockElements[node.Data]
+			if block {
+				buf.WriteByte('\n')
+			}
+			writeHTMLText(buf, child)
+			if block {
+				buf.WriteByte('\n')
+			}
+		}
+	})
+}
+
+// ExtractHTML is the extractor of the HTML pages, which returns the text of
+// their elements without the scripts and the style sheets.  The character
+// references are decoded.
+func ExtractHTML(r io.ReaderAt, size int64) (io.Reader, error) {
+	doc, err := goquery.NewDocumentFromReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	doc.Find("script, style, noscript, template").Remove()
+	var buf bytes.Buffer
+	writeHTMLText(&buf, doc.Selection)
+	return &buf, nil
+}
+
+// officeParts are the patterns of the parts of the OOXML and ODF archives
+// holding the text of the documents.
+var officeParts = []string{
+	"word/document.xml", "word/header*.xml", "word/footer*.xml", "word/footnotes.xml", "word/endnotes.xml",
+	"xl/sharedStrings.xml", "xl/worksheets/sheet*.xml",
+	"ppt/slides/slide*.xml", "ppt/notesSlides/notesSlide*.xml",
+	"content.xml",
+}
+
+// The local names of the elements of the office documents ending a line,
+// separating the words, or holding no text of the document.
+var (
+	xmlLineElements  = map[string]bool{"p": true, "h": true, "br": true, "cr": true, "line-break": true, "tr": true, "row": true, "si": true}
+	xmlSpaceElements = map[string]bool{"tab": true, "s": true, "c": true, "tc": true, "table-cell": true}
+	xmlSkipElements  = map[string]bool{"instrText": true, "delInstrText": true, "f": true}
+)
+
+// writeXMLText writes the character data of the XML document read from `r` to
+// `buf`, leaving out the elements without text of the document.
+func writeXMLText(buf *bytes.Buffer, r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	skipDepth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 || xmlSkipElements[t.Name.Local] {
+				skipDepth++
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+			} else if xmlLineElements[t.Name.Local] {
+				buf.WriteByte('\n')
+			} else if xmlSpaceElements[t.Name.Local] {
+				buf.WriteByte(' ')
+			}
+		case xml.CharData:
+			if skipDepth == 0 {
+				buf.Write(t)
+			}
+		}
+	}
+}
+
+// zipFileSlice attaches the methods of sort.Interface to []*zip.File, sorting
+// by the names in increasing order.
+type zipFileSlice []*zip.File
+
+func (p zipFileSlice) Len() int           { return len(p) }
+func (p zipFileSlice) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p zipFileSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// ExtractOffice is the extractor of the zip archives, which returns the text
+// of the Office Open XML (.docx, .xlsx, .pptx) and OpenDocument (.odt, .ods,
+// .odp) documents they hold.  The other archives have no text.  The parts of
+// the archive decompressed beyond `extractionLimit(size)` are an error.
+func ExtractOffice(r io.ReaderAt, size int64) (io.Reader, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var files []*zip.File
+	for _, file := range archive.File {
+		for _, pattern := range officeParts {
+			if matched, _ := path.Match(pattern, file.Name); matched {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	sort.Sort(zipFileSlice(files))
+
+	var buf bytes.Buffer
+	budget := extractionLimit(size)
+	for _, file := range files {
+		part, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := readLimited(part, &budget)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeXMLText(&buf, bytes.NewReader(content)); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return &buf, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readText returns the text extracted by `extractor` from `content`.
+func readText(t *testing.T, extractor Extractor, content []byte) string {
+	text, err := extractor(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when extracting the text: %s", err)
+	}
+	textBytes, err := ioutil.ReadAll(text)
+	if err != nil {
+		t.Fatalf("error when reading the text: %s", err)
+	}
+	return string(textBytes)
+}
+
+// textLines returns the non-empty lines of `text` without their surrounding
+// white space.
+func textLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// createZip returns a zip archive of the `files`, mapping the names to their
+// contents.
+func createZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("error when creating the archive: %s", err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("error when creating the archive: %s", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("error when creating the archive: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// TestGetExtractor tests the `getExtractor` function.  Checks that the
+// extractor is chosen by the sniffed type, except for the source and markdown
+// files.
+func TestGetExtractor(t *testing.T) {
+	testCases := []struct {
+		pathname string
+		head     string
+		expected Extractor
+	}{
+		{"page", "<!DOCTYPE html><html>", ExtractHTML},
+		{"page.txt", "<html>", nil},
+		{"README.md", "<!-- comment -->", nil},
+		{"report.docx", "PK\x03\x04", ExtractOffice},
+		{"scan", "%PDF-1.7", ExtractPDF},
+		{"notes", "plain text", nil},
+	}
+	for _, testCase := range testCases {
+		extractor := getExtractor(testCase.pathname, []byte(testCase.head))
+		if reflect.ValueOf(extractor).Pointer() != reflect.ValueOf(testCase.expected).Pointer() {
+			t.Fatalf("incorrect extractor for \"%s\"", testCase.pathname)
+		}
+	}
+
+	if err := RegisterExtractor(PDFMimeType, ExtractHTML); err == nil {
+		t.Fatalf("no error returned for a MIME type already registered")
+	}
+	if err := RegisterExtractor("image/png", ExtractHTML); err != nil {
+		t.Fatalf("error when registering the extractor: %s", err)
+	}
+	defer delete(extractors, "image/png")
+	if extractor := getExtractor("image", []byte("\x89PNG\x0d\x0a\x1a\x0a")); extractor == nil {
+		t.Fatalf("registered extractor not returned")
+	}
+}
+
+// TestExtractHTML tests the `ExtractHTML` function.  Checks that the markup,
+// the scripts and the style sheets are left out, and that the blocks are put
+// on separate lines.
+func TestExtractHTML(t *testing.T) {
+	content := "<html><head><title>Title</title><style>p { color: red }</style></head>" +
+		"<body><p>Hello <b>wor</b>ld</p><div>caf&eacute; &amp; bar</div><script>var hidden;</script></body></html>"
+	expected := []string{"Title", "Hello world", "café & bar"}
+	if lines := textLines(readText(t, ExtractHTML, []byte(content))); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("incorrect text: expected %q actual %q", expected, lines)
+	}
+}
+
+// TestExtractOffice tests the `ExtractOffice` function.  Checks that the text
+// of the OOXML and ODF documents is extracted without the field codes, that
+// the other archives have no text, and that the parts decompressing beyond the
+// limit are an error.
+func TestExtractOffice(t *testing.T) {
+	docx := createZip(t, map[string]string{
+		"[Content_Types].xml": `<Types><Default Extension="xml" ContentType="application/xml"/></Types>`,
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:r><w:t>Quar</w:t></w:r><w:r><w:t>terly</w:t></w:r><w:r><w:tab/><w:t>report</w:t></w:r></w:p>` +
+			`<w:p><w:r><w:instrText>HYPERLINK</w:instrText></w:r><w:r><w:t>Totals &amp; taxes</w:t></w:r></w:p></w:body></w:document>`,
+	})
+	if text, expected := readText(t, ExtractOffice, docx), "Quarterly report\nTotals & taxes\n\n"; text != expected {
+		t.Fatalf("incorrect docx text: expected %q actual %q", expected, text)
+	}
+
+	odt := createZip(t, map[string]string{
+		"mimetype":    "application/vnd.oasis.opendocument.text",
+		"content.xml": `<office:document-content xmlns:office="o" xmlns:text="t"><office:body><text:h>Title</text:h><text:p>First<text:s/>line</text:p></office:body></office:document-content>`,
+	})
+	if text, expected := readText(t, ExtractOffice, odt), "Title\nFirst line\n\n"; text != expected {
+		t.Fatalf("incorrect odt text: expected %q actual %q", expected, text)
+	}
+
+	archive := createZip(t, map[string]string{"photo.jpg": "not really"})
+	if text := readText(t, ExtractOffice, archive); text != "" {
+		t.Fatalf("text returned for an archive without documents: %q", text)
+	}
+	if _, err := ExtractOffice(bytes.NewReader([]byte("PK\x03\x04")), 4); err == nil {
+		t.Fatalf("no error returned for a corrupted archive")
+	}
+
+	// A part decompressing to far more than the archive is a zip bomb.
+	bomb := createZip(t, map[string]string{"word/document.xml": strings.Repeat(" ", 1<<20)})
+	if _, err := ExtractOffice(bytes.NewReader(bomb), int64(len(bomb))); err != errExtractionLimit {
+		t.Fatalf("incorrect error for a zip bomb: %v", err)
+	}
+}
+
+// TestExtractText tests the `extractText` function.  Checks that the length of
+// the text is returned along with it, for the documents indexed as they are
+// and for those whose text is longer than the document.
+func TestExtractText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExtractText")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	docx := createZip(t, map[string]string{"word/document.xml": "<w:p><w:t>" + strings.Repeat("repeated words ", 1000) + "</w:t></w:p>"})
+	testCases := []struct {
+		name    string
+		content []byte
+		textLen int64
+	}{
+		{"notes.txt", []byte("plain text"), 10},
+		{"report.docx", docx, int64(len("repeated words ")*1000 + 2)},
+	}
+	for _, testCase := range testCases {
+		filename := filepath.Join(dir, testCase.name)
+		if err := ioutil.WriteFile(filename, testCase.content, 0666); err != nil {
+			t.Fatalf("error when writing the test file: %s", err)
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatalf("error when opening the test file: %s", err)
+		}
+		text, textLen, err := extractText(file, int64(len(testCase.content)))
+		if err != nil {
+			file.Close()
+			t.Fatalf("error when extracting the text of %s: %s", testCase.name, err)
+		}
+		textBytes, err := ioutil.ReadAll(text)
+		file.Close()
+		if err != nil {
+			t.Fatalf("error when reading the text of %s: %s", testCase.name, err)
+		}
+		if textLen != testCase.textLen || int64(len(textBytes)) != textLen {
+			t.Fatalf("incorrect text length of %s: expected %d actual %d, read %d", testCase.name, testCase.textLen, textLen, len(textBytes))
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// fileTypeHeadLen is the number of leading bytes of a file read to detect its
+// type when its extension is unknown.
+const fileTypeHeadLen = 512
+
+// Filter restricts the files matched by a query by their metadata.  The zero
+// value of each field leaves the files unrestricted by it.  The modification
+// times are compared to the second.
+type Filter struct {
+	Types          []string  // The accepted file types, as MIME types such as "application/pdf", major types such as "image/*", or extensions such as "pdf".
+	MinSize        int64     // The minimum size in bytes.
+	MaxSize        int64     // The maximum size in bytes, or 0 for no maximum.
+	ModifiedAfter  time.Time // The time the files must have been modified at or after.
+	ModifiedBefore time.Time // The time the files must have been modified before.
+}
+
+// isEmpty returns whether the filter leaves all the files unrestricted.
+func (f Filter) isEmpty() bool {
+	return len(f.Types) == 0 && f.MinSize <= 0 && f.MaxSize <= 0 && f.ModifiedAfter.IsZero() && f.ModifiedBefore.IsZero()
+}
+
+// parseFileType returns the MIME type without parameters of `fileType`, which
+// is either a MIME type, a major type followed by "/*", or an extension with
+// or without its leading dot.  Returns an error if the extension is unknown.
+func parseFileType(fileType string) (string, error) {
+	if strings.Contains(fileType, "/") {
+		return strings.ToLower(fileType), nil
+	}
+	mimeType := mime.TypeByExtension("." + strings.TrimPrefix(fileType, "."))
+	if mimeType == "" {
+		return "", fmt.Errorf("unknown file type \"%s\"", fileType)
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// metadataTerm returns the analyzed term matching the files with a value from
+// `min` to `max` in the bucket `r` of the metadata `field`.
+func metadataTerm(field string, r libsearch.BucketRange) string {
+	return fieldTerm(field, r.Bucket+" "+strconv.FormatInt(r.Min, 10)+" "+strconv.FormatInt(r.Max, 10))
+}
+
+// fieldValue returns the value looked up in the index for the `keyword` of a
+// field term, i.e. the keyword without the range of a metadata bucket.
+func fieldValue(keyword string) string {
+	if i := strings.Index(keyword, " "); i >= 0 {
+		return keyword[:i]
+	}
+	return keyword
+}
+
+// appendDisjunction appends the disjunction of `terms` to the postfix query.
+func (q *Query) appendDisjunction(terms []string) {
+	for i, term := range terms {
+		q.appendTerm(term)
+		if i > 0 {
+			q.tokens = append(q.tokens, sserver1.QueryToken{Op: sserver1.QueryOp_OR})
+		}
+	}
+}
+
+// FilterQuery returns the conjunction of `query` with the restrictions of
+// `filter`.  Each restriction is searched for as the disjunction of the
+// metadata buckets it overlaps, and the candidate files are checked against
+// the exact restriction by the strict searches.  `query` can be nil to match
+// all the files passing the filter.  The metadata search must be enabled for
+// the searched TLF.  Returns an error if the filter is invalid, or if both
+// `query` is nil and the filter is empty.
+func FilterQuery(query *Query, filter Filter) (*Query, error) {
+	if filter.MinSize < 0 || filter.MaxSize < 0 || (filter.MaxSize > 0 && filter.MinSize > filter.MaxSize) {
+		return nil, errors.New("invalid size range in filter")
+	}
+	if !filter.ModifiedAfter.IsZero() && !filter.ModifiedBefore.IsZero() && !filter.ModifiedAfter.Before(filter.ModifiedBefore) {
+		return nil, errors.New("invalid modification time range in filter")
+	}
+	if query == nil && filter.isEmpty() {
+		return nil, errors.New("empty query")
+	}
+
+	filtered := new(Query)
+	if query != nil {
+		filtered.tokens = append(filtered.tokens, query.tokens...)
+		filtered.terms = append(filtered.terms, query.terms...)
+	}
+	var groups [][]string
+	if len(filter.Types) > 0 {
+		var terms []string
+		for _, fileType := range filter.Types {
+			mimeType, err := parseFileType(fileType)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, fieldTerm(libsearch.FieldType, mimeType))
+		}
+		groups = append(groups, terms)
+	}
+	if filter.MinSize > 0 || filter.MaxSize > 0 {
+		maxSize := filter.MaxSize
+		if maxSize == 0 {
+			maxSize = math.MaxInt64
+		}
+		var terms []string
+		for _, r := range libsearch.SizeRanges(filter.MinSize, maxSize) {
+			terms = append(terms, metadataTerm(libsearch.FieldSize, r))
+		}
+		groups = append(groups, terms)
+	}
+	if !filter.ModifiedAfter.IsZero() || !filter.ModifiedBefore.IsZero() {
+		var minTime, maxTime int64 = 0, math.MaxInt64
+		if !filter.ModifiedAfter.IsZero() {
+			minTime = filter.ModifiedAfter.Unix()
+		}
+		if !filter.ModifiedBefore.IsZero() {
+			maxTime = filter.ModifiedBefore.Unix() - 1
+		}
+		var terms []string
+		for _, r := range libsearch.ModTimeRanges(minTime, maxTime) {
+			terms = append(terms, metadataTerm(libsearch.FieldModTime, r))
+		}
+		if len(terms) == 0 {
+			return nil, errors.New("invalid modification time range in filter")
+		}
+		groups = append(groups, terms)
+	}
+
+	for _, terms := range groups {
+		hasQuery := len(filtered.tokens) > 0
+		filtered.appendDisjunction(terms)
+		if hasQuery {
+			filtered.tokens = append(filtered.tokens, sserver1.QueryToken{Op: sserver1.QueryOp_AND})
+		}
+	}
+	return filtered, nil
+}
+
+// readMetadata returns the metadata of `file` with `fileInfo`, reading its
+// leading bytes in case its type is not given by its extension.  The file is read from its
+// current offset, which is restored afterwards.
+func readMetadata(file *os.File, fileInfo os.FileInfo) (libsearch.Metadata, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return libsearch.Metadata{}, err
+	}
+	head := make([]byte, fileTypeHeadLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return libsearch.Metadata{}, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return libsearch.Metadata{}, err
+	}
+	return libsearch.Metadata{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		Type:    libsearch.FileType(file.Name(), head[:n]),
+	}, nil
+}
+
+// fileMetadata returns the metadata of the file at `filename`.
+func fileMetadata(filename string) (libsearch.Metadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return libsearch.Metadata{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return libsearch.Metadata{}, err
+	}
+	return readMetadata(file, fileInfo)
+}
+
+// matchMetadata returns whether `metadata` matches the `keyword` of a term of
+// the metadata `field`, i.e. whether it has the value of the keyword in the
+// field, and its actual value is within the range of the keyword if any.
+func matchMetadata(metadata libsearch.Metadata, field, keyword string) bool {
+	parts := strings.Fields(keyword)
+	found := false
+	for _, value := range metadata.Fields()[field] {
+		if value == parts[0] {
+			found = true
+			break
+		}
+	}
+	if !found || len(parts) != 3 {
+		return found
+	}
+	min, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	max, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return false
+	}
+	actual := metadata.Size
+	if field == libsearch.FieldModTime {
+		actual = metadata.ModTime.Unix()
+	}
+	return min <= actual && actual <= max
+}
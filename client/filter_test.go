@@ -0,0 +1,80 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// TestFilterQuery tests the `FilterQuery` function.  Checks that each
+// restriction of the filter is ANDed with the query as the disjunction of its
+// buckets, and that the invalid filters are rejected.
+func TestFilterQuery(t *testing.T) {
+	query, err := ParseQuery("report")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	filtered, err := FilterQuery(query, Filter{Types: []string{"pdf", "image/*"}, MinSize: 1000, MaxSize: 3000})
+	if err != nil {
+		t.Fatalf("error when filtering the query: %s", err)
+	}
+	expected := "((report AND (type: application/pdf OR type: image/*)) AND ((size: 10 1000 1023 OR size: 11 1024 2047) OR size: 12 2048 3000))"
+	if filtered.String() != expected {
+		t.Fatalf("incorrect filtered query: expected \"%s\" actual \"%s\"", expected, filtered.String())
+	}
+	if query.String() != "report" {
+		t.Fatalf("query modified by the filter: %s", query.String())
+	}
+
+	after := time.Unix(20526*24*60*60, 0)
+	filtered, err = FilterQuery(nil, Filter{ModifiedAfter: after, ModifiedBefore: after.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("error when filtering the query: %s", err)
+	}
+	if expected := "mtime: 0:20526 1773446400 1773532799"; filtered.String() != expected {
+		t.Fatalf("incorrect filter query: expected \"%s\" actual \"%s\"", expected, filtered.String())
+	}
+
+	invalid := []Filter{
+		{},
+		{Types: []string{"notanextension"}},
+		{MinSize: 10, MaxSize: 5},
+		{MinSize: -1},
+		{ModifiedAfter: after, ModifiedBefore: after},
+	}
+	for _, filter := range invalid {
+		if _, err := FilterQuery(nil, filter); err == nil {
+			t.Fatalf("no error returned for the invalid filter %v", filter)
+		}
+	}
+}
+
+// TestMatchMetadata tests the `matchMetadata` function.  Checks that the
+// metadata is matched by the values of its buckets, within the exact range of
+// the bucket if any.
+func TestMatchMetadata(t *testing.T) {
+	metadata := libsearch.Metadata{Size: 1500, ModTime: time.Unix(1773500000, 0), Type: "image/png"}
+	testCases := []struct {
+		field    string
+		keyword  string
+		expected bool
+	}{
+		{libsearch.FieldSize, "11 1024 2047", true},
+		{libsearch.FieldSize, "11 1600 2047", false},
+		{libsearch.FieldSize, "10 1000 1023", false},
+		{libsearch.FieldModTime, "0:20526 1773446400 1773532799", true},
+		{libsearch.FieldModTime, "0:20526 1773446400 1773499999", false},
+		{libsearch.FieldType, "image/*", true},
+		{libsearch.FieldType, "image/jpeg", false},
+	}
+	for _, testCase := range testCases {
+		if matched := matchMetadata(metadata, testCase.field, testCase.keyword); matched != testCase.expected {
+			t.Fatalf("incorrect match of %s: %s: expected %t actual %t", testCase.field, testCase.keyword, testCase.expected, matched)
+		}
+	}
+}
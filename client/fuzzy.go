@@ -0,0 +1,173 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// FuzzyFile is a file returned by a fuzzy search, along with its keyword
+// closest to the searched word.
+type FuzzyFile struct {
+	Filename string // The absolute path of the file.
+	Keyword  string // The keyword of the file closest to the searched word.
+	Distance int    // The edit distance between the keyword and the searched word.
+}
+
+// fuzzyFileSlice attaches the methods of sort.Interface to []FuzzyFile,
+// sorting in increasing order of the distances, and then of the filenames.
+type fuzzyFileSlice []FuzzyFile
+
+func (p fuzzyFileSlice) Len() int { return len(p) }
+func (p fuzzyFileSlice) Less(i, j int) bool {
+	if p[i].Distance != p[j].Distance {
+		return p[i].Distance < p[j].Distance
+	}
+	return p[i].Filename < p[j].Filename
+}
+func (p fuzzyFileSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// editDistance returns the Levenshtein distance in characters between `a` and
+// `b`, or `maxDistance+1` if it is greater than `maxDistance`.
+func editDistance(a, b string, maxDistance int) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra)-len(rb) > maxDistance || len(rb)-len(ra) > maxDistance {
+		return maxDistance + 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > maxDistance {
+			return maxDistance + 1
+		}
+		prev, curr = curr, prev
+	}
+	if prev[len(rb)] > maxDistance {
+		return maxDistance + 1
+	}
+	return prev[len(rb)]
+}
+
+// findClosestKeyword reads the text of the file at `filename` and returns its keyword
+// closest to the analyzed `keyword` along with their edit distance.  The
+// second return value is false if no keyword of the file is within an edit
+// distance of `maxDistance`.
+func findClosestKeyword(filename, keyword string, maxDistance int, analyzer libsearch.Analyzer) (FuzzyFile, bool, error) {
+	text, err := openText(filename)
+	if err != nil {
+		return FuzzyFile{}, false, err
+	}
+	defer text.Close()
+
+	closest := FuzzyFile{Filename: filename, Distance: maxDistance + 1}
+	seen := make(map[string]bool)
+	err = libsearch.ScanKeywordsStream(text, analyzer, func(candidate string) bool {
+		if seen[candidate] {
+			return true
+		}
+		seen[candidate] = true
+		distance := editDistance(keyword, candidate, closest.Distance)
+		if distance < closest.Distance || (distance == closest.Distance && candidate < closest.Keyword) {
+			closest.Keyword = candidate
+			closest.Distance = distance
+		}
+		return closest.Distance > 0
+	})
+	if err != nil {
+		return FuzzyFile{}, false, err
+	}
+	return closest, closest.Distance <= maxDistance, nil
+}
+
+// SearchWordFuzzy performs a fuzzy search request on the search server and
+// returns the files in `directory` containing a keyword within an edit
+// distance of `maxDistance` from `word`, in increasing order of the
+// distances.  The server returns the files sharing enough trigrams with the
+// word, which are then read locally to compute the actual edit distances.
+// The fuzzy search must be enabled for the TLF, and `word` must have a single
+// keyword.  If some of the candidate files cannot be read, the other files
+// are returned along with a `*VerificationError`.
+// NOTE: A keyword sharing no trigram with `word` is never found, which only
+// happens for short words.
+func (c *Client) SearchWordFuzzy(directory, word string, maxDistance int) ([]FuzzyFile, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+	if !dirInfo.tlfInfo.Fuzzy {
+		return nil, errors.New("fuzzy search is not enabled for the TLF")
+	}
+	if maxDistance < 0 {
+		return nil, errors.New("invalid maximum edit distance")
+	}
+	keywords := dirInfo.analyzer.Keywords(word)
+	if len(keywords) != 1 {
+		return nil, errors.New("a fuzzy search needs a word with a single keyword")
+	}
+	keyword := keywords[0]
+
+	keyGens, err := c.searchCli.GetKeyGens(context.TODO(), dirInfo.tlfID)
+	if err != nil {
+		return nil, err
+	}
+
+	trapdoorMap := make(map[string][]sserver1.Trapdoor)
+	for keyGen, indexer := range dirInfo.getIndexersForKeyGens(keyGens) {
+		trapdoorMap[keyGen] = indexer.ComputeTrigramTrapdoors(keyword)
+	}
+	minMatches := libsearch.MinTrigramMatches(len(libsearch.Trigrams(keyword)), maxDistance)
+	documents, err := c.searchCli.SearchFuzzy(context.TODO(), sserver1.SearchFuzzyArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMap, MinMatches: minMatches})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FuzzyFile
+	var fileErrs []FileError
+	for _, document := range documents {
+		filename, err := dirInfo.docIDToFilename(document.DocID)
+		if err != nil {
+			return nil, err
+		}
+		closest, ok, err := findClosestKeyword(filename, keyword, maxDistance, dirInfo.analyzer)
+		if err != nil {
+			fileErrs = append(fileErrs, FileError{Filename: filename, Err: err})
+		} else if ok {
+			files = append(files, closest)
+		}
+	}
+
+	sort.Sort(fuzzyFileSlice(files))
+	if len(fileErrs) > 0 {
+		sort.Sort(fileErrorSlice(fileErrs))
+		return files, &VerificationError{Errors: fileErrs}
+	}
+	return files, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"github.com/keybase/search/server"
+	"golang.org/x/net/context"
+)
+
+// TestEditDistance tests the `editDistance` function.  Checks that the
+// distances are counted in characters, and that the distances beyond the
+// maximum are capped.
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b        string
+		maxDistance int
+		expected    int
+	}{
+		{"necessary", "necessary", 2, 0},
+		{"neccessary", "necessary", 2, 1},
+		{"recieve", "receive", 2, 2},
+		{"café", "cafe", 2, 1},
+		{"", "abc", 5, 3},
+		{"kitten", "sitting", 5, 3},
+		{"kitten", "sitting", 2, 3},
+		{"abc", "abcdefgh", 2, 3},
+	}
+	for _, testCase := range testCases {
+		if actual := editDistance(testCase.a, testCase.b, testCase.maxDistance); actual != testCase.expected {
+			t.Fatalf("incorrect edit distance between \"%s\" and \"%s\" up to %d: expected %d actual %d", testCase.a, testCase.b, testCase.maxDistance, testCase.expected, actual)
+		}
+	}
+}
+
+// TestSearchWordFuzzy tests the `SearchWordFuzzy` function.  Checks that the
+// misspelled words find the files with the closest keywords, and that the
+// fuzzy search fails for the TLFs without indexed trigrams.
+func TestSearchWordFuzzy(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	if _, err := searchCli.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Fuzzy: true}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	necessary := filepath.Join(dir, "necessary")
+	if err := ioutil.WriteFile(necessary, []byte("It is necessary to configure the server"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	necessity := filepath.Join(dir, "necessity")
+	if err := ioutil.WriteFile(necessity, []byte("Out of necessity"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	for _, filename := range []string{necessary, necessity} {
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		word        string
+		maxDistance int
+		expected    []FuzzyFile
+	}{
+		{"neccessary", 1, []FuzzyFile{{necessary, "necessary", 1}}},
+		{"Necesary", 2, []FuzzyFile{{necessary, "necessary", 1}}},
+		{"necesity", 3, []FuzzyFile{{necessity, "necessity", 1}, {necessary, "necessary", 3}}},
+		{"necessary", 0, []FuzzyFile{{necessary, "necessary", 0}}},
+		{"configuraton", 1, nil},
+		{"confgure", 1, []FuzzyFile{{necessary, "configure", 1}}},
+	}
+	for _, testCase := range testCases {
+		actual, err := client.SearchWordFuzzy(dir, testCase.word, testCase.maxDistance)
+		if err != nil {
+			t.Fatalf("error when searching word \"%s\": %s", testCase.word, err)
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("incorrect fuzzy search result for \"%s\" within %d edits: expected %v actual %v", testCase.word, testCase.maxDistance, testCase.expected, actual)
+		}
+	}
+
+	if _, err := client.SearchWordFuzzy(dir, "necessary", -1); err == nil {
+		t.Fatalf("no error returned for a negative maximum edit distance")
+	}
+
+	exactClient, exactDir := startTestClient(t, "", nil)
+	defer os.RemoveAll(exactDir)
+	defer exactClient.Close()
+	if _, err := exactClient.SearchWordFuzzy(exactDir, "necessary", 1); err == nil {
+		t.Fatalf("no error returned for a fuzzy search without trigrams")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// listPageSize is the number of document IDs requested per page when listing
+// the indexes on the server.
+const listPageSize = 500
+
+// isOrphaned returns whether the index of `docID` should be garbage collected,
+// either because its file no longer exists or because it has been superseded
+// by an index of a newer key generation, along with the relative path of the
+// file.  Indexes that cannot be decrypted with the keys of this client are
+// never considered orphaned.
+func (d *DirectoryInfo) isOrphaned(docID sserver1.DocumentID) (bool, string, error) {
+	d.keyGenLock.RLock()
+	relPath, err := libsearch.DocIDToPathname(docID, d.pathnameKeys)
+	d.keyGenLock.RUnlock()
+	if err != nil {
+		return false, "", nil
+	}
+
+	if _, err := os.Lstat(filepath.Join(d.absDir, relPath)); os.IsNotExist(err) {
+		return true, relPath, nil
+	} else if err != nil {
+		return false, relPath, err
+	}
+
+	entry, ok, err := d.manifest.get(relPath)
+	if err != nil {
+		return false, relPath, err
+	}
+	return ok && entry.DocID != docID, relPath, nil
+}
+
+// forEachDocument calls `f` with the document ID of each index of the TLF of
+// `dirInfo` on the server, listing them page by page.  Stops at the first
+// error returned by `f`.
+func (c *Client) forEachDocument(dirInfo *DirectoryInfo, f func(docID sserver1.DocumentID) error) error {
+	var cursor sserver1.DocumentID
+	for {
+		list, err := c.searchCli.ListDocuments(context.TODO(), sserver1.ListDocumentsArg{TlfID: dirInfo.tlfID, Cursor: cursor, Limit: listPageSize})
+		if err != nil {
+			return err
+		}
+		for _, docID := range list.Documents {
+			if err := f(docID); err != nil {
+				return err
+			}
+		}
+		if list.NextCursor == "" {
+			return nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// GarbageCollect deletes the indexes on the server of the files in `directory`
+// that no longer exist, including the ones deleted while the client was not
+// running, as well as the indexes superseded by ones of newer key generations.
+// Unlike `Reconcile`, this works even if the manifest has been lost.  Returns
+// the number of indexes deleted.
+func (c *Client) GarbageCollect(directory string) (int, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return 0, err
+	}
+
+	numDeleted := 0
+	err = c.forEachDocument(dirInfo, func(docID sserver1.DocumentID) error {
+		orphaned, relPath, err := dirInfo.isOrphaned(docID)
+		if err != nil || !orphaned {
+			return err
+		}
+		if err := c.searchCli.DeleteIndex(context.TODO(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID}); err != nil {
+			return err
+		}
+		numDeleted++
+
+		// Only drops the manifest entry if it refers to the deleted index.
+		if entry, ok, err := dirInfo.manifest.get(relPath); err != nil {
+			return err
+		} else if ok && entry.DocID == docID {
+			return dirInfo.manifest.delete(relPath)
+		}
+		return nil
+	})
+	return numDeleted, err
+}
+
+// Forget deletes the indexes on the server of all the files in `directory`
+// that can be decrypted with the keys of this client, whether the files still
+// exist or not, and empties the manifest of the directory, so that it is no
+// longer searchable until it is indexed again.  The directory should not be
+// reconciled or watched afterwards.  Returns the number of indexes deleted.
+func (c *Client) Forget(directory string) (int, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return 0, err
+	}
+
+	numDeleted := 0
+	err = c.forEachDocument(dirInfo, func(docID sserver1.DocumentID) error {
+		dirInfo.keyGenLock.RLock()
+		_, err := libsearch.DocIDToPathname(docID, dirInfo.pathnameKeys)
+		dirInfo.keyGenLock.RUnlock()
+		if err != nil {
+			return nil
+		}
+		if err := c.searchCli.DeleteIndex(context.TODO(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID}); err != nil {
+			return err
+		}
+		numDeleted++
+		return nil
+	})
+	if err != nil {
+		return numDeleted, err
+	}
+
+	entries, err := dirInfo.manifest.entries()
+	if err != nil {
+		return numDeleted, err
+	}
+	for relPath := range entries {
+		if err := dirInfo.manifest.delete(relPath); err != nil {
+			return numDeleted, err
+		}
+	}
+	return numDeleted, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/search/server"
+)
+
+// TestGarbageCollect tests the `GarbageCollect` function.  Checks that the
+// indexes of the files deleted while no client was running are deleted, even
+// by a client that has lost its manifest.
+func TestGarbageCollect(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	client1, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+
+	filenames := make([]string, 3)
+	for i, name := range []string{"kept", "deleted", "sub/deleted"} {
+		filenames[i] = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filenames[i]), 0777); err != nil {
+			t.Fatalf("error when creating test directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filenames[i], []byte("garbage content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client1.AddFile(dir, filenames[i]); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	if err := client1.Close(); err != nil {
+		t.Fatalf("error when closing the client: %s", err)
+	}
+
+	for _, filename := range filenames[1:] {
+		if err := os.Remove(filename); err != nil {
+			t.Fatalf("error when removing test file: %s", err)
+		}
+	}
+
+	client2, _ := startTestClient(t, dir, searchCli)
+	defer client2.Close()
+	numDeleted, err := client2.GarbageCollect(dir)
+	if err != nil {
+		t.Fatalf("error when garbage collecting: %s", err)
+	}
+	if numDeleted != 2 {
+		t.Fatalf("incorrect number of indexes deleted: %d", numDeleted)
+	}
+
+	result, err := client2.SearchWord(dir, "garbage")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(result, filenames[:1]) {
+		t.Fatalf("incorrect search result after garbage collection: %v", result)
+	}
+
+	if numDeleted, err := client2.GarbageCollect(dir); err != nil || numDeleted != 0 {
+		t.Fatalf("indexes deleted by a second garbage collection: %d %v", numDeleted, err)
+	}
+}
+
+// TestForget tests the `Forget` function.  Checks that the indexes of all the
+// files are deleted, including the ones missing from the manifest, and that
+// the manifest is emptied.
+func TestForget(t *testing.T) {
+	searchCli := server.CreateMemoryServer()
+	client1, dir := startTestClient(t, "", searchCli)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"first", "second"} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte("forgotten content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client1.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	if err := client1.Close(); err != nil {
+		t.Fatalf("error when closing the client: %s", err)
+	}
+
+	client2, _ := startTestClient(t, dir, searchCli)
+	defer client2.Close()
+	third := filepath.Join(dir, "third")
+	if err := ioutil.WriteFile(third, []byte("forgotten content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client2.AddFile(dir, third); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+
+	numDeleted, err := client2.Forget(dir)
+	if err != nil {
+		t.Fatalf("error when forgetting the directory: %s", err)
+	}
+	if numDeleted != 3 {
+		t.Fatalf("incorrect number of indexes deleted: %d", numDeleted)
+	}
+	if result, err := client2.SearchWord(dir, "forgotten"); err != nil || len(result) != 0 {
+		t.Fatalf("files found after forgetting the directory: %v %v", result, err)
+	}
+	if status, err := client2.Status(dir); err != nil || status.NumIndexed != 0 {
+		t.Fatalf("manifest not emptied: %+v %v", status, err)
+	}
+}
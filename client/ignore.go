@@ -0,0 +1,253 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The names of the files listing the patterns of the files left out of the
+// indexes.  Being hidden, they are never indexed.
+const (
+	ignoreFilename    = ".searchignore"
+	gitignoreFilename = ".gitignore"
+)
+
+// ignoreRule is a single pattern of an ignore file, with the semantics of the
+// gitignore patterns.
+type ignoreRule struct {
+	segments []string // The slash-separated segments of the pattern, where "**" matches any number of directories.
+	negate   bool     // Whether the pattern re-includes the files excluded by the previous patterns.
+	dirOnly  bool     // Whether the pattern only matches directories.
+	anchored bool     // Whether the pattern is matched against the path relative to the directory of the ignore file, rather than the name alone.
+}
+
+// parseIgnoreRule parses a line of an ignore file.  Returns false if the line
+// is blank, a comment, or an invalid pattern, which are all skipped like git
+// does.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return rule, false
+	}
+	// The trailing spaces are dropped unless they are escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	if line == "" {
+		return rule, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+	for i, segment := range rule.segments {
+		// The bracket expressions of git are negated by "!" as well as "^".
+		segment = strings.Replace(segment, "[!", "[^", -1)
+		if _, err := path.Match(segment, ""); err != nil {
+			return rule, false
+		}
+		rule.segments[i] = segment
+	}
+	return rule, true
+}
+
+// matchSegments returns whether the slash-separated `names` of a path are
+// matched by the pattern `segments`.
+func matchSegments(segments, names []string) bool {
+	if len(segments) == 0 {
+		return len(names) == 0
+	}
+	if segments[0] == "**" {
+		// A trailing "**" matches everything inside a directory, but not the
+		// directory itself.
+		if len(segments) == 1 {
+			return len(names) > 0
+		}
+		for i := 0; i <= len(names); i++ {
+			if matchSegments(segments[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(names) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(segments[0], names[0]); !ok {
+		return false
+	}
+	return matchSegments(segments[1:], names[1:])
+}
+
+// match returns whether the rule matches the file at the slash-separated
+// `relPath` relative to the directory of the ignore file.
+func (r ignoreRule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return matchSegments(r.segments, []string{path.Base(relPath)})
+	}
+	return matchSegments(r.segments, strings.Split(relPath, "/"))
+}
+
+// ignoreList is the rules of the ignore files of a single directory.
+type ignoreList struct {
+	dir   string       // The slash-separated path of the directory relative to the client directory, or "." for the client directory itself.
+	rules []ignoreRule // The rules in the order of the files, the last matching rule taking precedence.
+}
+
+// ignoreChain is the ignore lists applying to the files of a directory, from
+// the client directory down to the directory itself.
+type ignoreChain []ignoreList
+
+// match returns whether the file at `relPath` relative to the client directory
+// is ignored by the rules of the chain.  The rules of the deeper directories
+// take precedence, and the last matching rule of a directory takes precedence
+// over the others.  The parent directories of the file are not checked.
+func (c ignoreChain) match(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	for i := len(c) - 1; i >= 0; i-- {
+		subPath := relPath
+		if c[i].dir != "." {
+			if !strings.HasPrefix(relPath, c[i].dir+"/") {
+				continue
+			}
+			subPath = relPath[len(c[i].dir)+1:]
+		}
+		for j := len(c[i].rules) - 1; j >= 0; j-- {
+			if c[i].rules[j].match(subPath, isDir) {
+				return !c[i].rules[j].negate
+			}
+		}
+	}
+	return false
+}
+
+// readIgnoreFile returns the rules of the ignore file at `filename`, or none if
+// there is no such file.
+func readIgnoreFile(filename string) ([]ignoreRule, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// loadIgnores returns the ignore chain of the files in the directory `relDir`
+// relative to `root`, with the rules of the `.searchignore` files of `relDir`
+// and its parents, preceded by those of their `.gitignore` files if
+// `useGitignore` is true.  The chains already loaded are cached in `cache`,
+// which can be nil.
+func loadIgnores(root, relDir string, useGitignore bool, cache map[string]ignoreChain) (ignoreChain, error) {
+	if cached, ok := cache[relDir]; ok {
+		return cached, nil
+	}
+	var chain ignoreChain
+	if relDir != "." {
+		var err error
+		chain, err = loadIgnores(root, filepath.Dir(relDir), useGitignore, cache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filenames := []string{ignoreFilename}
+	if useGitignore {
+		filenames = []string{gitignoreFilename, ignoreFilename}
+	}
+	var rules []ignoreRule
+	for _, filename := range filenames {
+		fileRules, err := readIgnoreFile(filepath.Join(root, relDir, filename))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	if len(rules) > 0 {
+		chain = append(chain[:len(chain):len(chain)], ignoreList{dir: filepath.ToSlash(relDir), rules: rules})
+	}
+
+	if cache != nil {
+		cache[relDir] = chain
+	}
+	return chain, nil
+}
+
+// isIgnoredPath returns whether the file at `relPath` relative to `root`, or
+// any of its parent directories, is ignored by the ignore files.  A file in an
+// ignored directory cannot be re-included, like with git.
+func isIgnoredPath(root, relPath string, isDir, useGitignore bool, cache map[string]ignoreChain) (bool, error) {
+	names := strings.Split(relPath, string(filepath.Separator))
+	for i := range names {
+		subPath := filepath.Join(names[:i+1]...)
+		chain, err := loadIgnores(root, filepath.Dir(subPath), useGitignore, cache)
+		if err != nil {
+			return false, err
+		}
+		if chain.match(subPath, isDir || i < len(names)-1) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isIgnoreFile returns whether `relPath` is the path of an ignore file or of a
+// `.search_policy` file in a non-hidden directory, whose changes affect the
+// files that are indexed.
+func isIgnoreFile(relPath string) bool {
+	switch filepath.Base(relPath) {
+	case ignoreFilename, gitignoreFilename, policyFilename:
+		dir := filepath.Dir(relPath)
+		return dir == "." || !isHiddenPath(dir)
+	}
+	return false
+}
+
+// ignoreFile returns whether the file at `pathname` in `directory` is ignored
+// by the ignore files.  The index of an ignored file, such as a file moved to
+// an ignored directory, is deleted.
+func (c *Client) ignoreFile(directory, pathname string) (bool, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return false, err
+	}
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return false, err
+	}
+	ignored, err := isIgnoredPath(dirInfo.absDir, relPath, false, dirInfo.policy.UseGitignore, nil)
+	if err != nil || !ignored {
+		return false, err
+	}
+	return true, c.deleteIndexed(directory, relPath, pathname)
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestIgnoreChain tests the `match` method of `ignoreChain`.  Checks the
+// gitignore semantics of the patterns: the negations, the anchored and the
+// directory-only patterns, the "**" wildcards, and the precedence of the later
+// patterns and of the deeper directories.
+func TestIgnoreChain(t *testing.T) {
+	parse := func(lines ...string) []ignoreRule {
+		var rules []ignoreRule
+		for _, line := range lines {
+			if rule, ok := parseIgnoreRule(line); ok {
+				rules = append(rules, rule)
+			}
+		}
+		return rules
+	}
+	chain := ignoreChain{
+		{dir: ".", rules: parse("# comment", "", "*.log", "!keep.log", "/build", "out/", "docs/*.tmp", "**/cache/**", "a/**/z", "[!x]y.bak", "\\#notes ", "bad[")},
+		{dir: "src", rules: parse("!debug.log", "vendor")},
+	}
+	testCases := []struct {
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"server.log", false, true},
+		{"deep/dir/server.log", false, true},
+		{"keep.log", false, false},
+		{"src/debug.log", false, false},
+		{"debug.log", false, true},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"out", true, true},
+		{"src/out", true, true},
+		{"out", false, false},
+		{"docs/a.tmp", false, true},
+		{"docs/sub/a.tmp", false, false},
+		{"x/cache/file", false, true},
+		{"cache", true, false},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"ay.bak", false, true},
+		{"xy.bak", false, false},
+		{"#notes", false, true},
+		{"src/vendor", true, true},
+		{"vendor", true, false},
+		{"bad[", false, false},
+	}
+	for _, testCase := range testCases {
+		if actual := chain.match(filepath.FromSlash(testCase.relPath), testCase.isDir); actual != testCase.expected {
+			t.Fatalf("incorrect result for \"%s\": expected %t actual %t", testCase.relPath, testCase.expected, actual)
+		}
+	}
+}
+
+// TestIsIgnoredPath tests the `isIgnoredPath` function.  Checks that the rules
+// are read from the ignore files of the parent directories, that a file in an
+// ignored directory cannot be re-included, and that the `.gitignore` files
+// only apply when asked for.
+func TestIsIgnoredPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIsIgnoredPath")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "app", "node_modules"), 0777); err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	ignoreFiles := map[string]string{
+		ignoreFilename:                          "node_modules/\n*.key\n",
+		filepath.Join("app", ignoreFilename):    "!*.key\n!node_modules/lib.js\n",
+		filepath.Join("app", gitignoreFilename): "*.o\n",
+	}
+	for name, content := range ignoreFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing the ignore file: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		relPath      string
+		useGitignore bool
+		expected     bool
+	}{
+		{"secret.key", false, true},
+		{"app/public.key", false, false},
+		{"app/node_modules/lib.js", false, true},
+		{"app/main.o", false, false},
+		{"app/main.o", true, true},
+		{"app/main.go", true, false},
+	}
+	for _, testCase := range testCases {
+		ignored, err := isIgnoredPath(dir, filepath.FromSlash(testCase.relPath), false, testCase.useGitignore, make(map[string]ignoreChain))
+		if err != nil {
+			t.Fatalf("error when checking \"%s\": %s", testCase.relPath, err)
+		}
+		if ignored != testCase.expected {
+			t.Fatalf("incorrect result for \"%s\": expected %t actual %t", testCase.relPath, testCase.expected, ignored)
+		}
+	}
+
+	if !isIgnoreFile(ignoreFilename) || !isIgnoreFile(filepath.Join("app", gitignoreFilename)) {
+		t.Fatalf("ignore files not recognized")
+	}
+	if isIgnoreFile(filepath.Join(".git", ignoreFilename)) || isIgnoreFile("notes.searchignore") {
+		t.Fatalf("other files recognized as ignore files")
+	}
+}
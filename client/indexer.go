@@ -0,0 +1,219 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// IndexError is returned when some of the files cannot be indexed.  All the
+// other files are still indexed.
+type IndexError struct {
+	Errors []FileError // The errors of the files that cannot be indexed.
+}
+
+// Error implements the error interface.
+func (e *IndexError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fileErr := range e.Errors {
+		msgs[i] = fileErr.Error()
+	}
+	return fmt.Sprintf("cannot index %d file(s): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Indexer builds the indexes of the files of a client directory on a pool of
+// workers, and uploads them to the server in batches.  The workers are blocked
+// once a full batch is waiting to be uploaded, so that the memory used by the
+// built indexes stays bounded.
+type Indexer struct {
+	cli        *Client         // The client that owns the directory.
+	dirInfo    *DirectoryInfo  // The information of the indexed directory.
+	numWorkers int             // The number of files indexed concurrently.
+	batchSize  int             // The maximum number of indexes uploaded in a single round trip.
+	onError    func(FileError) // The function to report the files that cannot be indexed.
+	errLock    sync.Mutex      // Serializes the calls to `onError`.
+}
+
+// CreateIndexer creates an `Indexer` for `directory`, which must be one of the
+// directories of `cli`.  The files are indexed on `numWorkers` concurrent
+// workers, and the indexes are written to the server in batches of up to
+// `batchSize`.  The files that cannot be indexed are passed to `onError`,
+// which is never called concurrently.
+func CreateIndexer(cli *Client, directory string, numWorkers, batchSize int, onError func(FileError)) (*Indexer, error) {
+	dirInfo, err := cli.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Indexer{
+		cli:        cli,
+		dirInfo:    dirInfo,
+		numWorkers: numWorkers,
+		batchSize:  batchSize,
+		onError:    onError,
+	}, nil
+}
+
+// fail reports that the file at `pathname` cannot be indexed.
+func (idx *Indexer) fail(pathname string, err error) {
+	idx.errLock.Lock()
+	defer idx.errLock.Unlock()
+	idx.onError(FileError{Filename: pathname, Err: err})
+}
+
+// writeBatch writes the indexes in `batch` to the server in a single round
+// trip and records the written ones in the manifest.  Returns the number of
+// indexes written.
+func (idx *Indexer) writeBatch(batch []builtIndex) int {
+	items := make([]sserver1.IndexItem, len(batch))
+	for i, built := range batch {
+		items[i] = built.item
+	}
+
+	results, err := idx.cli.searchCli.WriteIndexes(context.TODO(), sserver1.WriteIndexesArg{TlfID: idx.dirInfo.tlfID, Indexes: items})
+	if err == nil && len(results) != len(batch) {
+		err = errors.New("unexpected number of results from the server")
+	}
+	if err != nil {
+		for _, built := range batch {
+			idx.fail(filepath.Join(idx.dirInfo.absDir, built.relPath), err)
+		}
+		return 0
+	}
+
+	numWritten := 0
+	for i, built := range batch {
+		if results[i].Error != "" {
+			err = errors.New(results[i].Error)
+		} else {
+			err = idx.cli.commitIndex(idx.dirInfo, built)
+		}
+		if err != nil {
+			idx.fail(filepath.Join(idx.dirInfo.absDir, built.relPath), err)
+			continue
+		}
+		numWritten++
+	}
+	return numWritten
+}
+
+// Run indexes the files whose absolute paths are received from `pathnames`,
+// until the channel is closed.  Each batch is uploaded while the next one is
+// being built.  Returns the number of files indexed.  If `ctx` is cancelled,
+// the indexes not uploaded yet are dropped and `ctx.Err()` is returned.
+func (idx *Indexer) Run(ctx context.Context, pathnames <-chan string) (int, error) {
+	// The buffer lets the workers build the next batch during an upload, and
+	// blocks them once it is full.
+	builtCh := make(chan builtIndex, idx.batchSize)
+	var wg sync.WaitGroup
+	for i := 0; i < idx.numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var pathname string
+				var ok bool
+				select {
+				case pathname, ok = <-pathnames:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+				built, err := idx.dirInfo.buildIndex(pathname)
+				if err != nil {
+					idx.fail(pathname, err)
+					continue
+				}
+				select {
+				case builtCh <- built:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(builtCh)
+	}()
+
+	numIndexed := 0
+	batch := make([]builtIndex, 0, idx.batchSize)
+	for built := range builtCh {
+		if ctx.Err() != nil {
+			// Drains the indexes built before the workers noticed.
+			continue
+		}
+		batch = append(batch, built)
+		if len(batch) == idx.batchSize {
+			numIndexed += idx.writeBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return numIndexed, err
+	}
+	if len(batch) > 0 {
+		numIndexed += idx.writeBatch(batch)
+	}
+	return numIndexed, nil
+}
+
+// IndexFiles indexes the files with the given absolute `pathnames`.  See `Run`
+// for the details.
+func (idx *Indexer) IndexFiles(ctx context.Context, pathnames []string) (int, error) {
+	pathCh := make(chan string)
+	go func() {
+		defer close(pathCh)
+		for _, pathname := range pathnames {
+			select {
+			case pathCh <- pathname:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return idx.Run(ctx, pathCh)
+}
+
+// AddFiles indexes the files in `directory` with the given `pathnames` on
+// `numWorkers` concurrent workers, and writes the indexes to the server in
+// batches of up to `batchSize`.  If some of the files cannot be indexed, the
+// others are still written and the failures are returned in an
+// `*IndexError`.
+func (c *Client) AddFiles(ctx context.Context, directory string, pathnames []string, numWorkers, batchSize int) error {
+	var fileErrs []FileError
+	idx, err := CreateIndexer(c, directory, numWorkers, batchSize, func(fileErr FileError) {
+		fileErrs = append(fileErrs, fileErr)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := idx.IndexFiles(ctx, pathnames); err != nil {
+		return err
+	}
+	if len(fileErrs) > 0 {
+		sort.Sort(fileErrorSlice(fileErrs))
+		return &IndexError{Errors: fileErrs}
+	}
+	return nil
+}
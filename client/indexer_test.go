@@ -0,0 +1,199 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fileErrorRecorder records the file errors reported by an `Indexer`.  The
+// errors are reported from the worker goroutines, where a test cannot fail, so
+// they are checked once the indexing returns.
+type fileErrorRecorder struct {
+	lock sync.Mutex  // The mutex to protect `errs`.
+	errs []FileError // The errors reported so far.
+}
+
+// record records `fileErr`.  Safe to call from several goroutines.
+func (r *fileErrorRecorder) record(fileErr FileError) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errs = append(r.errs, fileErr)
+}
+
+// errors returns the errors recorded so far.
+func (r *fileErrorRecorder) errors() []FileError {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]FileError(nil), r.errs...)
+}
+
+// TestAddFiles tests the `AddFiles` function.  Checks that all the valid files
+// are indexed across several batches, and that the invalid ones are reported.
+func TestAddFiles(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	var pathnames, expected []string
+	for i := 0; i < 10; i++ {
+		filename := filepath.Join(dir, "testBatchFile"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filename, []byte("batched content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		pathnames = append(pathnames, filename)
+		expected = append(expected, filename)
+	}
+	nonExisting := filepath.Join(dir, "nonExisting")
+	pathnames = append(pathnames, nonExisting)
+
+	err := client.AddFiles(context.Background(), dir, pathnames, 3, 4)
+	indexErr, ok := err.(*IndexError)
+	if !ok {
+		t.Fatalf("no index error returned for the non-existing file: %v", err)
+	}
+	if len(indexErr.Errors) != 1 || indexErr.Errors[0].Filename != nonExisting || !os.IsNotExist(indexErr.Errors[0].Err) {
+		t.Fatalf("incorrect index errors: %v", indexErr.Errors)
+	}
+
+	actual, err := client.SearchWordStrict(dir, "batched")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+
+	if err := client.AddFiles(context.Background(), dir, pathnames[:3], 0, 0); err != nil {
+		t.Fatalf("error when adding the files again: %s", err)
+	}
+}
+
+// TestIndexerCancel tests the cancellation of an `Indexer`.  Checks that a
+// cancelled run stops without indexing the remaining files.
+func TestIndexerCancel(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	filename := filepath.Join(dir, "cancelledFile")
+	if err := ioutil.WriteFile(filename, []byte("cancelled content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	var recorder fileErrorRecorder
+	idx, err := CreateIndexer(client, dir, 2, 2, recorder.record)
+	if err != nil {
+		t.Fatalf("error when creating the indexer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The channel is never closed, so the run only stops on the cancellation.
+	pathCh := make(chan string)
+	numIndexed, err := idx.Run(ctx, pathCh)
+	if err != context.Canceled {
+		t.Fatalf("incorrect error for a cancelled run: %v", err)
+	}
+	if numIndexed != 0 {
+		t.Fatalf("files indexed after the cancellation: %d", numIndexed)
+	}
+
+	numIndexed, err = idx.IndexFiles(context.Background(), []string{filename})
+	if err != nil || numIndexed != 1 {
+		t.Fatalf("incorrect result when indexing the file: %d, %v", numIndexed, err)
+	}
+	if fileErrs := recorder.errors(); len(fileErrs) > 0 {
+		t.Fatalf("unexpected file errors: %v", fileErrs)
+	}
+}
+
+// generateTestCorpus writes `numFiles` files of `numWords` random words from
+// the dictionary of the prototype test file generator into `dir`, the same way
+// as `prototype/test/testfile.go` does.  Returns the paths of the files.
+func generateTestCorpus(b *testing.B, dir string, numFiles, numWords int) []string {
+	dict, err := os.Open(filepath.Join("..", "prototype", "test", "dictionary.txt"))
+	if err != nil {
+		b.Fatalf("error when opening the dictionary: %s", err)
+	}
+	defer dict.Close()
+	scanner := bufio.NewScanner(dict)
+	scanner.Split(bufio.ScanWords)
+	var words []string
+	for scanner.Scan() {
+		words = append(words, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		b.Fatalf("error when reading the dictionary: %s", err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	pathnames := make([]string, numFiles)
+	for i := range pathnames {
+		pathnames[i] = filepath.Join(dir, "testFile"+strconv.Itoa(i))
+		outfile, err := os.Create(pathnames[i])
+		if err != nil {
+			b.Fatalf("error when creating test file: %s", err)
+		}
+		writer := bufio.NewWriter(outfile)
+		for j := 0; j < numWords; j++ {
+			fmt.Fprintln(writer, words[rng.Intn(len(words))])
+		}
+		if err := writer.Flush(); err != nil {
+			b.Fatalf("error when writing test file: %s", err)
+		}
+		outfile.Close()
+	}
+	return pathnames
+}
+
+// BenchmarkIndexer measures the throughput of an `Indexer` on a generated
+// corpus with different numbers of workers.  Each iteration indexes the whole
+// corpus, and the throughput is reported in bytes of files per second.
+func BenchmarkIndexer(b *testing.B) {
+	client, dir := startTestClient(b, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+	pathnames := generateTestCorpus(b, dir, 200, 200)
+	var corpusSize int64
+	for _, pathname := range pathnames {
+		info, err := os.Stat(pathname)
+		if err != nil {
+			b.Fatalf("error when reading test file: %s", err)
+		}
+		corpusSize += info.Size()
+	}
+
+	for _, numWorkers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", numWorkers), func(b *testing.B) {
+			var recorder fileErrorRecorder
+			idx, err := CreateIndexer(client, dir, numWorkers, reconcileBatchSize, recorder.record)
+			if err != nil {
+				b.Fatalf("error when creating the indexer: %s", err)
+			}
+			b.SetBytes(corpusSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := idx.IndexFiles(context.Background(), pathnames); err != nil {
+					b.Fatalf("error when indexing the files: %s", err)
+				}
+				if fileErrs := recorder.errors(); len(fileErrs) > 0 {
+					b.Fatalf("unexpected file errors: %v", fileErrs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/keybase/kbfs/libkbfs"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// manifestEntry records the state of a file when its index was last uploaded
+// to the search server.
+type manifestEntry struct {
+	Hash          []byte              // The SHA-256 hash of the content of the file.
+	Size          int64               // The size of the file in bytes.
+	ModTime       int64               // The modification time of the file in nanoseconds since the Unix epoch.
+	KeyGen        libkbfs.KeyGen      // The key generation the index is built with.
+	DocID         sserver1.DocumentID // The document ID the index is stored under.
+	Normalization int                 // The version of the normalization scheme the index is built with, or 0 for the entries recorded before the versioning.
+	Extraction    int                 // The version of the text extraction the index is built with, or 0 for the entries recorded before the extraction.
+}
+
+// isCurrent returns whether the index of the entry is built with `keyGen`, the
+// normalization scheme `version` and the current text extraction, so that it
+// does not need to be rebuilt.
+func (e manifestEntry) isCurrent(keyGen libkbfs.KeyGen, version int) bool {
+	return e.KeyGen == keyGen && e.Normalization == version && e.Extraction == extractionVersion
+}
+
+// manifest is the persistent local record of the indexes uploaded for the
+// files of a client directory, keyed by the relative paths of the files.
+type manifest struct {
+	db *leveldb.DB // The database storing the JSON-encoded entries.
+}
+
+// openManifest opens the manifest stored in the goleveldb database at
+// `dbPath`.  The database is created if it does not exist yet.
+func openManifest(dbPath string) (*manifest, error) {
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{db: db}, nil
+}
+
+// close closes the manifest.
+func (m *manifest) close() error {
+	return m.db.Close()
+}
+
+// get returns the entry of `relPath`, and whether such an entry exists.
+func (m *manifest) get(relPath string) (manifestEntry, bool, error) {
+	var entry manifestEntry
+	entryJSON, err := m.db.Get([]byte(relPath), nil)
+	if err == leveldb.ErrNotFound {
+		return entry, false, nil
+	} else if err != nil {
+		return entry, false, err
+	}
+	if err := json.Unmarshal(entryJSON, &entry); err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+// put stores `entry` as the entry of `relPath`.
+func (m *manifest) put(relPath string, entry manifestEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return m.db.Put([]byte(relPath), entryJSON, nil)
+}
+
+// rename atomically replaces the entry of `orig` with `entry` as the entry of
+// `curr`.
+func (m *manifest) rename(orig, curr string, entry manifestEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete([]byte(orig))
+	batch.Put([]byte(curr), entryJSON)
+	return m.db.Write(batch, nil)
+}
+
+// delete removes the entry of `relPath`.  Deleting a non-existing entry is a
+// no-op.
+func (m *manifest) delete(relPath string) error {
+	return m.db.Delete([]byte(relPath), nil)
+}
+
+// entries returns all the entries in the manifest, keyed by the relative
+// paths.
+func (m *manifest) entries() (map[string]manifestEntry, error) {
+	iter := m.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	entries := make(map[string]manifestEntry)
+	for iter.Next() {
+		var entry manifestEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			return nil, err
+		}
+		entries[string(iter.Key())] = entry
+	}
+	return entries, iter.Error()
+}
+
+// hashFile returns the SHA-256 hash of the content of `file`, and rewinds the
+// file afterwards.
+func hashFile(file *os.File) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
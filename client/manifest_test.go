@@ -0,0 +1,71 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestManifest tests the `manifest` functions.  Checks that the entries are
+// properly stored, renamed and deleted, and kept across reopens.
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestManifest")
+	if err != nil {
+		t.Fatalf("error when creating the manifest directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := openManifest(dir)
+	if err != nil {
+		t.Fatalf("error when opening the manifest: %s", err)
+	}
+
+	entry1 := manifestEntry{Hash: []byte("hash1"), Size: 10, ModTime: 100, KeyGen: 1, DocID: "doc1"}
+	entry2 := manifestEntry{Hash: []byte("hash2"), Size: 20, ModTime: 200, KeyGen: 1, DocID: "doc2"}
+	if err := m.put("file1", entry1); err != nil {
+		t.Fatalf("error when putting the entry: %s", err)
+	}
+	if err := m.put("dir/file2", entry2); err != nil {
+		t.Fatalf("error when putting the entry: %s", err)
+	}
+
+	if entry, ok, err := m.get("file1"); err != nil || !ok || !reflect.DeepEqual(entry, entry1) {
+		t.Fatalf("incorrect entry returned: %v %t %v", entry, ok, err)
+	}
+	if _, ok, err := m.get("nonexisting"); err != nil || ok {
+		t.Fatalf("entry returned for a non-existing file: %t %v", ok, err)
+	}
+
+	entry1.DocID = "doc3"
+	if err := m.rename("file1", "file3", entry1); err != nil {
+		t.Fatalf("error when renaming the entry: %s", err)
+	}
+	if err := m.delete("dir/file2"); err != nil {
+		t.Fatalf("error when deleting the entry: %s", err)
+	}
+	if err := m.delete("dir/file2"); err != nil {
+		t.Fatalf("error when deleting a non-existing entry: %s", err)
+	}
+
+	if err := m.close(); err != nil {
+		t.Fatalf("error when closing the manifest: %s", err)
+	}
+	m, err = openManifest(dir)
+	if err != nil {
+		t.Fatalf("error when reopening the manifest: %s", err)
+	}
+	defer m.close()
+
+	entries, err := m.entries()
+	if err != nil {
+		t.Fatalf("error when listing the entries: %s", err)
+	}
+	if !reflect.DeepEqual(entries, map[string]manifestEntry{"file3": entry1}) {
+		t.Fatalf("incorrect entries: %v", entries)
+	}
+}
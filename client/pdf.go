@@ -0,0 +1,331 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"unicode/utf16"
+)
+
+// The text of a PDF is extracted from the strings shown by the text operators
+// of its content streams, which are either uncompressed or compressed with
+// `FlateDecode`.  The strings are decoded as UTF-16 if they start with a byte
+// order mark, and as Latin-1 otherwise, which matches the standard encodings
+// of the simple fonts.
+// NOTE: The strings of the composite fonts are glyph IDs, whose text is only
+// given by the font, so the PDFs using them have no text extracted.
+
+// pdfWordSpacing is the displacement in thousandths of a text unit beyond
+// which the adjacent strings of a `TJ` array are separated by a space.
+const pdfWordSpacing = 200
+
+// pdfOperand is an operand of an operator of a PDF content stream.
+type pdfOperand struct {
+	text    []byte       // The bytes of a string.
+	isText  bool         // Whether the operand is a string.
+	number  float64      // The value of a number.
+	isArray bool         // Whether the operand is an array.
+	array   []pdfOperand // The elements of an array.
+}
+
+// isPDFSpace returns whether `c` is a white-space character of PDF.
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+// isPDFDelimiter returns whether `c` ends a regular token of PDF.
+func isPDFDelimiter(c byte) bool {
+	return isPDFSpace(c) || bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// readPDFString reads the literal string starting after the opening
+// parenthesis at `data[i]`, and returns its bytes and the index after it.
+func readPDFString(data []byte, i int) ([]byte, int) {
+	var str []byte
+	depth := 1
+	for i++; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return str, i + 1
+			}
+		case c == '\\' && i+1 < len(data):
+			i++
+			c = data[i]
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// A line continuation.
+				if c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := 0
+					for j := 0; j < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; j++ {
+						value = value*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					c = byte(value)
+				}
+			}
+		}
+		str = append(str, c)
+	}
+	return str, i
+}
+
+// readPDFHexString reads the hexadecimal string starting after the opening
+// angle bracket at `data[i]`, and returns its bytes and the index after it.
+func readPDFHexString(data []byte, i int) ([]byte, int) {
+	var str []byte
+	var digits []byte
+	for i++; i < len(data) && data[i] != '>'; i++ {
+		if value, err := strconv.ParseUint(string(data[i]), 16, 8); err == nil {
+			digits = append(digits, byte(value))
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, 0)
+	}
+	for j := 0; j < len(digits); j += 2 {
+		str = append(str, digits[j]<<4|digits[j+1])
+	}
+	return str, i + 1
+}
+
+// decodePDFString returns the text of the bytes of a string.
+func decodePDFString(str []byte) string {
+	if len(str) >= 2 && str[0] == 0xfe && str[1] == 0xff {
+		units := make([]uint16, 0, len(str)/2)
+		for i := 2; i+1 < len(str); i += 2 {
+			units = append(units, uint16(str[i])<<8|uint16(str[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(str))
+	for i, c := range str {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// writePDFText writes the text shown by the text operators of the content
+// stream `data` to `buf`.  The strings of a text object are put on the same
+// line unless the operators move to another line.
+func writePDFText(buf *bytes.Buffer, data []byte) {
+	var operands []pdfOperand
+	var arrayStarts []int // The indexes in `operands` of the open arrays.
+	inText := false
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(data) && data[i] != '\r' && data[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			var str []byte
+			str, i = readPDFString(data, i)
+			operands = append(operands, pdfOperand{text: str, isText: true})
+		case c == '<' && i+1 < len(data) && data[i+1] == '<', c == '>' && i+1 < len(data) && data[i+1] == '>':
+			i += 2
+		case c == '<':
+			var str []byte
+			str, i = readPDFHexString(data, i)
+			operands = append(operands, pdfOperand{text: str, isText: true})
+		case c == '[':
+			arrayStarts = append(arrayStarts, len(operands))
+			i++
+		case c == ']':
+			if len(arrayStarts) > 0 {
+				start := arrayStarts[len(arrayStarts)-1]
+				arrayStarts = arrayStarts[:len(arrayStarts)-1]
+				array := append([]pdfOperand(nil), operands[start:]...)
+				operands = append(operands[:start], pdfOperand{isArray: true, array: array})
+			}
+			i++
+		default:
+			start := i
+			for i++; i < len(data) && !isPDFDelimiter(data[i]); i++ {
+			}
+			token := string(data[start:i])
+			if c == '/' {
+				// A name is only an operand of the operators ignored here.
+				operands = append(operands, pdfOperand{})
+				continue
+			}
+			if number, err := strconv.ParseFloat(token, 64); err == nil {
+				operands = append(operands, pdfOperand{number: number})
+				continue
+			}
+			if len(arrayStarts) == 0 {
+				inText = writePDFOperator(buf, token, operands, inText)
+				operands = operands[:0]
+			}
+		}
+	}
+}
+
+// writePDFOperator writes the text shown by the content stream operator `op`
+// with `operands` to `buf`.  `inText` tells whether the operator is inside a
+// text object, and the return value whether the next one is.
+func writePDFOperator(buf *bytes.Buffer, op string, operands []pdfOperand, inText bool) bool {
+	switch op {
+	case "BT":
+		return true
+	case "ET":
+		if inText {
+			buf.WriteByte('\n')
+		}
+		return false
+	}
+	if !inText {
+		return false
+	}
+	switch op {
+	case "Tj", "'", "\"":
+		if op != "Tj" {
+			buf.WriteByte('\n')
+		}
+		if len(operands) > 0 && operands[len(operands)-1].isText {
+			buf.WriteString(decodePDFString(operands[len(operands)-1].text))
+		}
+	case "TJ":
+		if len(operands) > 0 && operands[len(operands)-1].isArray {
+			for _, element := range operands[len(operands)-1].array {
+				if element.isText {
+					buf.WriteString(decodePDFString(element.text))
+				} else if -element.number > pdfWordSpacing {
+					buf.WriteByte(' ')
+				}
+			}
+		}
+	case "Td", "TD":
+		if len(operands) == 2 && operands[1].number != 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	case "T*":
+		buf.WriteByte('\n')
+	case "Tm":
+		buf.WriteByte(' ')
+	}
+	return true
+}
+
+// pdfOtherFilters are the names of the stream filters other than
+// `FlateDecode`, which are used for the binary content.
+var pdfOtherFilters = [][]byte{
+	[]byte("/ASCIIHexDecode"), []byte("/ASCII85Decode"), []byte("/LZWDecode"), []byte("/RunLengthDecode"),
+	[]byte("/CCITTFaxDecode"), []byte("/JBIG2Decode"), []byte("/DCTDecode"), []byte("/JPXDecode"), []byte("/Crypt"),
+}
+
+// hasPDFOtherFilter returns whether the filters of a stream `filters` include
+// a filter other than `FlateDecode`.
+func hasPDFOtherFilter(filters []byte) bool {
+	for _, filter := range pdfOtherFilters {
+		if bytes.Contains(filters, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfStreams returns the decoded content of the uncompressed and the
+// `FlateDecode` streams of the PDF `data`.  The streams that cannot be decoded
+// are left out, and decompressing more than `budget` bytes in all is an error.
+func pdfStreams(data []byte, budget int64) ([][]byte, error) {
+	var streams [][]byte
+	dictStart := 0
+	for {
+		i := bytes.Index(data[dictStart:], []byte("stream"))
+		if i < 0 {
+			return streams, nil
+		}
+		i += dictStart
+		start := i + len("stream")
+		if i > 0 && data[i-1] == 'd' {
+			// The "endstream" keyword of a stream left out.
+			dictStart = start
+			continue
+		}
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			return streams, nil
+		}
+		end += start
+		dict := data[dictStart:i]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		dictStart = end + len("endstream")
+
+		content := data[start:end]
+		if filter := bytes.Index(dict, []byte("/Filter")); filter >= 0 {
+			if !bytes.Contains(dict[filter:], []byte("/FlateDecode")) || hasPDFOtherFilter(dict[filter:]) {
+				continue
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, err = readLimited(reader, &budget)
+			if err == errExtractionLimit {
+				return nil, err
+			} else if err != nil && len(content) == 0 {
+				continue
+			}
+		}
+		streams = append(streams, content)
+	}
+}
+
+// ExtractPDF is the extractor of the PDFs, which returns the text shown by
+// their content streams.  The streams decompressed beyond
+// `extractionLimit(size)` are an error.
+func ExtractPDF(r io.ReaderAt, size int64) (io.Reader, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	streams, err := pdfStreams(data, extractionLimit(size))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, stream := range streams {
+		writePDFText(&buf, stream)
+	}
+	return &buf, nil
+}
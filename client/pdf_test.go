@@ -0,0 +1,70 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// createPDF returns a PDF with a stream of each of the `contents`, with the
+// corresponding `filters`.  The contents filtered with `FlateDecode` are
+// compressed.
+func createPDF(t *testing.T, contents []string, filters []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, content := range contents {
+		data := []byte(content)
+		if filters[i] == "/FlateDecode" {
+			var compressed bytes.Buffer
+			w := zlib.NewWriter(&compressed)
+			if _, err := w.Write(data); err != nil {
+				t.Fatalf("error when compressing the stream: %s", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("error when compressing the stream: %s", err)
+			}
+			data = compressed.Bytes()
+		}
+		buf.WriteString(strconv.Itoa(i+1) + " 0 obj\n<< /Length " + strconv.Itoa(len(data)))
+		if filters[i] != "" {
+			buf.WriteString(" /Filter " + filters[i])
+		}
+		buf.WriteString(" >>\nstream\r\n")
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return buf.Bytes()
+}
+
+// TestExtractPDF tests the `ExtractPDF` function.  Checks that the strings of
+// the text objects are extracted from the uncompressed and the compressed
+// streams, and that the other streams are left out.
+func TestExtractPDF(t *testing.T) {
+	contents := []string{
+		"BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\)) Tj 0 -14 Td [(W) 120 (orld) -300 (again)] TJ ET",
+		"q 1 0 0 1 0 0 cm (not shown) Tj Q BT <FEFF00630061006600E9> Tj T* (caf\\351) ' ET",
+		"BT (image) Tj ET",
+	}
+	pdf := createPDF(t, contents, []string{"", "/FlateDecode", "[/FlateDecode /DCTDecode]"})
+	expected := []string{"Hello (PDF)", "World again", "café", "café"}
+	if lines := textLines(readText(t, ExtractPDF, pdf)); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("incorrect text: expected %q actual %q", expected, lines)
+	}
+
+	if text := readText(t, ExtractPDF, []byte("%PDF-1.4\n1 0 obj\n<< /Length 10 >>\nstream\nBT (trunc")); text != "" {
+		t.Fatalf("text returned for a truncated PDF: %q", text)
+	}
+
+	bomb := createPDF(t, []string{strings.Repeat(" ", 1<<20)}, []string{"/FlateDecode"})
+	if _, err := ExtractPDF(bytes.NewReader(bomb), int64(len(bomb))); err != errExtractionLimit {
+		t.Fatalf("incorrect error for a compressed stream beyond the limit: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// policyFilename is the name of the files overriding the index policy for the
+// files under their directories.  Being hidden, they are never indexed.
+const policyFilename = ".search_policy"
+
+// The reasons why a file is skipped by an index policy.
+const (
+	SkipTooLarge      = "larger than the maximum file size"
+	SkipBinary        = "binary content"
+	SkipDeniedExt     = "denied extension"
+	SkipNotAllowedExt = "extension not allowed"
+)
+
+// IndexPolicy decides which files of a directory are indexed.  The zero value
+// indexes all the files.  The policy of a client directory can be overridden
+// for the files under any of its subdirectories by a `.search_policy` file,
+// holding a JSON object with any of the fields "max_file_size", "skip_binary",
+// "allow_extensions" and "deny_extensions".  The overrides of a directory
+// also apply to its subdirectories unless they are overridden again.
+type IndexPolicy struct {
+	MaxFileSize     int64    // The maximum size in bytes of the indexed files, or 0 for no maximum.
+	SkipBinary      bool     // Whether the files with binary content are skipped.  The documents whose text can be extracted are not binary.
+	AllowExtensions []string // If not empty, the extensions of the only files indexed, with or without their leading dots.
+	DenyExtensions  []string // The extensions of the files never indexed, with or without their leading dots.
+	UseGitignore    bool     // Whether the patterns of the `.gitignore` files apply along with those of the `.searchignore` files.  Not overridden by the `.search_policy` files.
+}
+
+// policyOverride is the content of a `.search_policy` file.  The fields left
+// out keep the values of the parent directory.
+type policyOverride struct {
+	MaxFileSize     *int64    `json:"max_file_size"`
+	SkipBinary      *bool     `json:"skip_binary"`
+	AllowExtensions *[]string `json:"allow_extensions"`
+	DenyExtensions  *[]string `json:"deny_extensions"`
+}
+
+// apply returns `policy` with the fields set in the override.
+func (o policyOverride) apply(policy IndexPolicy) IndexPolicy {
+	if o.MaxFileSize != nil {
+		policy.MaxFileSize = *o.MaxFileSize
+	}
+	if o.SkipBinary != nil {
+		policy.SkipBinary = *o.SkipBinary
+	}
+	if o.AllowExtensions != nil {
+		policy.AllowExtensions = *o.AllowExtensions
+	}
+	if o.DenyExtensions != nil {
+		policy.DenyExtensions = *o.DenyExtensions
+	}
+	return policy
+}
+
+// SkippedFile records a file left out of the indexes by the index policy.  It
+// implements the error interface, so that the files skipped by a `Watcher`
+// are reported along with its errors.
+type SkippedFile struct {
+	Filename string // The absolute path of the file.
+	Reason   string // The reason why the file is skipped.
+}
+
+// Error implements the error interface.
+func (s SkippedFile) Error() string {
+	return fmt.Sprintf("%s: skipped (%s)", s.Filename, s.Reason)
+}
+
+// hasExtension returns whether `extensions` contain the extension `ext`, which
+// is lowercase and without its leading dot.
+func hasExtension(extensions []string, ext string) bool {
+	for _, extension := range extensions {
+		if strings.ToLower(strings.TrimPrefix(extension, ".")) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinaryFile returns whether the file at `pathname` has binary content,
+// i.e. its leading bytes are not sniffed as text and its text cannot be
+// extracted.
+func isBinaryFile(pathname string) (bool, error) {
+	file, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, fileTypeHeadLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if getExtractor(pathname, head[:n]) != nil {
+		return false, nil
+	}
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	return err != nil || !strings.HasPrefix(mimeType, "text/"), nil
+}
+
+// skipReason returns the reason why the file at `pathname` with `info` is
+// skipped by the policy, or "" if it is indexed.  Only the leading bytes of the
+// file are read to find out whether it is binary.
+func (p IndexPolicy) skipReason(pathname string, info os.FileInfo) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(info.Name()), "."))
+	switch {
+	case hasExtension(p.DenyExtensions, ext):
+		return SkipDeniedExt, nil
+	case len(p.AllowExtensions) > 0 && !hasExtension(p.AllowExtensions, ext):
+		return SkipNotAllowedExt, nil
+	case p.MaxFileSize > 0 && info.Size() > p.MaxFileSize:
+		return SkipTooLarge, nil
+	case p.SkipBinary:
+		binary, err := isBinaryFile(pathname)
+		if err != nil {
+			return "", err
+		} else if binary {
+			return SkipBinary, nil
+		}
+	}
+	return "", nil
+}
+
+// loadPolicy returns the index policy of the files in the directory `relDir`
+// relative to `root`, whose own policy is `policy`, with the overrides of the
+// `.search_policy` files of `relDir` and its parents.  The policies already
+// loaded are cached in `cache`, which can be nil.
+func loadPolicy(root, relDir string, policy IndexPolicy, cache map[string]IndexPolicy) (IndexPolicy, error) {
+	if cached, ok := cache[relDir]; ok {
+		return cached, nil
+	}
+	if relDir != "." {
+		var err error
+		policy, err = loadPolicy(root, filepath.Dir(relDir), policy, cache)
+		if err != nil {
+			return IndexPolicy{}, err
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, relDir, policyFilename))
+	if err == nil {
+		var override policyOverride
+		if err := json.Unmarshal(content, &override); err != nil {
+			return IndexPolicy{}, fmt.Errorf("invalid %s in \"%s\": %s", policyFilename, relDir, err)
+		}
+		policy = override.apply(policy)
+	} else if !os.IsNotExist(err) {
+		return IndexPolicy{}, err
+	}
+
+	if cache != nil {
+		cache[relDir] = policy
+	}
+	return policy, nil
+}
+
+// SetIndexPolicy sets the index policy of `directory`, which must be one of
+// the directories of the client.  Should be called before the directory is
+// reconciled or watched.  The files already indexed that are skipped by the
+// policy are removed from the indexes by the next reconciliation.
+func (c *Client) SetIndexPolicy(directory string, policy IndexPolicy) error {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return err
+	}
+	dirInfo.policy = policy
+	return nil
+}
+
+// skipFile returns the reason why the file at `pathname` in `directory` is
+// skipped by the index policy, or "" if it is indexed.  The index of a skipped
+// file, such as a file that has grown too large, is deleted.
+func (c *Client) skipFile(directory, pathname string) (string, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return "", err
+	}
+	policy, err := loadPolicy(dirInfo.absDir, filepath.Dir(relPath), dirInfo.policy, nil)
+	if err != nil {
+		return "", err
+	}
+	reason, err := policy.skipReason(pathname, info)
+	if err != nil || reason == "" {
+		return "", err
+	}
+
+	return reason, c.deleteIndexed(directory, relPath, pathname)
+}
+
+// deleteIndexed deletes the index of the file at `pathname` in `directory`,
+// whose path relative to the directory is `relPath`, if it is indexed.
+func (c *Client) deleteIndexed(directory, relPath, pathname string) error {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return err
+	}
+	if _, ok, err := dirInfo.manifest.get(relPath); err != nil || !ok {
+		return err
+	}
+	return c.DeleteFile(directory, pathname)
+}
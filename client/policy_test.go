@@ -0,0 +1,102 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestSkipReason tests the `skipReason` function.  Checks that the files are
+// skipped by their extensions, sizes and contents, and that the documents
+// whose text can be extracted are not binary.
+func TestSkipReason(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSkipReason")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy := IndexPolicy{MaxFileSize: 100, SkipBinary: true, AllowExtensions: []string{"txt", ".pdf", ""}, DenyExtensions: []string{"log"}}
+	testCases := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"notes.TXT", "plain text", ""},
+		{"empty", "", ""},
+		{"server.log", "plain text", SkipDeniedExt},
+		{"photo.png", "plain text", SkipNotAllowedExt},
+		{"big.txt", string(make([]byte, 101)), SkipTooLarge},
+		{"zeros.txt", string(make([]byte, 100)), SkipBinary},
+		{"report.pdf", "%PDF-1.4\n\x00\x01\x02", ""},
+	}
+	for _, testCase := range testCases {
+		filename := filepath.Join(dir, testCase.name)
+		if err := ioutil.WriteFile(filename, []byte(testCase.content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatalf("error when reading the file information: %s", err)
+		}
+		reason, err := policy.skipReason(filename, info)
+		if err != nil {
+			t.Fatalf("error when checking \"%s\": %s", testCase.name, err)
+		}
+		if reason != testCase.expected {
+			t.Fatalf("incorrect reason for \"%s\": expected \"%s\" actual \"%s\"", testCase.name, testCase.expected, reason)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "zeros.txt"))
+	if err != nil {
+		t.Fatalf("error when reading the file information: %s", err)
+	}
+	if reason, err := (IndexPolicy{}).skipReason(filepath.Join(dir, "zeros.txt"), info); err != nil || reason != "" {
+		t.Fatalf("file skipped by the zero policy: \"%s\" %v", reason, err)
+	}
+}
+
+// TestLoadPolicy tests the `loadPolicy` function.  Checks that the overrides
+// of a directory apply to its subdirectories, and only for the fields they
+// set.
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLoadPolicy")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "media", "clips", "raw"), 0777); err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	overrides := map[string]string{
+		"media":       `{"max_file_size": 1000, "deny_extensions": ["mov"]}`,
+		"media/clips": `{"skip_binary": false}`,
+	}
+	for relDir, content := range overrides {
+		if err := ioutil.WriteFile(filepath.Join(dir, relDir, policyFilename), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing the policy file: %s", err)
+		}
+	}
+
+	base := IndexPolicy{MaxFileSize: 10, SkipBinary: true, AllowExtensions: []string{"txt"}}
+	cache := make(map[string]IndexPolicy)
+	policy, err := loadPolicy(dir, filepath.Join("media", "clips", "raw"), base, cache)
+	if err != nil {
+		t.Fatalf("error when loading the policy: %s", err)
+	}
+	expected := IndexPolicy{MaxFileSize: 1000, SkipBinary: false, AllowExtensions: []string{"txt"}, DenyExtensions: []string{"mov"}}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Fatalf("incorrect policy: expected %+v actual %+v", expected, policy)
+	}
+	if len(cache) != 4 || !reflect.DeepEqual(cache["."], base) {
+		t.Fatalf("incorrect cached policies: %v", cache)
+	}
+}
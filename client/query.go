@@ -0,0 +1,555 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// The keywords of the query language.  Operators must be in upper case so
+// that the lower case words can still be searched for.
+const (
+	queryAnd = "AND"
+	queryOr  = "OR"
+	queryNot = "NOT"
+)
+
+// queryWildcard matches any sequence of characters in a term of a query.  The
+// documents possibly matching a term with wildcards are found by the part of
+// the term before its first wildcard, so the term must start with a prefix.
+const queryWildcard = "*"
+
+// queryQuote delimits a phrase in a query.  The words of a phrase must be
+// adjacent and in order in the matching documents.
+const queryQuote = '"'
+
+// queryFieldSeparator separates the field from its value in a field term of a
+// query, such as "name:invoice".  A field term is matched by the files with
+// the words of the value in that field of their relative pathnames, instead of
+// in their contents.
+const queryFieldSeparator = ":"
+
+// Query is a parsed boolean query over keywords, supporting the AND, OR and
+// NOT operators and parentheses.  Adjacent terms without an operator in
+// between are implicitly joined with AND.  A term can contain `*` wildcards
+// after a prefix, such as "config*", be a quoted phrase of several words, or
+// be restricted to a field of the pathnames, such as "ext:pdf".
+type Query struct {
+	tokens []sserver1.QueryToken // The query in postfix notation.
+	terms  []string              // The terms, indexed by the `Term` field of the tokens.  Either the words and phrases of a parsed query or the keywords and keyword phrases of an analyzed one.
+}
+
+// queryParser holds the state of the recursive descent parser for queries.
+type queryParser struct {
+	words []string // The words and parentheses of the query.
+	pos   int      // The position of the next word to be parsed.
+	query *Query   // The query being built.
+}
+
+// splitQuery splits `input` into words, treating the parentheses as separate
+// words and each quoted phrase as a single word, with the spaces between its
+// words collapsed.  Returns an error if a quote is not closed.
+func splitQuery(input string) ([]string, error) {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for i := 0; i < len(input); {
+		c, size := utf8.DecodeRuneInString(input[i:])
+		i += size
+		switch {
+		case unicode.IsSpace(c):
+			flush()
+		case c == '(' || c == ')':
+			flush()
+			words = append(words, string(c))
+		case c == queryQuote:
+			flush()
+			end := strings.IndexRune(input[i:], queryQuote)
+			if end < 0 {
+				return nil, errors.New("missing closing quote in query")
+			}
+			phrase := strings.Join(strings.Fields(input[i:i+end]), " ")
+			words = append(words, string(queryQuote)+phrase+string(queryQuote))
+			i += end + 1
+		default:
+			word = append(word, c)
+		}
+	}
+	flush()
+	return words, nil
+}
+
+// ParseQuery parses `input` into a `Query`.  The grammar, from the lowest to
+// the highest precedence, is:
+//
+//	query   := andExpr ("OR" andExpr)*
+//	andExpr := notExpr (["AND"] notExpr)*
+//	notExpr := "NOT" notExpr | "(" query ")" | term | '"' term* '"' | field ":" term
+//	field   := "name" | "path" | "ext"
+//
+// Returns an error if `input` is empty or malformed.
+func ParseQuery(input string) (*Query, error) {
+	words, err := splitQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{
+		words: words,
+		query: new(Query),
+	}
+	if len(p.words) == 0 {
+		return nil, errors.New("empty query")
+	}
+	if err := p.parseOr(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.words) {
+		return nil, fmt.Errorf("unexpected \"%s\" in query", p.words[p.pos])
+	}
+	return p.query, nil
+}
+
+// peek returns the next word to be parsed, or an empty string if all the
+// words have been parsed.
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.words) {
+		return ""
+	}
+	return p.words[p.pos]
+}
+
+// emit appends an operator to the postfix query.
+func (p *queryParser) emit(op sserver1.QueryOp) {
+	p.query.tokens = append(p.query.tokens, sserver1.QueryToken{Op: op})
+}
+
+// parseOr parses a `query` production.
+func (p *queryParser) parseOr() error {
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+	for p.peek() == queryOr {
+		p.pos++
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+		p.emit(sserver1.QueryOp_OR)
+	}
+	return nil
+}
+
+// parseAnd parses an `andExpr` production.
+func (p *queryParser) parseAnd() error {
+	if err := p.parseNot(); err != nil {
+		return err
+	}
+	for {
+		switch p.peek() {
+		case "", ")", queryOr:
+			return nil
+		case queryAnd:
+			p.pos++
+		}
+		if err := p.parseNot(); err != nil {
+			return err
+		}
+		p.emit(sserver1.QueryOp_AND)
+	}
+}
+
+// parseNot parses a `notExpr` production.
+func (p *queryParser) parseNot() error {
+	word := p.peek()
+	switch word {
+	case "":
+		return errors.New("unexpected end of query")
+	case queryNot:
+		p.pos++
+		if err := p.parseNot(); err != nil {
+			return err
+		}
+		p.emit(sserver1.QueryOp_NOT)
+		return nil
+	case "(":
+		p.pos++
+		if err := p.parseOr(); err != nil {
+			return err
+		}
+		if p.peek() != ")" {
+			return errors.New("missing closing parenthesis in query")
+		}
+		p.pos++
+		return nil
+	case ")", queryAnd, queryOr:
+		return fmt.Errorf("unexpected \"%s\" in query", word)
+	}
+
+	p.pos++
+	// The words are only turned into keywords once the analyzer of the
+	// searched directory is known, but a word without any letter or digit can
+	// never be searched for.
+	if libsearch.NormalizeKeyword(word) == "" {
+		return fmt.Errorf("invalid term \"%s\" in query", word)
+	}
+	p.query.appendTerm(word)
+	return nil
+}
+
+// appendTerm appends `term` to the postfix query, reusing the position of an
+// identical earlier term.
+func (q *Query) appendTerm(term string) {
+	index := -1
+	for i, t := range q.terms {
+		if t == term {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		index = len(q.terms)
+		q.terms = append(q.terms, term)
+	}
+	q.tokens = append(q.tokens, sserver1.QueryToken{Op: sserver1.QueryOp_TERM, Term: index})
+}
+
+// appendConjunction appends the conjunction of `terms` to the postfix query.
+func (q *Query) appendConjunction(terms []string) {
+	for i, term := range terms {
+		q.appendTerm(term)
+		if i > 0 {
+			q.tokens = append(q.tokens, sserver1.QueryToken{Op: sserver1.QueryOp_AND})
+		}
+	}
+}
+
+// equalStrings returns whether `a` and `b` hold the same strings in the same
+// order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// analyzeWildcard normalizes the parts of the wildcard `word` between the
+// wildcards with `analyzer`.  Returns an error if the word does not start with
+// a prefix.
+func analyzeWildcard(word string, analyzer libsearch.Analyzer) (string, error) {
+	parts := strings.Split(word, queryWildcard)
+	for i, part := range parts {
+		parts[i] = analyzer.Normalize(part)
+	}
+	if parts[0] == "" {
+		return "", fmt.Errorf("wildcard term \"%s\" does not start with a prefix", word)
+	}
+	return strings.Join(parts, queryWildcard), nil
+}
+
+// isWildcardTerm returns whether the analyzed `term` contains wildcards.
+func isWildcardTerm(term string) bool {
+	return strings.Contains(term, queryWildcard)
+}
+
+// wildcardPrefix returns the prefix of the analyzed wildcard `term` before its
+// first wildcard.
+func wildcardPrefix(term string) string {
+	return term[:strings.Index(term, queryWildcard)]
+}
+
+// matchWildcard returns whether `keyword` is matched by the analyzed wildcard
+// `term`.
+func matchWildcard(term, keyword string) bool {
+	parts := strings.Split(term, queryWildcard)
+	if !strings.HasPrefix(keyword, parts[0]) {
+		return false
+	}
+	keyword = keyword[len(parts[0]):]
+	// The earliest match of each middle part leaves the most room for the
+	// following ones.
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(keyword, part)
+		if i < 0 {
+			return false
+		}
+		keyword = keyword[i+len(part):]
+	}
+	return strings.HasSuffix(keyword, parts[len(parts)-1])
+}
+
+// isPhrase returns whether `word` is a quoted phrase, either as a word of a
+// parsed query or as a term of an analyzed one.
+func isPhrase(word string) bool {
+	return len(word) > 0 && word[0] == queryQuote
+}
+
+// analyzePhrase returns the keywords of the words of the quoted `phrase`
+// given by `analyzer`, in order.  Returns an error if the phrase has
+// wildcards.
+func analyzePhrase(phrase string, analyzer libsearch.Analyzer) ([]string, error) {
+	if strings.Contains(phrase, queryWildcard) {
+		return nil, fmt.Errorf("wildcards are not supported in phrase %s", phrase)
+	}
+	var keywords []string
+	for _, word := range strings.Fields(phrase[1 : len(phrase)-1]) {
+		keywords = append(keywords, analyzer.Keywords(word)...)
+	}
+	return keywords, nil
+}
+
+// phraseTerm returns the analyzed term of the phrase of `keywords`.
+func phraseTerm(keywords []string) string {
+	return string(queryQuote) + strings.Join(keywords, " ") + string(queryQuote)
+}
+
+// phraseKeywords returns the keywords of the analyzed phrase `term` in order.
+func phraseKeywords(term string) []string {
+	return strings.Fields(term[1 : len(term)-1])
+}
+
+// parseField splits `word` of a parsed query into its field and its value.
+// The third return value is false if the word is not a field term.
+func parseField(word string) (string, string, bool) {
+	i := strings.Index(word, queryFieldSeparator)
+	if i < 0 || i+1 == len(word) || !libsearch.IsField(word[:i]) {
+		return "", "", false
+	}
+	return word[:i], word[i+1:], true
+}
+
+// fieldTerm returns the analyzed term of `keyword` in `field`.  The field and
+// the keyword are separated by a space, which never appears in a keyword, so
+// that the term is never confused with a keyword containing the separator.
+func fieldTerm(field, keyword string) string {
+	return field + queryFieldSeparator + " " + keyword
+}
+
+// splitFieldTerm splits the analyzed `term` into its field and its keyword.
+// The third return value is false if the term is not a field term.
+func splitFieldTerm(term string) (string, string, bool) {
+	if isPhrase(term) {
+		return "", "", false
+	}
+	i := strings.Index(term, queryFieldSeparator+" ")
+	if i < 0 {
+		return "", "", false
+	}
+	return term[:i], term[i+len(queryFieldSeparator)+1:], true
+}
+
+// isFieldTerm returns whether the analyzed `term` is a field term.
+func isFieldTerm(term string) bool {
+	_, _, ok := splitFieldTerm(term)
+	return ok
+}
+
+// analyzeField returns the field terms of the keywords of the field term
+// `word` given by `analyzer`, whose value is split into words in the same way
+// as the pathnames.  Returns an error if the value has wildcards or no
+// keyword.
+func analyzeField(word string, analyzer libsearch.Analyzer) ([]string, error) {
+	field, value, _ := parseField(word)
+	if strings.Contains(value, queryWildcard) {
+		return nil, fmt.Errorf("wildcards are not supported in field term \"%s\"", word)
+	}
+	keywords := libsearch.FieldKeywords(value, analyzer)
+	if len(keywords) == 0 {
+		return nil, fmt.Errorf("invalid term \"%s\" in query", word)
+	}
+	terms := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		terms[i] = fieldTerm(field, keyword)
+	}
+	return terms, nil
+}
+
+// termMatcher finds the terms of an analyzed query matched by the keywords of
+// a document.  The keywords must be given in the order of the document, so
+// that the phrases can be matched across them.  The field terms are never
+// matched, as they are about the pathname of the document.
+type termMatcher struct {
+	exact     map[string]bool     // The terms without wildcards.
+	wildcards []string            // The terms with wildcards.
+	phrases   map[string][]string // The map from the phrase terms to their keywords.
+	recent    []string            // The most recent keywords, up to the number of keywords of the longest phrase.
+	maxRecent int                 // The number of keywords of the longest phrase.
+}
+
+// createTermMatcher creates a `termMatcher` for the analyzed `terms`.
+func createTermMatcher(terms []string) *termMatcher {
+	m := &termMatcher{exact: make(map[string]bool, len(terms))}
+	for _, term := range terms {
+		switch {
+		case isFieldTerm(term):
+			continue
+		case isPhrase(term):
+			if m.phrases == nil {
+				m.phrases = make(map[string][]string)
+			}
+			keywords := phraseKeywords(term)
+			m.phrases[term] = keywords
+			if len(keywords) > m.maxRecent {
+				m.maxRecent = len(keywords)
+			}
+		case isWildcardTerm(term):
+			m.wildcards = append(m.wildcards, term)
+		default:
+			m.exact[term] = true
+		}
+	}
+	return m
+}
+
+// match calls `f` with each of the terms matched by `keyword`, including the
+// phrases ending with it.
+func (m *termMatcher) match(keyword string, f func(term string)) {
+	if m.exact[keyword] {
+		f(keyword)
+	}
+	for _, term := range m.wildcards {
+		if matchWildcard(term, keyword) {
+			f(term)
+		}
+	}
+	if m.maxRecent == 0 {
+		return
+	}
+	if len(m.recent) == m.maxRecent {
+		m.recent = append(m.recent[:0], m.recent[1:]...)
+	}
+	m.recent = append(m.recent, keyword)
+	for term, keywords := range m.phrases {
+		if len(keywords) <= len(m.recent) && equalStrings(keywords, m.recent[len(m.recent)-len(keywords):]) {
+			f(term)
+		}
+	}
+}
+
+// analyze returns the query with each of its words replaced by the
+// conjunction of the keywords of the word given by `analyzer`.  So that the
+// indexes built under `libsearch.LegacyNormalization` stay searchable, a word
+// whose legacy keywords differ is replaced by the disjunction of the two
+// conjunctions instead.  A word with wildcards is kept as a single term with
+// its parts normalized.  A phrase is kept as a single term of the keywords of
+// its words, unless it has a single keyword.  A field term is replaced by the
+// conjunction of the field terms of its keywords, and a metadata term is kept
+// as is.  Returns an error if a word, a phrase or a field term has no
+// keyword.
+func (q *Query) analyze(analyzer libsearch.Analyzer) (*Query, error) {
+	var legacy libsearch.Analyzer
+	if analyzer.Normalization().Version != libsearch.LegacyNormalizationVersion {
+		legacy = analyzer.WithNormalization(libsearch.LegacyNormalization)
+	}
+
+	analyzed := new(Query)
+	for _, token := range q.tokens {
+		if token.Op != sserver1.QueryOp_TERM {
+			analyzed.tokens = append(analyzed.tokens, token)
+			continue
+		}
+		word := q.terms[token.Term]
+		if isFieldTerm(word) {
+			// The metadata terms added by `FilterQuery` are already
+			// analyzed.
+			analyzed.appendTerm(word)
+			continue
+		}
+		if isPhrase(word) {
+			keywords, err := analyzePhrase(word, analyzer)
+			if err != nil {
+				return nil, err
+			}
+			switch len(keywords) {
+			case 0:
+				return nil, fmt.Errorf("invalid phrase %s in query", word)
+			case 1:
+				analyzed.appendTerm(keywords[0])
+			default:
+				analyzed.appendTerm(phraseTerm(keywords))
+			}
+			continue
+		}
+		if _, _, ok := parseField(word); ok {
+			terms, err := analyzeField(word, analyzer)
+			if err != nil {
+				return nil, err
+			}
+			analyzed.appendConjunction(terms)
+			continue
+		}
+		if strings.Contains(word, queryWildcard) {
+			pattern, err := analyzeWildcard(word, analyzer)
+			if err != nil {
+				return nil, err
+			}
+			analyzed.appendTerm(pattern)
+			continue
+		}
+		keywords := analyzer.Keywords(word)
+		if len(keywords) == 0 {
+			return nil, fmt.Errorf("invalid term \"%s\" in query", word)
+		}
+		analyzed.appendConjunction(keywords)
+		if legacy == nil {
+			continue
+		}
+		if legacyKeywords := legacy.Keywords(word); len(legacyKeywords) > 0 && !equalStrings(keywords, legacyKeywords) {
+			analyzed.appendConjunction(legacyKeywords)
+			analyzed.tokens = append(analyzed.tokens, sserver1.QueryToken{Op: sserver1.QueryOp_OR})
+		}
+	}
+	return analyzed, nil
+}
+
+// Terms returns the words of the query.
+func (q *Query) Terms() []string {
+	return q.terms
+}
+
+// String returns the query in a fully parenthesized form.
+func (q *Query) String() string {
+	var stack []string
+	for _, token := range q.tokens {
+		switch token.Op {
+		case sserver1.QueryOp_TERM:
+			stack = append(stack, q.terms[token.Term])
+		case sserver1.QueryOp_NOT:
+			stack[len(stack)-1] = queryNot + " " + stack[len(stack)-1]
+		default:
+			op := queryAnd
+			if token.Op == sserver1.QueryOp_OR {
+				op = queryOr
+			}
+			lhs, rhs := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1] = "(" + lhs + " " + op + " " + rhs + ")"
+		}
+	}
+	return strings.Join(stack, "")
+}
+
+// evaluate evaluates the query, calling `match` to find out whether a term is
+// matched.
+func (q *Query) evaluate(match func(term string) bool) (bool, error) {
+	return libsearch.EvaluateQuery(q.tokens, len(q.terms), func(term int) bool {
+		return match(q.terms[term])
+	})
+}
@@ -0,0 +1,249 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// testParseQueryHelper checks that parsing `input` yields a query with the
+// `expected` string form and `terms`, or an error if `expected` is empty.
+func testParseQueryHelper(t *testing.T, input, expected string, terms []string) {
+	query, err := ParseQuery(input)
+	if expected == "" {
+		if err == nil {
+			t.Fatalf("expecting error for query \"%s\", no error returned", input)
+		}
+		return
+	} else if err != nil {
+		t.Fatalf("unexpected error for query \"%s\": %s", input, err)
+	}
+	if query.String() != expected {
+		t.Fatalf("incorrect query parsed for \"%s\": expected \"%s\" actual \"%s\"", input, expected, query.String())
+	}
+	if !reflect.DeepEqual(query.Terms(), terms) {
+		t.Fatalf("incorrect terms parsed for \"%s\": expected %v actual %v", input, terms, query.Terms())
+	}
+}
+
+// TestParseQuery tests multiple edge cases for the `ParseQuery` function.
+func TestParseQuery(t *testing.T) {
+	testParseQueryHelper(t, "word", "word", []string{"word"})
+	testParseQueryHelper(t, "Hello World", "(Hello AND World)", []string{"Hello", "World"})
+	testParseQueryHelper(t, "a AND b OR c", "((a AND b) OR c)", []string{"a", "b", "c"})
+	testParseQueryHelper(t, "a OR b c", "(a OR (b AND c))", []string{"a", "b", "c"})
+	testParseQueryHelper(t, "a AND (b OR c)", "(a AND (b OR c))", []string{"a", "b", "c"})
+	testParseQueryHelper(t, "NOT a b", "(NOT a AND b)", []string{"a", "b"})
+	testParseQueryHelper(t, "NOT (a OR b)", "NOT (a OR b)", []string{"a", "b"})
+	testParseQueryHelper(t, "NOT NOT a", "NOT NOT a", []string{"a"})
+	testParseQueryHelper(t, "(a)OR(a)", "(a OR a)", []string{"a"})
+	testParseQueryHelper(t, "and or not", "((and AND or) AND not)", []string{"and", "or", "not"})
+	testParseQueryHelper(t, "", "", nil)
+	testParseQueryHelper(t, "a AND", "", nil)
+	testParseQueryHelper(t, "OR a", "", nil)
+	testParseQueryHelper(t, "(a OR b", "", nil)
+	testParseQueryHelper(t, "a OR b)", "", nil)
+	testParseQueryHelper(t, "a NOT", "", nil)
+	testParseQueryHelper(t, "a ()", "", nil)
+	testParseQueryHelper(t, "a AND --", "", nil)
+	testParseQueryHelper(t, "conf* NOT *.go", "(conf* AND NOT *.go)", []string{"conf*", "*.go"})
+	testParseQueryHelper(t, "*", "", nil)
+	testParseQueryHelper(t, "\"quick  brown\" fox", "(\"quick brown\" AND fox)", []string{"\"quick brown\"", "fox"})
+	testParseQueryHelper(t, "NOT\"a OR b\"", "NOT \"a OR b\"", []string{"\"a OR b\""})
+	testParseQueryHelper(t, "(\"a b\")", "\"a b\"", []string{"\"a b\""})
+	testParseQueryHelper(t, "\"a b", "", nil)
+	testParseQueryHelper(t, "\"\"", "", nil)
+	testParseQueryHelper(t, "\"-- --\" word", "", nil)
+	testParseQueryHelper(t, "name:invoice OR NOT ext:pdf", "(name:invoice OR NOT ext:pdf)", []string{"name:invoice", "ext:pdf"})
+}
+
+// TestAnalyzeQuery tests the `analyze` function.  Checks that each word is
+// replaced by the conjunction of its keywords.
+func TestAnalyzeQuery(t *testing.T) {
+	query, err := ParseQuery("snake_case OR NOT Word")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+
+	analyzed, err := query.analyze(libsearch.ProseAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "(snakecase OR NOT word)"; analyzed.String() != expected {
+		t.Fatalf("incorrect prose query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+
+	analyzed, err = query.analyze(libsearch.CodeAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "(((snakecase AND snake) AND case) OR NOT word)"; analyzed.String() != expected {
+		t.Fatalf("incorrect code query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+	if expected := []string{"snakecase", "snake", "case", "word"}; !reflect.DeepEqual(analyzed.Terms(), expected) {
+		t.Fatalf("incorrect code query terms: expected %v actual %v", expected, analyzed.Terms())
+	}
+
+	// The legacy keywords are only searched for when they differ.
+	query, err = ParseQuery("Straße AND cafe")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	analyzed, err = query.analyze(libsearch.ProseAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "((strasse OR straße) AND cafe)"; analyzed.String() != expected {
+		t.Fatalf("incorrect migration query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+	analyzed, err = query.analyze(libsearch.ProseAnalyzer.WithNormalization(libsearch.LegacyNormalization))
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "(straße AND cafe)"; analyzed.String() != expected {
+		t.Fatalf("incorrect legacy query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+}
+
+// TestAnalyzeWildcard tests the analysis of the terms with wildcards.  Checks
+// that the parts of a term are normalized and that a term must start with a
+// prefix.
+func TestAnalyzeWildcard(t *testing.T) {
+	query, err := ParseQuery("Conf*.Go* OR straße*")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	analyzed, err := query.analyze(libsearch.ProseAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "(conf*go* OR strasse*)"; analyzed.String() != expected {
+		t.Fatalf("incorrect wildcard query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+
+	query, err = ParseQuery("*.go")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	if _, err := query.analyze(libsearch.ProseAnalyzer); err == nil {
+		t.Fatalf("no error returned for a wildcard term without a prefix")
+	}
+}
+
+// TestTermMatcher tests the `termMatcher` type.  Checks that a keyword matches
+// the identical terms and the wildcard terms matching it.
+func TestTermMatcher(t *testing.T) {
+	matcher := createTermMatcher([]string{"config", "conf*", "c*f*g", "con*ion", "file*"})
+	testCases := map[string][]string{
+		"config":        {"config", "conf*", "c*f*g"},
+		"configuration": {"conf*", "con*ion"},
+		"conion":        {"con*ion"},
+		"cfg":           {"c*f*g"},
+		"profile":       nil,
+	}
+	for keyword, expected := range testCases {
+		var actual []string
+		matcher.match(keyword, func(term string) {
+			actual = append(actual, term)
+		})
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect terms matched by \"%s\": expected %v actual %v", keyword, expected, actual)
+		}
+	}
+}
+
+// TestAnalyzePhrase tests the analysis of the phrases.  Checks that a phrase
+// is kept as a single term of the keywords of its words, and that a phrase
+// with a single keyword becomes a plain term.
+func TestAnalyzePhrase(t *testing.T) {
+	query, err := ParseQuery("\"The getUserName call\" OR \"Word\"")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	analyzed, err := query.analyze(libsearch.CodeAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "(\"the getusername get user name call\" OR word)"; analyzed.String() != expected {
+		t.Fatalf("incorrect phrase query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+	if keywords := phraseKeywords(analyzed.Terms()[0]); !reflect.DeepEqual(keywords, []string{"the", "getusername", "get", "user", "name", "call"}) {
+		t.Fatalf("incorrect phrase keywords: %v", keywords)
+	}
+
+	query, err = ParseQuery("\"conf* file\"")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	if _, err := query.analyze(libsearch.ProseAnalyzer); err == nil {
+		t.Fatalf("no error returned for a phrase with wildcards")
+	}
+}
+
+// TestTermMatcherPhrase tests the matching of the phrases by `termMatcher`.
+// Checks that a phrase is matched at its last keyword, only when its keywords
+// are adjacent and in order.
+func TestTermMatcherPhrase(t *testing.T) {
+	matcher := createTermMatcher([]string{"\"quick brown fox\"", "\"brown fox\"", "fox"})
+	var matched []string
+	for _, keyword := range []string{"the", "quick", "brown", "dog", "brown", "fox", "quick", "brown", "fox"} {
+		matcher.match(keyword, func(term string) {
+			matched = append(matched, keyword+":"+term)
+		})
+	}
+	sort.Strings(matched)
+	expected := []string{"fox:\"brown fox\"", "fox:\"brown fox\"", "fox:\"quick brown fox\"", "fox:fox", "fox:fox"}
+	if !reflect.DeepEqual(matched, expected) {
+		t.Fatalf("incorrect phrase matches: expected %v actual %v", expected, matched)
+	}
+}
+
+// TestAnalyzeField tests the analysis of the field terms.  Checks that the
+// value of a field term is split into keywords in the same way as the
+// pathnames, that only the known fields are recognized, and that the field
+// terms are never matched by the keywords of the contents.
+func TestAnalyzeField(t *testing.T) {
+	query, err := ParseQuery("path:Invoices/2024 ext:PDF title:Report mailto:")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	analyzed, err := query.analyze(libsearch.ProseAnalyzer)
+	if err != nil {
+		t.Fatalf("error when analyzing the query: %s", err)
+	}
+	if expected := "((((path: invoices AND path: 2024) AND ext: pdf) AND titlereport) AND mailto)"; analyzed.String() != expected {
+		t.Fatalf("incorrect field query: expected \"%s\" actual \"%s\"", expected, analyzed.String())
+	}
+	field, keyword, ok := splitFieldTerm(analyzed.Terms()[2])
+	if !ok || field != libsearch.FieldExt || keyword != "pdf" {
+		t.Fatalf("incorrect field term split: %s %s %v", field, keyword, ok)
+	}
+	if isFieldTerm("\"path: invoices\"") || isFieldTerm("path:invoices") {
+		t.Fatalf("phrase or keyword taken for a field term")
+	}
+
+	for _, input := range []string{"name:conf*", "ext:--"} {
+		query, err := ParseQuery(input)
+		if err != nil {
+			t.Fatalf("error when parsing the query: %s", err)
+		}
+		if _, err := query.analyze(libsearch.ProseAnalyzer); err == nil {
+			t.Fatalf("no error returned for the field term \"%s\"", input)
+		}
+	}
+
+	matcher := createTermMatcher([]string{fieldTerm(libsearch.FieldName, "pdf"), "pdf"})
+	var matched []string
+	matcher.match("pdf", func(term string) {
+		matched = append(matched, term)
+	})
+	if !reflect.DeepEqual(matched, []string{"pdf"}) {
+		t.Fatalf("incorrect terms matched: %v", matched)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"math"
+	"sort"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// RankedFile is a file returned by a ranked search, along with its relevance
+// score.
+type RankedFile struct {
+	Filename string  // The absolute path of the file.
+	Score    float64 // The TF-IDF relevance score of the file.
+}
+
+// rankedFileSlice attaches the methods of sort.Interface to []RankedFile,
+// sorting in decreasing order of the scores, and then in increasing order of
+// the filenames.
+type rankedFileSlice []RankedFile
+
+func (p rankedFileSlice) Len() int { return len(p) }
+func (p rankedFileSlice) Less(i, j int) bool {
+	if p[i].Score != p[j].Score {
+		return p[i].Score > p[j].Score
+	}
+	return p[i].Filename < p[j].Filename
+}
+func (p rankedFileSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// countTermsInFile reads the text of the file at `filename` and returns the number of
+// occurrences of each of the analyzed `terms` in it.  A wildcard term counts
+// the occurrences of all the keywords it matches, and a field term occurs
+// once if it is matched by the pathname of the file relative to `root`.
+func countTermsInFile(root, filename string, terms []string, analyzer libsearch.Analyzer) (map[string]int, error) {
+	found, err := findFieldTerms(root, filename, terms, analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := openText(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer text.Close()
+
+	matcher := createTermMatcher(terms)
+	counts := make(map[string]int, len(terms))
+	for term := range found {
+		counts[term] = 1
+	}
+	err = libsearch.ScanKeywordsStream(text, analyzer, func(keyword string) bool {
+		matcher.match(keyword, func(term string) {
+			counts[term]++
+		})
+		return true
+	})
+	return counts, err
+}
+
+// tfIdf returns the TF-IDF weight of a term occurring `tf` times in a document
+// and possibly contained in `df` out of `numDocuments` documents.  The inverse
+// document frequency is smoothed so that a term contained in every document
+// still contributes to the score.
+func tfIdf(tf, df, numDocuments int) float64 {
+	if tf == 0 {
+		return 0
+	}
+	if df < 1 {
+		df = 1
+	}
+	return (1 + math.Log(float64(tf))) * math.Log(1+float64(numDocuments)/float64(df))
+}
+
+// rerankFiles verifies the top `numRerank` of the `filenames` under `root`
+// ranked by the server against `query`, and re-ranks them by their TF-IDF
+// scores over the positive terms of the query.  The document frequencies
+// `docFrequencies`, indexed in the same way as the terms of the query, and
+// `numDocuments` come from the server.  The rest of the files, which are not
+// verified, are left out.  Files that turn out not to match the query are dropped, and those that
+// cannot be read are reported in a `*VerificationError`.
+func rerankFiles(root string, filenames []string, query *Query, analyzer libsearch.Analyzer, docFrequencies []int, numDocuments int, numRerank int) ([]RankedFile, error) {
+	if numRerank > len(filenames) {
+		numRerank = len(filenames)
+	}
+	positiveTerms := query.positiveTerms()
+
+	var reranked []RankedFile
+	var fileErrs []FileError
+	for _, filename := range filenames[:numRerank] {
+		counts, err := countTermsInFile(root, filename, query.terms, analyzer)
+		if err != nil {
+			fileErrs = append(fileErrs, FileError{Filename: filename, Err: err})
+			continue
+		}
+		matched, err := query.evaluate(func(term string) bool {
+			return counts[term] > 0
+		})
+		if err != nil {
+			return nil, err
+		} else if !matched {
+			continue
+		}
+
+		score := 0.0
+		for i, term := range query.terms {
+			if positiveTerms[term] && i < len(docFrequencies) {
+				score += tfIdf(counts[term], docFrequencies[i], numDocuments)
+			}
+		}
+		reranked = append(reranked, RankedFile{Filename: filename, Score: score})
+	}
+	sort.Sort(rankedFileSlice(reranked))
+
+	if len(fileErrs) > 0 {
+		return reranked, &VerificationError{Errors: fileErrs}
+	}
+	return reranked, nil
+}
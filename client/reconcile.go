@@ -0,0 +1,235 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ReconcileResult summarizes the changes made by a reconciliation pass.
+type ReconcileResult struct {
+	Written   int           // The number of indexes written for new or modified files.
+	Renamed   int           // The number of indexes renamed for moved files.
+	Deleted   int           // The number of indexes deleted for removed files.
+	Unchanged int           // The number of files whose indexes are already up to date.
+	Skipped   []SkippedFile // The files skipped by the index policy.
+}
+
+// ReconcileError is returned by `Reconcile` when some of the files cannot be
+// reconciled.  All the other files are still reconciled.
+type ReconcileError struct {
+	Errors []FileError // The errors of the files that cannot be reconciled.
+}
+
+// Error implements the error interface.
+func (e *ReconcileError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fileErr := range e.Errors {
+		msgs[i] = fileErr.Error()
+	}
+	return fmt.Sprintf("cannot reconcile %d file(s): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// hashPath returns the SHA-256 hash of the content of the file at `pathname`.
+func hashPath(pathname string) ([]byte, error) {
+	file, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return hashFile(file)
+}
+
+// reconcileBatchSize is the number of indexes written to the server in a
+// single round trip when reconciling.
+const reconcileBatchSize = 64
+
+// contentKey returns the key identifying a file by its content, used to detect
+// the files that have been moved.
+func contentKey(hash []byte, size int64) string {
+	return hex.EncodeToString(hash) + ":" + strconv.FormatInt(size, 10)
+}
+
+// Reconcile brings the indexes of `directory` on the server up to date with
+// its files, by diffing the directory tree against the manifest.  Files whose
+// size and modification time are unchanged are skipped without being read,
+// unless their indexes are built with an older key generation or normalization
+// scheme.  A new file with the same content as a removed one is treated as a
+// rename.  The new and modified files are indexed on `numWorkers` concurrent
+// workers.  The files and the directories ignored by the `.searchignore` files
+// are left out like the hidden ones, and the files skipped by the index policy
+// of the directory are listed in the result.  The indexes of both are deleted
+// if they were indexed before.
+// If some of the files cannot be reconciled, the result is returned along with
+// a `*ReconcileError`.  If `ctx` is cancelled, the reconciliation stops early
+// and `ctx.Err()` is returned, leaving the rest to the next pass.
+func (c *Client) Reconcile(ctx context.Context, directory string, numWorkers int) (ReconcileResult, error) {
+	return c.reconcile(ctx, directory, numWorkers, false)
+}
+
+// Reindex rebuilds the indexes of all the files of `directory` on
+// `numWorkers` concurrent workers, even those that are up to date, and
+// otherwise reconciles the directory like `Reconcile`.  The indexes of the
+// files already indexed count as written rather than unchanged in the result.
+// If `ctx` is cancelled, the files not reindexed yet keep their indexes.
+func (c *Client) Reindex(ctx context.Context, directory string, numWorkers int) (ReconcileResult, error) {
+	return c.reconcile(ctx, directory, numWorkers, true)
+}
+
+// reconcile implements `Reconcile`, and `Reindex` if `force` is true, in
+// which case all the files are considered modified.
+func (c *Client) reconcile(ctx context.Context, directory string, numWorkers int, force bool) (ReconcileResult, error) {
+	var result ReconcileResult
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return result, err
+	}
+
+	missing, err := dirInfo.manifest.entries()
+	if err != nil {
+		return result, err
+	}
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	version := dirInfo.analyzer.Normalization().Version
+
+	var fileErrs []FileError
+	fail := func(relPath string, err error) {
+		fileErrs = append(fileErrs, FileError{Filename: filepath.Join(dirInfo.absDir, relPath), Err: err})
+	}
+
+	// Finds the files that are new or modified since they were last indexed.
+	// The entries left in `missing` are the files no longer present.
+	// The files ignored by the ignore files or skipped by the index policy
+	// are left in `missing`, so that their indexes are deleted if they were
+	// indexed before.
+	var modified []string
+	added := make(map[string]string)         // The map from the new files to their content keys.
+	policies := make(map[string]IndexPolicy) // The policies of the directories walked so far.
+	ignores := make(map[string]ignoreChain)  // The ignore chains of the directories walked so far.
+	ignored := func(relPath string, isDir bool) (bool, error) {
+		chain, err := loadIgnores(dirInfo.absDir, filepath.Dir(relPath), dirInfo.policy.UseGitignore, ignores)
+		if err != nil {
+			return false, err
+		}
+		return chain.match(relPath, isDir), nil
+	}
+	err = walkFiles(dirInfo.absDir, ignored, func(relPath string, info os.FileInfo) {
+		entry, ok := missing[relPath]
+		unchanged := !force && ok && entry.isCurrent(keyGen, version) && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano()
+		policy, err := loadPolicy(dirInfo.absDir, filepath.Dir(relPath), dirInfo.policy, policies)
+		if err != nil {
+			delete(missing, relPath)
+			fail(relPath, err)
+			return
+		}
+		// Even an unchanged file is sniffed, as it may have been indexed while
+		// the binary files were not skipped.
+		reason, err := policy.skipReason(filepath.Join(dirInfo.absDir, relPath), info)
+		if err != nil {
+			delete(missing, relPath)
+			fail(relPath, err)
+			return
+		} else if reason != "" {
+			result.Skipped = append(result.Skipped, SkippedFile{Filename: filepath.Join(dirInfo.absDir, relPath), Reason: reason})
+			return
+		}
+
+		delete(missing, relPath)
+		if unchanged {
+			result.Unchanged++
+			return
+		}
+
+		hash, err := hashPath(filepath.Join(dirInfo.absDir, relPath))
+		if err != nil {
+			fail(relPath, err)
+			return
+		}
+		if !ok {
+			added[relPath] = contentKey(hash, info.Size())
+		} else if !force && entry.isCurrent(keyGen, version) && bytes.Equal(entry.Hash, hash) && !dirInfo.tlfInfo.Metadata {
+			// Only the metadata has changed, so the index is still valid
+			// unless the metadata are indexed as well.
+			entry.Size = info.Size()
+			entry.ModTime = info.ModTime().UnixNano()
+			if err := dirInfo.manifest.put(relPath, entry); err != nil {
+				fail(relPath, err)
+				return
+			}
+			result.Unchanged++
+		} else {
+			modified = append(modified, relPath)
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// Matches the new files against the missing ones with the same content.
+	// When reindexing, the moved files are indexed again instead.
+	renamable := make(map[string][]string) // The map from the content keys to the missing files.
+	for relPath, entry := range missing {
+		if !force && entry.isCurrent(keyGen, version) {
+			key := contentKey(entry.Hash, entry.Size)
+			renamable[key] = append(renamable[key], relPath)
+		}
+	}
+
+	for relPath, key := range added {
+		pathname := filepath.Join(dirInfo.absDir, relPath)
+		if origs := renamable[key]; len(origs) > 0 {
+			orig := origs[len(origs)-1]
+			renamable[key] = origs[:len(origs)-1]
+			delete(missing, orig)
+			if err := c.RenameFile(directory, filepath.Join(dirInfo.absDir, orig), pathname); err != nil {
+				fail(relPath, err)
+				continue
+			}
+			result.Renamed++
+			continue
+		}
+		modified = append(modified, relPath)
+	}
+
+	idx, err := CreateIndexer(c, directory, numWorkers, reconcileBatchSize, func(fileErr FileError) {
+		fileErrs = append(fileErrs, fileErr)
+	})
+	if err != nil {
+		return result, err
+	}
+	pathnames := make([]string, len(modified))
+	for i, relPath := range modified {
+		pathnames[i] = filepath.Join(dirInfo.absDir, relPath)
+	}
+	result.Written, err = idx.IndexFiles(ctx, pathnames)
+	if err != nil {
+		return result, err
+	}
+
+	for relPath := range missing {
+		if err := c.DeleteFile(directory, filepath.Join(dirInfo.absDir, relPath)); err != nil {
+			fail(relPath, err)
+			continue
+		}
+		result.Deleted++
+	}
+
+	if len(fileErrs) > 0 {
+		sort.Sort(fileErrorSlice(fileErrs))
+		return result, &ReconcileError{Errors: fileErrs}
+	}
+	return result, nil
+}
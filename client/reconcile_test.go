@@ -0,0 +1,281 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestReconcile tests the `Reconcile` function.  Checks that only the minimal
+// set of changes is applied, and that the search results reflect the files in
+// the directory afterwards.
+func TestReconcile(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	writeFile := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatalf("error when creating test directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		return filename
+	}
+	reconcile := func(expected ReconcileResult) {
+		result, err := client.Reconcile(context.Background(), dir, 2)
+		if err != nil {
+			t.Fatalf("error when reconciling: %s", err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("incorrect reconcile result: expected %+v actual %+v", expected, result)
+		}
+	}
+
+	kept := writeFile("kept", "kept content")
+	moved := writeFile("moved", "moved content")
+	modified := writeFile("sub/modified", "original content")
+	removed := writeFile("removed", "removed content")
+	writeFile(".hidden", "hidden content")
+	reconcile(ReconcileResult{Written: 4})
+	reconcile(ReconcileResult{Unchanged: 4})
+
+	renamed := filepath.Join(dir, "sub", "renamed")
+	if err := os.Rename(moved, renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	writeFile("sub/modified", "updated content")
+	if err := os.Remove(removed); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(kept, later, later); err != nil {
+		t.Fatalf("error when touching test file: %s", err)
+	}
+	reconcile(ReconcileResult{Written: 1, Renamed: 1, Deleted: 1, Unchanged: 1})
+	reconcile(ReconcileResult{Unchanged: 3})
+
+	testCases := []struct {
+		word     string
+		expected []string
+	}{
+		{"content", []string{kept, modified, renamed}},
+		{"moved", []string{renamed}},
+		{"original", nil},
+		{"removed", nil},
+		{"hidden", nil},
+	}
+	for _, testCase := range testCases {
+		actual, err := client.SearchWord(dir, testCase.word)
+		if err != nil {
+			t.Fatalf("error when searching word %s: %s", testCase.word, err)
+		}
+		if len(testCase.expected) == 0 && len(actual) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(testCase.expected, actual) {
+			t.Fatalf("incorrect search result for \"%s\": expected \"%s\" actual \"%s\"", testCase.word, testCase.expected, actual)
+		}
+	}
+}
+
+// TestReindex tests the `Reindex` function.  Checks that the indexes of all
+// the files are rebuilt, including the ones of the moved files, and that the
+// files are up to date afterwards.
+func TestReindex(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	kept := filepath.Join(dir, "kept")
+	moved := filepath.Join(dir, "moved")
+	for _, filename := range []string{kept, moved} {
+		if err := ioutil.WriteFile(filename, []byte("reindexed "+filepath.Base(filename)), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if _, err := client.Reconcile(context.Background(), dir, 2); err != nil {
+		t.Fatalf("error when reconciling: %s", err)
+	}
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(moved, renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+
+	result, err := client.Reindex(context.Background(), dir, 2)
+	if err != nil {
+		t.Fatalf("error when reindexing: %s", err)
+	}
+	if expected := (ReconcileResult{Written: 2, Deleted: 1}); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("incorrect reindex result: expected %+v actual %+v", expected, result)
+	}
+	if result, err = client.Reconcile(context.Background(), dir, 2); err != nil || !reflect.DeepEqual(result, ReconcileResult{Unchanged: 2}) {
+		t.Fatalf("files not up to date after reindexing: %+v %v", result, err)
+	}
+
+	files, err := client.SearchWord(dir, "reindexed")
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if expected := []string{kept, renamed}; !reflect.DeepEqual(files, expected) {
+		t.Fatalf("incorrect search result: expected %v actual %v", expected, files)
+	}
+}
+
+// TestReconcilePolicy tests the `Reconcile` function with an index policy.
+// Checks that the skipped files are reported with their reasons, that the
+// overrides of the subdirectories apply, and that the indexes of the files
+// skipped after they were indexed are deleted, even if they have not changed.
+func TestReconcilePolicy(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	writeFile := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatalf("error when creating test directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		return filename
+	}
+	reconcile := func(expected ReconcileResult) {
+		result, err := client.Reconcile(context.Background(), dir, 2)
+		if err != nil {
+			t.Fatalf("error when reconciling: %s", err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("incorrect reconcile result: expected %+v actual %+v", expected, result)
+		}
+	}
+
+	if err := client.SetIndexPolicy(dir, IndexPolicy{MaxFileSize: 32, SkipBinary: true, DenyExtensions: []string{".MP4"}}); err != nil {
+		t.Fatalf("error when setting the index policy: %s", err)
+	}
+	notes := writeFile("notes.txt", "short notes")
+	growing := writeFile("growing.txt", "short log")
+	video := writeFile("video.mp4", "not really a video")
+	binary := writeFile("program", "\x7fELF\x02\x01\x01\x00\x00\x00")
+	large := writeFile("large/dump.txt", "a line longer than the maximum size")
+	writeFile("large/.search_policy", `{"max_file_size": 0}`)
+	reconcile(ReconcileResult{Written: 3, Skipped: []SkippedFile{
+		{Filename: binary, Reason: SkipBinary},
+		{Filename: video, Reason: SkipDeniedExt},
+	}})
+
+	writeFile("growing.txt", "a log that has grown beyond the maximum size")
+	writeFile("large/.search_policy", `{"allow_extensions": ["md"]}`)
+	reconcile(ReconcileResult{Deleted: 2, Unchanged: 1, Skipped: []SkippedFile{
+		{Filename: growing, Reason: SkipTooLarge},
+		{Filename: large, Reason: SkipNotAllowedExt},
+		{Filename: binary, Reason: SkipBinary},
+		{Filename: video, Reason: SkipDeniedExt},
+	}})
+
+	files, err := client.SearchWord(dir, "short")
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if !reflect.DeepEqual(files, []string{notes}) {
+		t.Fatalf("incorrect search result: expected %v actual %v", []string{notes}, files)
+	}
+
+	writeFile("large/.search_policy", "not json")
+	if _, err := client.Reconcile(context.Background(), dir, 2); err == nil {
+		t.Fatalf("no error returned for an invalid policy file")
+	}
+
+	// A binary file indexed while the binary files are not skipped is removed
+	// from the indexes once they are, although it has not changed.
+	if err := os.Remove(filepath.Join(dir, "large", ".search_policy")); err != nil {
+		t.Fatalf("error when removing the policy file: %s", err)
+	}
+	for _, skipBinary := range []bool{false, true} {
+		if err := client.SetIndexPolicy(dir, IndexPolicy{MaxFileSize: 32, SkipBinary: skipBinary, DenyExtensions: []string{".MP4"}}); err != nil {
+			t.Fatalf("error when setting the index policy: %s", err)
+		}
+		expected := ReconcileResult{Written: 1, Unchanged: 1, Skipped: []SkippedFile{
+			{Filename: growing, Reason: SkipTooLarge},
+			{Filename: large, Reason: SkipTooLarge},
+			{Filename: video, Reason: SkipDeniedExt},
+		}}
+		if skipBinary {
+			expected = ReconcileResult{Deleted: 1, Unchanged: 1, Skipped: []SkippedFile{
+				{Filename: growing, Reason: SkipTooLarge},
+				{Filename: large, Reason: SkipTooLarge},
+				{Filename: binary, Reason: SkipBinary},
+				{Filename: video, Reason: SkipDeniedExt},
+			}}
+		}
+		reconcile(expected)
+	}
+}
+
+// TestReconcileIgnore tests the `Reconcile` function with `.searchignore`
+// files.  Checks that the ignored files and directories are left out, and that
+// the indexes of the files ignored after they were indexed are deleted.
+func TestReconcileIgnore(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	writeFile := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatalf("error when creating test directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		return filename
+	}
+	reconcile := func(expected ReconcileResult) {
+		result, err := client.Reconcile(context.Background(), dir, 2)
+		if err != nil {
+			t.Fatalf("error when reconciling: %s", err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("incorrect reconcile result: expected %+v actual %+v", expected, result)
+		}
+	}
+
+	notes := writeFile("notes.txt", "shared notes")
+	writeFile("build/output.txt", "shared output")
+	writeFile("node_modules/lib/index.js", "shared library")
+	writeFile("credentials.secret", "shared secret")
+	example := writeFile("example.secret", "shared example")
+	writeFile(ignoreFilename, "/build/\nnode_modules\n*.secret\n!example.secret\n")
+	reconcile(ReconcileResult{Written: 2})
+
+	writeFile(ignoreFilename, "/build/\nnode_modules\n*.secret\n")
+	reconcile(ReconcileResult{Deleted: 1, Unchanged: 1})
+
+	files, err := client.SearchWord(dir, "shared")
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if !reflect.DeepEqual(files, []string{notes}) {
+		t.Fatalf("incorrect search result: expected %v actual %v", []string{notes}, files)
+	}
+
+	if err := os.Remove(filepath.Join(dir, ignoreFilename)); err != nil {
+		t.Fatalf("error when removing the ignore file: %s", err)
+	}
+	reconcile(ReconcileResult{Written: 4, Unchanged: 1})
+	if files, err = client.SearchWord(dir, "example"); err != nil || !reflect.DeepEqual(files, []string{example}) {
+		t.Fatalf("incorrect search result: expected %v actual %v (%v)", []string{example}, files, err)
+	}
+}
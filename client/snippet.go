@@ -0,0 +1,210 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// Line is a line of a file included in a search hit.
+type Line struct {
+	Number  int      // The 1-based line number.
+	Text    string   // The content of the line, without the line terminator.
+	Matches [][2]int // The byte ranges in `Text` of the words matching the query.  Empty for context lines.
+}
+
+// Hit holds the matching lines of a single file for a query, along with their
+// surrounding context lines.
+type Hit struct {
+	Filename string // The absolute path of the file.
+	Lines    []Line // The matching and context lines in increasing order of line numbers.
+}
+
+// Highlight returns the text of the line with each of the matches wrapped
+// between `start` and `end`.
+func (l Line) Highlight(start, end string) string {
+	if len(l.Matches) == 0 {
+		return l.Text
+	}
+	var highlighted []byte
+	pos := 0
+	for _, match := range l.Matches {
+		highlighted = append(highlighted, l.Text[pos:match[0]]...)
+		highlighted = append(highlighted, start...)
+		highlighted = append(highlighted, l.Text[match[0]:match[1]]...)
+		highlighted = append(highlighted, end...)
+		pos = match[1]
+	}
+	highlighted = append(highlighted, l.Text[pos:]...)
+	return string(highlighted)
+}
+
+// positiveTerms returns the set of terms of the query that are not negated,
+// i.e. the ones whose presence contributes to a file matching the query.
+func (q *Query) positiveTerms() map[string]bool {
+	type polarTerm struct {
+		term    int
+		negated bool
+	}
+	var stack [][]polarTerm
+	for _, token := range q.tokens {
+		switch token.Op {
+		case sserver1.QueryOp_TERM:
+			stack = append(stack, []polarTerm{{term: token.Term}})
+		case sserver1.QueryOp_NOT:
+			top := stack[len(stack)-1]
+			for i := range top {
+				top[i].negated = !top[i].negated
+			}
+		default:
+			lhs, rhs := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1] = append(lhs, rhs...)
+		}
+	}
+
+	terms := make(map[string]bool)
+	for _, entry := range stack {
+		for _, polar := range entry {
+			if !polar.negated {
+				terms[q.terms[polar.term]] = true
+			}
+		}
+	}
+	return terms
+}
+
+// findMatches returns the byte ranges of the words in `line` with any of their
+// keywords given by `analyzer` matched by `matcher`.  Words are split in the
+// same way as the index builder.
+func findMatches(line string, matcher *termMatcher, analyzer libsearch.Analyzer) [][2]int {
+	matches := func(word string) bool {
+		matched := false
+		for _, keyword := range analyzer.Keywords(word) {
+			matcher.match(keyword, func(string) {
+				matched = true
+			})
+		}
+		return matched
+	}
+
+	var ranges [][2]int
+	start := -1
+	for pos, c := range line {
+		if unicode.IsSpace(c) {
+			if start >= 0 && matches(line[start:pos]) {
+				ranges = append(ranges, [2]int{start, pos})
+			}
+			start = -1
+		} else if start < 0 {
+			start = pos
+		}
+	}
+	if start >= 0 && matches(line[start:]) {
+		ranges = append(ranges, [2]int{start, len(line)})
+	}
+	return ranges
+}
+
+// trimLineEnding removes the trailing line terminator from `line`.
+func trimLineEnding(line string) string {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// findHit reads the text of the file at `filename` and returns the lines containing any
+// of the `terms` once analyzed with `analyzer`, each surrounded by up to
+// `numContext` lines of context.
+func findHit(filename string, terms map[string]bool, analyzer libsearch.Analyzer, numContext int) (Hit, error) {
+	hit := Hit{Filename: filename}
+	termList := make([]string, 0, len(terms))
+	for term := range terms {
+		// The words of a phrase are highlighted on their own, as a phrase may
+		// span several lines.
+		if isPhrase(term) {
+			termList = append(termList, phraseKeywords(term)...)
+		} else {
+			termList = append(termList, term)
+		}
+	}
+	matcher := createTermMatcher(termList)
+
+	text, err := openText(filename)
+	if err != nil {
+		return hit, err
+	}
+	defer text.Close()
+
+	reader := bufio.NewReader(text)
+	var before []Line   // The most recent lines not yet included in the hit.
+	afterRemaining := 0 // The number of context lines still to include after the last match.
+	for number := 1; ; number++ {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return hit, err
+		}
+		if len(text) == 0 && err == io.EOF {
+			break
+		}
+		if !utf8.ValidString(text) {
+			text = string([]rune(text))
+		}
+
+		line := Line{Number: number, Text: trimLineEnding(text)}
+		line.Matches = findMatches(line.Text, matcher, analyzer)
+		if len(line.Matches) > 0 {
+			hit.Lines = append(hit.Lines, before...)
+			hit.Lines = append(hit.Lines, line)
+			before = before[:0]
+			afterRemaining = numContext
+		} else if afterRemaining > 0 {
+			hit.Lines = append(hit.Lines, line)
+			afterRemaining--
+		} else if numContext > 0 {
+			if len(before) == numContext {
+				before = append(before[:0], before[1:]...)
+			}
+			before = append(before, line)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	return hit, nil
+}
+
+// findHits returns the hits of the positive terms of the analyzed `query` in
+// each of the `files`, which are expected to have already been verified to
+// match the query.  Files that cannot be read are reported in a
+// `*VerificationError`.
+func findHits(files []string, query *Query, analyzer libsearch.Analyzer, numContext int) ([]Hit, error) {
+	terms := query.positiveTerms()
+	hits := make([]Hit, 0, len(files))
+	var fileErrs []FileError
+	for _, filename := range files {
+		hit, err := findHit(filename, terms, analyzer, numContext)
+		if err != nil {
+			fileErrs = append(fileErrs, FileError{Filename: filename, Err: err})
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	if len(fileErrs) > 0 {
+		return hits, &VerificationError{Errors: fileErrs}
+	}
+	return hits, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// TestFindHit tests the `findHit` function.  Checks that the matching lines
+// and their context are correctly extracted and highlighted.
+func TestFindHit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestFindHit")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "line one\nline two\nthe Needle, here\nline four\nline five\nline six\nline seven\nneedle again\r\n"
+	filename := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	hit, err := findHit(filename, map[string]bool{"needle": true}, libsearch.ProseAnalyzer, 1)
+	if err != nil {
+		t.Fatalf("error when finding the hit: %s", err)
+	}
+	expected := []Line{
+		{Number: 2, Text: "line two"},
+		{Number: 3, Text: "the Needle, here", Matches: [][2]int{{4, 11}}},
+		{Number: 4, Text: "line four"},
+		{Number: 7, Text: "line seven"},
+		{Number: 8, Text: "needle again", Matches: [][2]int{{0, 6}}},
+	}
+	if !reflect.DeepEqual(hit.Lines, expected) {
+		t.Fatalf("incorrect hit: expected %v actual %v", expected, hit.Lines)
+	}
+	if highlighted := hit.Lines[1].Highlight("[", "]"); highlighted != "the [Needle,] here" {
+		t.Fatalf("incorrect highlighting: %s", highlighted)
+	}
+
+	if _, err := findHit(filepath.Join(dir, "missing"), map[string]bool{"needle": true}, libsearch.ProseAnalyzer, 1); !os.IsNotExist(err) {
+		t.Fatalf("no error returned for missing file")
+	}
+}
+
+// TestPositiveTerms tests the `positiveTerms` function.  Checks that negated
+// terms are excluded.
+func TestPositiveTerms(t *testing.T) {
+	query, err := ParseQuery("a AND NOT (b OR NOT c) OR d")
+	if err != nil {
+		t.Fatalf("error when parsing the query: %s", err)
+	}
+	expected := map[string]bool{"a": true, "c": true, "d": true}
+	if terms := query.positiveTerms(); !reflect.DeepEqual(terms, expected) {
+		t.Fatalf("incorrect positive terms: expected %v actual %v", expected, terms)
+	}
+}
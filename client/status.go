@@ -0,0 +1,62 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import "github.com/keybase/kbfs/libkbfs"
+
+// DirectoryStatus describes the indexes of a client directory, as recorded in
+// its manifest and its TLF information.
+type DirectoryStatus struct {
+	Directory       string         // The absolute path of the directory.
+	TlfID           string         // The TLF ID of the directory.
+	KeyGen          libkbfs.KeyGen // The latest key generation of the directory.
+	Analyzer        string         // The ID of the analyzer of the TLF.
+	StripDiacritics bool           // Whether the diacritics are removed from the keywords of the TLF.
+	MaxPrefixLen    int            // The maximum length of the word prefixes indexed for the TLF, or 0 if none are.
+	Fuzzy           bool           // Whether the word trigrams are indexed for the TLF.
+	Phrases         bool           // Whether the pairs of adjacent words are indexed for the TLF.
+	Paths           bool           // Whether the words of the pathnames are indexed for the TLF.
+	Metadata        bool           // Whether the metadata of the files are indexed for the TLF.
+	NumIndexed      int            // The number of files whose indexes have been uploaded.
+	NumOutdated     int            // The number of indexed files whose indexes are rebuilt by the next reconciliation, because they are built with an older key generation, normalization scheme or text extraction.
+}
+
+// Status returns the status of the indexes of `directory`.  Only reads the
+// local manifest, so the files changed since they were last indexed are not
+// counted as outdated.
+func (c *Client) Status(directory string) (DirectoryStatus, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return DirectoryStatus{}, err
+	}
+	entries, err := dirInfo.manifest.entries()
+	if err != nil {
+		return DirectoryStatus{}, err
+	}
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+
+	status := DirectoryStatus{
+		Directory:       dirInfo.absDir,
+		TlfID:           dirInfo.tlfID.String(),
+		KeyGen:          keyGen,
+		Analyzer:        dirInfo.tlfInfo.Analyzer,
+		StripDiacritics: dirInfo.tlfInfo.StripDiacritics,
+		MaxPrefixLen:    dirInfo.tlfInfo.MaxPrefixLen,
+		Fuzzy:           dirInfo.tlfInfo.Fuzzy,
+		Phrases:         dirInfo.tlfInfo.Phrases,
+		Paths:           dirInfo.tlfInfo.Paths,
+		Metadata:        dirInfo.tlfInfo.Metadata,
+		NumIndexed:      len(entries),
+	}
+	version := dirInfo.analyzer.Normalization().Version
+	for _, entry := range entries {
+		if !entry.isCurrent(keyGen, version) {
+			status.NumOutdated++
+		}
+	}
+	return status, nil
+}
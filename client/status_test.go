@@ -0,0 +1,58 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStatus tests the `Status` function.  Checks that the indexed files are
+// counted, and that the indexes built with an older text extraction are
+// reported as outdated.
+func TestStatus(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	for _, name := range []string{"first", "second"} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte("status content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	dirInfo, err := client.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory information: %s", err)
+	}
+	entry, _, err := dirInfo.manifest.get("first")
+	if err != nil {
+		t.Fatalf("error when reading the manifest: %s", err)
+	}
+	entry.Extraction = 0
+	if err := dirInfo.manifest.put("first", entry); err != nil {
+		t.Fatalf("error when writing the manifest: %s", err)
+	}
+
+	status, err := client.Status(dir)
+	if err != nil {
+		t.Fatalf("error when getting the status: %s", err)
+	}
+	if status.Directory != dirInfo.absDir || status.TlfID != dirInfo.tlfID.String() || status.Analyzer != dirInfo.tlfInfo.Analyzer {
+		t.Fatalf("incorrect directory information: %+v", status)
+	}
+	if status.NumIndexed != 2 || status.NumOutdated != 1 {
+		t.Fatalf("incorrect numbers of files: %d indexed, %d outdated", status.NumIndexed, status.NumOutdated)
+	}
+
+	if _, err := client.Status(filepath.Join(dir, "first")); err == nil {
+		t.Fatalf("no error returned for an invalid directory")
+	}
+}
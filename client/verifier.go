@@ -0,0 +1,165 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// FileError records an error that occurred when processing a single file.
+type FileError struct {
+	Filename string // The absolute path of the file.
+	Err      error  // The error that occurred.
+}
+
+// Error implements the error interface.
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Filename, e.Err)
+}
+
+// fileErrorSlice attaches the methods of sort.Interface to []FileError, sorting
+// by the filenames in increasing order.
+type fileErrorSlice []FileError
+
+func (p fileErrorSlice) Len() int           { return len(p) }
+func (p fileErrorSlice) Less(i, j int) bool { return p[i].Filename < p[j].Filename }
+func (p fileErrorSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// VerificationError is returned by the strict search functions when some of
+// the candidate files cannot be verified.  The results returned along with it
+// are still valid for all the other files.
+type VerificationError struct {
+	Errors []FileError // The errors of the files that cannot be verified.
+}
+
+// Error implements the error interface.
+func (e *VerificationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fileErr := range e.Errors {
+		msgs[i] = fileErr.Error()
+	}
+	return fmt.Sprintf("cannot verify %d file(s): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// findFieldTerms returns the set of the field terms among the analyzed `terms`
+// matched by the pathname of the file at `filename` relative to `root`, or by
+// the metadata of the file.  The pathname is split into fields in the same way
+// as the index builder.
+func findFieldTerms(root, filename string, terms []string, analyzer libsearch.Analyzer) (map[string]bool, error) {
+	found := make(map[string]bool)
+	var fields map[string][]string
+	var metadata *libsearch.Metadata
+	for _, term := range terms {
+		field, keyword, ok := splitFieldTerm(term)
+		if !ok {
+			continue
+		}
+		if libsearch.IsMetadataField(field) {
+			if metadata == nil {
+				fileMetadata, err := fileMetadata(filename)
+				if err != nil {
+					return nil, err
+				}
+				metadata = &fileMetadata
+			}
+			if matchMetadata(*metadata, field, keyword) {
+				found[term] = true
+			}
+			continue
+		}
+		if fields == nil {
+			relPath, err := filepath.Rel(root, filename)
+			if err != nil {
+				return nil, err
+			}
+			fields = libsearch.PathFields(relPath, analyzer)
+		}
+		for _, fieldKeyword := range fields[field] {
+			if fieldKeyword == keyword {
+				found[term] = true
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// findTermsInFile reads the text of the file at `filename` and returns the set
+// of `terms` that it contains, along with the field terms matched by its
+// pathname relative to `root`.  The text is extracted and tokenized in the same
+// way as the index builder, so `terms` should already be analyzed.  `terms` must be distinct.
+func findTermsInFile(root, filename string, terms []string, analyzer libsearch.Analyzer) (map[string]bool, error) {
+	found, err := findFieldTerms(root, filename, terms, analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := openText(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer text.Close()
+
+	matcher := createTermMatcher(terms)
+	err = libsearch.ScanKeywordsStream(text, analyzer, func(keyword string) bool {
+		matcher.match(keyword, func(term string) {
+			found[term] = true
+		})
+		return len(found) < len(terms)
+	})
+	return found, err
+}
+
+// verifyFiles checks which of the `terms` each of the `files` under `root`
+// contains once analyzed with `analyzer`, using a bounded pool of workers.  Returns the found terms of each
+// successfully verified file, and a `VerificationError` if any file cannot be
+// verified.
+func verifyFiles(root string, files []string, terms []string, analyzer libsearch.Analyzer) (map[string]map[string]bool, error) {
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(files) {
+		numWorkers = len(files)
+	}
+
+	var lock sync.Mutex
+	results := make(map[string]map[string]bool, len(files))
+	var fileErrs []FileError
+
+	fileCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range fileCh {
+				found, err := findTermsInFile(root, filename, terms, analyzer)
+				lock.Lock()
+				if err != nil {
+					fileErrs = append(fileErrs, FileError{Filename: filename, Err: err})
+				} else {
+					results[filename] = found
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, filename := range files {
+		fileCh <- filename
+	}
+	close(fileCh)
+	wg.Wait()
+
+	if len(fileErrs) > 0 {
+		sort.Sort(fileErrorSlice(fileErrs))
+		return results, &VerificationError{Errors: fileErrs}
+	}
+	return results, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// TestVerifyFiles tests the `verifyFiles` function.  Checks that the terms are
+// matched after the same normalization as the index builder, that long lists
+// of files are handled, that the field terms are matched by the pathnames,
+// and that the errors are reported for each file.
+func TestVerifyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestVerify")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"punctuation": "This is a TOP-NOTCH test, isn't it?",
+		"substring":   "Words like topnotched and testing do not count.",
+		"empty":       "",
+	}
+	var files []string
+	for name, content := range contents {
+		files = append(files, filepath.Join(dir, name))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	missing := filepath.Join(dir, "missing")
+	files = append(files, missing)
+	// Makes sure that the list of files is longer than any command line
+	// could be.
+	for i := 0; i < 10000; i++ {
+		files = append(files, filepath.Join(dir, "punctuation"))
+	}
+
+	nameTerm := fieldTerm(libsearch.FieldName, "punctuation")
+	results, err := verifyFiles(dir, files, []string{"topnotch", "isnt", "test", nameTerm}, libsearch.ProseAnalyzer)
+	verifyErr, ok := err.(*VerificationError)
+	if !ok {
+		t.Fatalf("verification error not returned for missing file: %v", err)
+	}
+	if len(verifyErr.Errors) != 1 || verifyErr.Errors[0].Filename != missing || !os.IsNotExist(verifyErr.Errors[0].Err) {
+		t.Fatalf("incorrect verification errors: %s", verifyErr)
+	}
+	if !strings.Contains(verifyErr.Error(), missing) {
+		t.Fatalf("filename not included in the error message: %s", verifyErr)
+	}
+
+	expected := map[string]bool{"topnotch": true, "isnt": true, "test": true, nameTerm: true}
+	if !reflect.DeepEqual(results[filepath.Join(dir, "punctuation")], expected) {
+		t.Fatalf("incorrect terms found: %v", results[filepath.Join(dir, "punctuation")])
+	}
+	if len(results[filepath.Join(dir, "substring")]) != 0 || len(results[filepath.Join(dir, "empty")]) != 0 {
+		t.Fatalf("terms found in files not containing them")
+	}
+	if _, ok := results[missing]; ok {
+		t.Fatalf("result returned for missing file")
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fsOp is the type of a filesystem change observed by a `Watcher`.
+type fsOp int
+
+const (
+	fsWrite     fsOp = iota // A file has been written, or a directory has been created.
+	fsRemove                // A file has been removed.
+	fsMovedFrom             // A file has been moved away from `path`.
+	fsMovedTo               // A file has been moved to `path`.
+	fsRename                // A file has been renamed from `oldPath` to `path`.  Only produced by `coalesceEvents`.
+	fsOverflow              // Some events have been lost.
+)
+
+// fsEvent is a filesystem change observed by a `Watcher`.
+type fsEvent struct {
+	op      fsOp   // The type of the change.
+	path    string // The absolute path of the changed file.
+	oldPath string // The original absolute path of a renamed file.
+	cookie  uint32 // The cookie pairing the `fsMovedFrom` and `fsMovedTo` events of a single move.
+	isDir   bool   // Whether the changed file is a directory.
+}
+
+// fsNotifier is the platform-specific source of the filesystem events of a
+// directory tree.
+type fsNotifier interface {
+	// events returns the channel of the events, which is closed once the
+	// notifier is closed or fails.
+	events() <-chan fsEvent
+	// err returns the error that stopped the notifier, if any.  Only valid
+	// after the events channel is closed.
+	err() error
+	// close stops the notifier.
+	close() error
+}
+
+// isHiddenPath returns whether any component of `relPath` is hidden, in which
+// case the file is not indexed.
+func isHiddenPath(relPath string) bool {
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		if len(name) > 0 && name[0] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher keeps the indexes of a client directory up to date by applying the
+// changes reported by the filesystem.  Bursts of events are debounced, so that
+// a file written several times in a row is only indexed once.
+type Watcher struct {
+	cli       *Client       // The client that owns the directory.
+	directory string        // The absolute path of the watched directory.
+	debounce  time.Duration // The quiet period to wait for before applying the buffered events.
+	reconcile func() error  // The function to rescan the directory when events have been lost.
+	onError   func(error)   // The function to report the errors of applying the events.
+	notifier  fsNotifier    // The source of the filesystem events.
+}
+
+// CreateWatcher creates a `Watcher` for `directory`, which must be one of the
+// directories of `cli`.  The changes are applied once no event has been seen
+// for `debounce`.  When the filesystem reports that some events have been
+// lost, `reconcile` is called to rescan the directory.  Errors that occur
+// while applying the changes are passed to `onError`.
+func CreateWatcher(cli *Client, directory string, debounce time.Duration, reconcile func() error, onError func(error)) (*Watcher, error) {
+	dirInfo, err := cli.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier, err := newFsNotifier(dirInfo.absDir, func(path string) bool {
+		relPath, err := relPathStrict(dirInfo.absDir, path)
+		return err != nil || (isHiddenPath(relPath) && !isIgnoreFile(relPath))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		cli:       cli,
+		directory: dirInfo.absDir,
+		debounce:  debounce,
+		reconcile: reconcile,
+		onError:   onError,
+		notifier:  notifier,
+	}, nil
+}
+
+// Run applies the filesystem changes until the watcher is closed.  Returns the
+// error that stopped the watcher, if any.
+func (w *Watcher) Run() error {
+	var pending []fsEvent
+	var firstPending time.Time
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.notifier.events():
+			if !ok {
+				timer.Stop()
+				w.applyEvents(pending)
+				return w.notifier.err()
+			}
+			if event.op == fsOverflow {
+				timer.Stop()
+				w.applyEvents(pending)
+				pending = nil
+				if err := w.reconcile(); err != nil {
+					w.onError(err)
+				}
+				continue
+			}
+			if len(pending) == 0 {
+				firstPending = time.Now()
+			}
+			pending = append(pending, event)
+			// Flushes a never-ending stream of events every now and then.
+			if time.Since(firstPending) >= 10*w.debounce {
+				timer.Stop()
+				w.applyEvents(pending)
+				pending = nil
+			} else {
+				timer.Reset(w.debounce)
+			}
+		case <-timer.C:
+			w.applyEvents(pending)
+			pending = nil
+		}
+	}
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return w.notifier.close()
+}
+
+// coalesceEvents pairs up the moves in `events` into renames, and drops the
+// writes that are superseded by later events on the same files.  Moves without
+// a pair are treated as removals and writes respectively.
+func coalesceEvents(events []fsEvent) []fsEvent {
+	var paired []fsEvent
+	movedFrom := make(map[uint32]int) // The positions in `paired` of the unpaired moves.
+	for _, event := range events {
+		switch event.op {
+		case fsMovedFrom:
+			movedFrom[event.cookie] = len(paired)
+			paired = append(paired, fsEvent{op: fsRemove, path: event.path, isDir: event.isDir})
+		case fsMovedTo:
+			if i, ok := movedFrom[event.cookie]; ok {
+				delete(movedFrom, event.cookie)
+				paired[i] = fsEvent{op: fsRename, oldPath: paired[i].path, path: event.path, isDir: event.isDir}
+			} else {
+				paired = append(paired, fsEvent{op: fsWrite, path: event.path, isDir: event.isDir})
+			}
+		default:
+			paired = append(paired, event)
+		}
+	}
+
+	var coalesced []fsEvent
+	dropped := make(map[int]bool)
+	pendingWrites := make(map[string]int) // The positions in `coalesced` of the writes not superseded yet.
+	for _, event := range paired {
+		switch event.op {
+		case fsWrite:
+			if _, ok := pendingWrites[event.path]; ok {
+				continue
+			}
+			pendingWrites[event.path] = len(coalesced)
+			coalesced = append(coalesced, event)
+		case fsRemove:
+			if i, ok := pendingWrites[event.path]; ok {
+				dropped[i] = true
+				delete(pendingWrites, event.path)
+			}
+			coalesced = append(coalesced, event)
+		case fsRename:
+			// A pending write of the original file is applied to the renamed
+			// file instead, and one of the renamed file is overwritten anyway.
+			i, hadWrite := pendingWrites[event.oldPath]
+			if hadWrite {
+				dropped[i] = true
+				delete(pendingWrites, event.oldPath)
+			}
+			if i, ok := pendingWrites[event.path]; ok {
+				dropped[i] = true
+				delete(pendingWrites, event.path)
+			}
+			coalesced = append(coalesced, event)
+			if hadWrite {
+				pendingWrites[event.path] = len(coalesced)
+				coalesced = append(coalesced, fsEvent{op: fsWrite, path: event.path, isDir: event.isDir})
+			}
+		}
+	}
+
+	result := make([]fsEvent, 0, len(coalesced))
+	for i, event := range coalesced {
+		if !dropped[i] {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// walkFiles calls `f` with the path relative to `root` and the information of
+// each non-hidden file under `root`.  If `ignored` is not nil, the files and
+// the directories for which it returns true are skipped as well.
+func walkFiles(root string, ignored func(relPath string, isDir bool) (bool, error), f func(relPath string, info os.FileInfo)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		skip := info.Name()[0] == '.'
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !skip && ignored != nil {
+			if skip, err = ignored(relPath, info.IsDir()); err != nil {
+				return err
+			}
+		}
+		switch {
+		case skip && info.IsDir():
+			return filepath.SkipDir
+		case !skip && !info.IsDir():
+			f(relPath, info)
+		}
+		return nil
+	})
+}
+
+// addFile indexes the file at `pathname`, unless it is ignored by the ignore
+// files, or skipped by the index policy, in which case a `SkippedFile` is
+// returned.
+func (w *Watcher) addFile(pathname string) error {
+	if ignored, err := w.cli.ignoreFile(w.directory, pathname); err != nil || ignored {
+		return err
+	}
+	reason, err := w.cli.skipFile(w.directory, pathname)
+	if err != nil {
+		return err
+	} else if reason != "" {
+		return SkippedFile{Filename: pathname, Reason: reason}
+	}
+	return w.cli.AddFile(w.directory, pathname)
+}
+
+// renameFile renames the index of the file moved from `oldPath` to `path`,
+// and deletes it if the file is ignored by the ignore files, or skipped by the
+// index policy under its new name, in which case a `SkippedFile` is returned.
+func (w *Watcher) renameFile(oldPath, path string) error {
+	if ignored, err := w.cli.ignoreFile(w.directory, path); err != nil {
+		return err
+	} else if ignored {
+		return w.cli.DeleteFile(w.directory, oldPath)
+	}
+	if err := w.cli.RenameFile(w.directory, oldPath, path); err != nil {
+		return err
+	}
+	reason, err := w.cli.skipFile(w.directory, path)
+	if err != nil {
+		return err
+	} else if reason != "" {
+		return SkippedFile{Filename: path, Reason: reason}
+	}
+	return nil
+}
+
+// applyEvents coalesces the `events` and applies the resulting changes to the
+// indexes.  Files that disappear before they can be indexed are skipped, and
+// the files skipped by the index policy are reported as `SkippedFile` errors.
+// When an ignore file or a `.search_policy` file changes, the directory is
+// rescanned once the other changes are applied, so that the files it now
+// leaves out are removed from the indexes and those it lets in are indexed.
+func (w *Watcher) applyEvents(events []fsEvent) {
+	report := func(err error) {
+		if err != nil && !os.IsNotExist(err) {
+			w.onError(err)
+		}
+	}
+	isRulesEvent := func(event fsEvent) bool {
+		for _, path := range []string{event.path, event.oldPath} {
+			if relPath, err := relPathStrict(w.directory, path); err == nil && isIgnoreFile(relPath) {
+				return true
+			}
+		}
+		return false
+	}
+
+	rescan := false
+	for _, event := range coalesceEvents(events) {
+		if !event.isDir && isRulesEvent(event) {
+			rescan = true
+			continue
+		}
+		switch {
+		case event.op == fsWrite && event.isDir:
+			report(walkFiles(event.path, nil, func(relPath string, _ os.FileInfo) {
+				report(w.addFile(filepath.Join(event.path, relPath)))
+			}))
+		case event.op == fsWrite:
+			report(w.addFile(event.path))
+		case event.op == fsRemove && event.isDir:
+			// The files of a directory moved out of the watched tree cannot be
+			// listed anymore, so their indexes are only found by rescanning.
+			report(w.reconcile())
+		case event.op == fsRemove:
+			report(w.cli.DeleteFile(w.directory, event.path))
+		case event.op == fsRename && event.isDir:
+			report(walkFiles(event.path, nil, func(relPath string, _ os.FileInfo) {
+				report(w.renameFile(filepath.Join(event.oldPath, relPath), filepath.Join(event.path, relPath)))
+			}))
+		case event.op == fsRename:
+			report(w.renameFile(event.oldPath, event.path))
+		}
+	}
+	if rescan {
+		report(w.reconcile())
+	}
+}
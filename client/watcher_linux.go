@@ -0,0 +1,251 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask is the set of inotify events watched on each directory.  Files
+// are only reported once they are closed after writing, while the creations
+// are only used to start watching the new directories.
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// inotifyNotifier is the inotify-based `fsNotifier` on Linux.  Each directory
+// of the tree is watched separately, and the new directories are watched as
+// soon as they are created or moved in.
+type inotifyNotifier struct {
+	fd        int               // The inotify file descriptor.
+	epfd      int               // The epoll file descriptor waiting on `fd` and `pipe[0]`.
+	pipe      [2]int            // The pipe to wake up the reading goroutine when closing.
+	ignored   func(string) bool // The function to tell whether a path should be ignored.
+	watches   map[int]string    // The map from the watch descriptors to the watched directories.
+	movedDirs map[uint32]string // The directories moved away in the current batch, keyed by the cookies.
+	eventCh   chan fsEvent      // The channel of the translated events.
+	readErr   error             // The error that stopped the reading goroutine.
+	closeOnce sync.Once         // Makes sure that the notifier is only closed once.
+}
+
+// newFsNotifier creates an `fsNotifier` watching the directory tree at `root`,
+// except for the paths for which `ignored` returns true.
+func newFsNotifier(root string, ignored func(string) bool) (fsNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotifyNotifier{
+		fd:        fd,
+		epfd:      -1,
+		pipe:      [2]int{-1, -1},
+		ignored:   ignored,
+		watches:   make(map[int]string),
+		movedDirs: make(map[uint32]string),
+		eventCh:   make(chan fsEvent, 1024),
+	}
+
+	if n.epfd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		n.closeFds()
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	if err := unix.Pipe2(n.pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		n.closeFds()
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	for _, fd := range []int{n.fd, n.pipe[0]} {
+		event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+		if err := unix.EpollCtl(n.epfd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
+			n.closeFds()
+			unix.Close(n.pipe[1])
+			return nil, os.NewSyscallError("epoll_ctl", err)
+		}
+	}
+
+	if err := n.addWatches(root); err != nil {
+		n.closeFds()
+		unix.Close(n.pipe[1])
+		return nil, err
+	}
+
+	go n.readEvents()
+	return n, nil
+}
+
+// closeFds closes all the file descriptors of the notifier, except for the
+// writing end of the pipe, which is closed by `close`.
+func (n *inotifyNotifier) closeFds() {
+	for _, fd := range []int{n.fd, n.epfd, n.pipe[0]} {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+	}
+}
+
+// addWatches watches `dir` and all its non-ignored subdirectories.
+func (n *inotifyNotifier) addWatches(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The directory might have been removed in the meantime.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && n.ignored(path) {
+			return filepath.SkipDir
+		}
+		wd, err := unix.InotifyAddWatch(n.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		n.watches[wd] = path
+		return nil
+	})
+}
+
+// renameWatches updates the paths of the watched directories after `oldDir`
+// has been moved to `newDir`.
+func (n *inotifyNotifier) renameWatches(oldDir, newDir string) {
+	for wd, path := range n.watches {
+		if path == oldDir || strings.HasPrefix(path, oldDir+string(filepath.Separator)) {
+			n.watches[wd] = newDir + path[len(oldDir):]
+		}
+	}
+}
+
+// removeWatches stops watching `dir` and all its subdirectories.
+func (n *inotifyNotifier) removeWatches(dir string) {
+	for wd, path := range n.watches {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			unix.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.watches, wd)
+		}
+	}
+}
+
+// readEvents reads the inotify events until the notifier is closed, and sends
+// the translated events to `eventCh`.
+func (n *inotifyNotifier) readEvents() {
+	defer close(n.eventCh)
+	defer n.closeFds()
+
+	buf := make([]byte, 4096*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	epollEvents := make([]unix.EpollEvent, 2)
+	for {
+		numReady, err := unix.EpollWait(n.epfd, epollEvents, -1)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			n.readErr = os.NewSyscallError("epoll_wait", err)
+			return
+		}
+		for _, epollEvent := range epollEvents[:numReady] {
+			if int(epollEvent.Fd) == n.pipe[0] {
+				return
+			}
+		}
+
+		numRead, err := unix.Read(n.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		} else if err != nil {
+			n.readErr = os.NewSyscallError("read", err)
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= numRead; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			n.handleEvent(int(raw.Wd), raw.Mask, raw.Cookie, name)
+			offset += unix.SizeofInotifyEvent + int(raw.Len)
+		}
+
+		// The directories moved out of the watched tree are no longer
+		// reported with the right paths, so they stop being watched.
+		for cookie, dir := range n.movedDirs {
+			n.removeWatches(dir)
+			delete(n.movedDirs, cookie)
+		}
+	}
+}
+
+// handleEvent translates a single inotify event on the file `name` in the
+// directory watched by `wd`.
+func (n *inotifyNotifier) handleEvent(wd int, mask, cookie uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		n.eventCh <- fsEvent{op: fsOverflow}
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(n.watches, wd)
+		return
+	}
+	dir, ok := n.watches[wd]
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if n.ignored(path) {
+		return
+	}
+	isDir := mask&unix.IN_ISDIR != 0
+
+	switch {
+	case mask&unix.IN_CREATE != 0 && isDir:
+		if err := n.addWatches(path); err != nil {
+			n.eventCh <- fsEvent{op: fsOverflow}
+		}
+		n.eventCh <- fsEvent{op: fsWrite, path: path, isDir: true}
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		n.eventCh <- fsEvent{op: fsWrite, path: path}
+	case mask&unix.IN_DELETE != 0 && !isDir:
+		n.eventCh <- fsEvent{op: fsRemove, path: path}
+	case mask&unix.IN_MOVED_FROM != 0:
+		if isDir {
+			n.movedDirs[cookie] = path
+		}
+		n.eventCh <- fsEvent{op: fsMovedFrom, path: path, cookie: cookie, isDir: isDir}
+	case mask&unix.IN_MOVED_TO != 0:
+		if isDir {
+			if oldDir, ok := n.movedDirs[cookie]; ok {
+				delete(n.movedDirs, cookie)
+				n.renameWatches(oldDir, path)
+			} else if err := n.addWatches(path); err != nil {
+				n.eventCh <- fsEvent{op: fsOverflow}
+			}
+		}
+		n.eventCh <- fsEvent{op: fsMovedTo, path: path, cookie: cookie, isDir: isDir}
+	}
+}
+
+// events implements the fsNotifier interface.
+func (n *inotifyNotifier) events() <-chan fsEvent {
+	return n.eventCh
+}
+
+// err implements the fsNotifier interface.
+func (n *inotifyNotifier) err() error {
+	return n.readErr
+}
+
+// close implements the fsNotifier interface.  The reading goroutine might have
+// already stopped on an error, in which case the write fails harmlessly.
+func (n *inotifyNotifier) close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		unix.Write(n.pipe[1], []byte{0})
+		err = unix.Close(n.pipe[1])
+	})
+	return err
+}
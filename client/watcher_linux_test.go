@@ -0,0 +1,136 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitForSearchResult polls `SearchWordStrict` until it returns `expected`,
+// and fails the test if it does not within a few seconds.
+func waitForSearchResult(t *testing.T, client *Client, dir, word string, expected []string) {
+	var actual []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		var err error
+		actual, err = client.SearchWordStrict(dir, word)
+		// The index of a file might not have been updated yet.
+		if _, ok := err.(*VerificationError); ok {
+			continue
+		} else if err != nil {
+			t.Fatalf("error when searching word %s: %s", word, err)
+		}
+		if reflect.DeepEqual(actual, expected) || (len(actual) == 0 && len(expected) == 0) {
+			return
+		}
+	}
+	t.Fatalf("incorrect search result for \"%s\": expected \"%s\" actual \"%s\"", word, expected, actual)
+}
+
+// TestWatcher tests the `Watcher` with inotify.  Checks that the files written,
+// renamed and removed in the directory and its subdirectories are reflected in
+// the search results, that hidden files and the files matched by the
+// `.searchignore` files are ignored, and that the files skipped by the index
+// policy are reported.
+func TestWatcher(t *testing.T) {
+	client, dir := startTestClient(t, "", nil)
+	defer os.RemoveAll(dir)
+
+	if err := client.SetIndexPolicy(dir, IndexPolicy{DenyExtensions: []string{"log"}}); err != nil {
+		t.Fatalf("error when setting the index policy: %s", err)
+	}
+	skipped := make(chan SkippedFile, 1)
+	watcher, err := CreateWatcher(client, dir, 10*time.Millisecond, func() error { return nil }, func(err error) {
+		if skippedFile, ok := err.(SkippedFile); ok {
+			select {
+			case skipped <- skippedFile:
+			default:
+			}
+			return
+		}
+		t.Errorf("error when applying the changes: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("error when creating the watcher: %s", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- watcher.Run()
+	}()
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("watched content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("watched"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	log := filepath.Join(dir, "watched.log")
+	if err := ioutil.WriteFile(log, []byte("watched"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "watched", []string{file})
+	select {
+	case skippedFile := <-skipped:
+		if expected := (SkippedFile{Filename: log, Reason: SkipDeniedExt}); skippedFile != expected {
+			t.Fatalf("incorrect skipped file: expected %v actual %v", expected, skippedFile)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("skipped file not reported")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ignoreFilename), []byte("ignored/\n"), 0666); err != nil {
+		t.Fatalf("error when writing the ignore file: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "ignored"), 0777); err != nil {
+		t.Fatalf("error when creating test directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ignored", "file"), []byte("watched"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(file, renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "watched", []string{renamed})
+
+	subdir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subdir, 0777); err != nil {
+		t.Fatalf("error when creating test directory: %s", err)
+	}
+	subfile := filepath.Join(subdir, "file")
+	if err := ioutil.WriteFile(subfile, []byte("nested content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "content", []string{renamed, subfile})
+
+	movedDir := filepath.Join(dir, "moved")
+	if err := os.Rename(subdir, movedDir); err != nil {
+		t.Fatalf("error when renaming test directory: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "nested", []string{filepath.Join(movedDir, "file")})
+
+	if err := os.Rename(filepath.Join(movedDir, "file"), filepath.Join(dir, "ignored", "nested")); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "nested", nil)
+
+	if err := os.Remove(renamed); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	waitForSearchResult(t, client, dir, "watched", nil)
+
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("error when closing the watcher: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("error returned by the watcher: %s", err)
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package client
+
+import "errors"
+
+// newFsNotifier is not supported on platforms other than Linux yet.
+func newFsNotifier(root string, ignored func(string) bool) (fsNotifier, error) {
+	return nil, errors.New("filesystem watching is not supported on this platform")
+}
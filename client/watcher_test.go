@@ -0,0 +1,76 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestIsHiddenPath tests the `isHiddenPath` function.  Checks that paths with
+// any hidden component are reported as hidden.
+func TestIsHiddenPath(t *testing.T) {
+	testCases := []struct {
+		relPath  string
+		expected bool
+	}{
+		{"file", false},
+		{"dir/file", false},
+		{".file", true},
+		{".dir/file", true},
+		{"dir/.file", true},
+	}
+	for _, testCase := range testCases {
+		if actual := isHiddenPath(testCase.relPath); actual != testCase.expected {
+			t.Fatalf("incorrect result for \"%s\": expected %t actual %t", testCase.relPath, testCase.expected, actual)
+		}
+	}
+}
+
+// TestCoalesceEvents tests the `coalesceEvents` function.  Checks that moves
+// are paired into renames and that superseded writes are dropped.
+func TestCoalesceEvents(t *testing.T) {
+	testCases := []struct {
+		name     string
+		events   []fsEvent
+		expected []fsEvent
+	}{
+		{
+			"repeated writes",
+			[]fsEvent{{op: fsWrite, path: "/a"}, {op: fsWrite, path: "/b"}, {op: fsWrite, path: "/a"}},
+			[]fsEvent{{op: fsWrite, path: "/a"}, {op: fsWrite, path: "/b"}},
+		},
+		{
+			"write then remove",
+			[]fsEvent{{op: fsWrite, path: "/a"}, {op: fsRemove, path: "/a"}},
+			[]fsEvent{{op: fsRemove, path: "/a"}},
+		},
+		{
+			"paired move",
+			[]fsEvent{{op: fsMovedFrom, path: "/a", cookie: 1}, {op: fsMovedTo, path: "/b", cookie: 1}},
+			[]fsEvent{{op: fsRename, oldPath: "/a", path: "/b"}},
+		},
+		{
+			"unpaired moves",
+			[]fsEvent{{op: fsMovedFrom, path: "/a", cookie: 1}, {op: fsMovedTo, path: "/b", cookie: 2}},
+			[]fsEvent{{op: fsRemove, path: "/a"}, {op: fsWrite, path: "/b"}},
+		},
+		{
+			"write then move",
+			[]fsEvent{{op: fsWrite, path: "/a"}, {op: fsWrite, path: "/b"}, {op: fsMovedFrom, path: "/a", cookie: 1}, {op: fsMovedTo, path: "/b", cookie: 1}},
+			[]fsEvent{{op: fsRename, oldPath: "/a", path: "/b"}, {op: fsWrite, path: "/b"}},
+		},
+		{
+			"remove then write",
+			[]fsEvent{{op: fsRemove, path: "/a"}, {op: fsWrite, path: "/a"}},
+			[]fsEvent{{op: fsRemove, path: "/a"}, {op: fsWrite, path: "/a"}},
+		},
+	}
+	for _, testCase := range testCases {
+		if actual := coalesceEvents(testCase.events); !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("incorrect result for %s: expected %v actual %v", testCase.name, testCase.expected, actual)
+		}
+	}
+}
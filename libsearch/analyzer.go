@@ -0,0 +1,350 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Analyzer turns the words of the documents and of the queries into the
+// keywords stored in the indexes.  The documents are first split into words at
+// white spaces, and each word is then analyzed on its own.  Every client of a
+// TLF must use the same analyzer, so it is recorded in the TLF information.
+type Analyzer interface {
+	// ID returns the identifier of the analyzer recorded in the TLF
+	// information.
+	ID() string
+	// Keywords returns the distinct non-empty keywords of `word`, which is a
+	// sequence of non-space characters.  A query word is matched by the
+	// documents containing all of its keywords.
+	Keywords(word string) []string
+	// Normalize maps a single token to its keyword.  Normalizing a keyword
+	// returns the keyword itself.
+	Normalize(token string) string
+	// Normalization returns the normalization scheme of the keywords.
+	Normalization() Normalization
+	// WithNormalization returns the same analyzer with the keywords
+	// normalized under `normalization` instead.
+	WithNormalization(normalization Normalization) Analyzer
+}
+
+// Tokenizer splits a word into tokens.
+type Tokenizer func(word string) []string
+
+// Normalizer maps a token to its keyword under `normalization`.
+type Normalizer func(token string, normalization Normalization) string
+
+// TokenFilter transforms the tokens produced by a `Tokenizer`, possibly
+// splitting, expanding or dropping some of them.
+type TokenFilter func(tokens []string) []string
+
+// chainAnalyzer is an `Analyzer` made of a tokenizer, a chain of filters and a
+// normalizer.
+type chainAnalyzer struct {
+	id            string        // The identifier of the analyzer.
+	tokenizer     Tokenizer     // The tokenizer splitting the words into tokens.
+	filters       []TokenFilter // The filters applied in order to the tokens.
+	normalizer    Normalizer    // The normalizer mapping the tokens to keywords.
+	normalization Normalization // The normalization scheme passed to `normalizer`.
+}
+
+// CreateAnalyzer creates an `Analyzer` identified by `id`, which splits each
+// word with `tokenizer`, applies the `filters` in order to the tokens, and
+// maps each of the resulting tokens to a keyword with `normalizer` under
+// `CurrentNormalization`.
+func CreateAnalyzer(id string, tokenizer Tokenizer, filters []TokenFilter, normalizer Normalizer) Analyzer {
+	return &chainAnalyzer{id: id, tokenizer: tokenizer, filters: filters, normalizer: normalizer, normalization: CurrentNormalization}
+}
+
+// ID implements the Analyzer interface.
+func (a *chainAnalyzer) ID() string {
+	return a.id
+}
+
+// Keywords implements the Analyzer interface.
+func (a *chainAnalyzer) Keywords(word string) []string {
+	tokens := a.tokenizer(word)
+	for _, filter := range a.filters {
+		tokens = filter(tokens)
+	}
+
+	keywords := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		keyword := a.Normalize(token)
+		if keyword == "" || containsString(keywords, keyword) {
+			continue
+		}
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+// Normalize implements the Analyzer interface.
+func (a *chainAnalyzer) Normalize(token string) string {
+	return a.normalizer(token, a.normalization)
+}
+
+// Normalization implements the Analyzer interface.
+func (a *chainAnalyzer) Normalization() Normalization {
+	return a.normalization
+}
+
+// WithNormalization implements the Analyzer interface.
+func (a *chainAnalyzer) WithNormalization(normalization Normalization) Analyzer {
+	copied := *a
+	copied.normalization = normalization
+	return &copied
+}
+
+// containsString returns whether `strs` contains `str`.  Only used on the few
+// keywords of a single word.
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// isAlphanumeric returns whether `c` is a letter or a digit.
+func isAlphanumeric(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// WholeWordTokenizer is the `Tokenizer` keeping each word as a single token.
+func WholeWordTokenizer(word string) []string {
+	return []string{word}
+}
+
+// IdentifierTokenizer is the `Tokenizer` splitting a word into the identifiers
+// of source code, i.e. the runs of letters, digits and underscores.
+func IdentifierTokenizer(word string) []string {
+	return strings.FieldsFunc(word, func(c rune) bool {
+		return !isAlphanumeric(c) && c != '_'
+	})
+}
+
+// splitIdentifier splits an identifier into its parts at the underscores and
+// at the case changes, e.g. "parseHTTPRequest_v2" into "parse", "HTTP",
+// "Request" and "v2".
+func splitIdentifier(identifier string) []string {
+	var parts []string
+	runes := []rune(identifier)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			parts = append(parts, string(runes[start:end]))
+		}
+		start = end
+	}
+	for i, c := range runes {
+		switch {
+		case c == '_':
+			flush(i)
+			start = i + 1
+		case i > start && unicode.IsUpper(c) && !unicode.IsUpper(runes[i-1]):
+			// A lower case letter or a digit followed by an upper case one,
+			// as in "parseHTTP".
+			flush(i)
+		case i > start+1 && unicode.IsLower(c) && unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i-2]):
+			// The last letter of an upper case run starting a new part, as
+			// in "HTTPRequest".
+			flush(i - 1)
+		}
+	}
+	flush(len(runes))
+	return parts
+}
+
+// IdentifierPartsFilter is the `TokenFilter` adding the camelCase and
+// snake_case parts of each identifier after the identifier itself, so that an
+// identifier can be found by any of its parts.
+func IdentifierPartsFilter(tokens []string) []string {
+	var filtered []string
+	for _, token := range tokens {
+		filtered = append(filtered, token)
+		if parts := splitIdentifier(token); len(parts) > 1 {
+			filtered = append(filtered, parts...)
+		}
+	}
+	return filtered
+}
+
+// isIdentifierSeparator returns whether `c` separates the components of an
+// email address or a URL, such as the user and the domain of an email.
+func isIdentifierSeparator(c rune) bool {
+	return strings.ContainsRune("@/:?#&=", c)
+}
+
+// isIdentifierPunctuation returns whether `c` is a punctuation kept inside the
+// keywords of emails and URLs.
+func isIdentifierPunctuation(c rune) bool {
+	return isIdentifierSeparator(c) || strings.ContainsRune(".-_+~%", c)
+}
+
+// EmailURLTokenizer is the `Tokenizer` for emails, URLs and similar
+// identifiers.  A word is kept whole once stripped of the surrounding
+// punctuation, followed by its components between the separators, e.g. the
+// domain of an email, and by its alphanumeric parts.
+func EmailURLTokenizer(word string) []string {
+	word = strings.TrimFunc(word, func(c rune) bool {
+		return !isAlphanumeric(c)
+	})
+	if word == "" {
+		return nil
+	}
+	tokens := []string{word}
+	if components := strings.FieldsFunc(word, isIdentifierSeparator); len(components) > 1 {
+		tokens = append(tokens, components...)
+	}
+	if parts := strings.FieldsFunc(word, func(c rune) bool { return !isAlphanumeric(c) }); len(parts) > 1 {
+		tokens = append(tokens, parts...)
+	}
+	return tokens
+}
+
+// isUnspacedScript returns whether `c` belongs to one of the scripts written
+// without spaces between the words, i.e. Han, Hiragana, Katakana and Thai.
+func isUnspacedScript(c rune) bool {
+	// The prolonged sound mark of Katakana is shared by both kana scripts.
+	return unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai) || c == 'ー'
+}
+
+// CJKBigramFilter is the `TokenFilter` for the scripts written without spaces
+// between the words.  Each run of characters of these scripts is replaced by
+// its characters and their overlapping bigrams, so that a query word matches
+// the documents containing all of its characters and bigrams.  The rest of a
+// token is kept as separate tokens, e.g. "KBFS里搜索" is turned into "KBFS",
+// "里", "搜", "里搜", "索" and "搜索".  The combining marks stay attached to
+// their characters.
+func CJKBigramFilter(tokens []string) []string {
+	var filtered []string
+	for _, token := range tokens {
+		if strings.IndexFunc(token, isUnspacedScript) < 0 {
+			filtered = append(filtered, token)
+			continue
+		}
+
+		var chars []string // The characters of the current run of unspaced scripts.
+		var other []rune   // The current run of the other characters.
+		flush := func() {
+			for i, char := range chars {
+				filtered = append(filtered, char)
+				if i > 0 {
+					filtered = append(filtered, chars[i-1]+char)
+				}
+			}
+			if len(other) > 0 {
+				filtered = append(filtered, string(other))
+			}
+			chars, other = nil, nil
+		}
+		// The compatibility forms such as the half-width Katakana are
+		// normalized first, so that they are split into the same characters.
+		for _, c := range norm.NFKC.String(token) {
+			switch {
+			case unicode.IsMark(c) && len(chars) > 0:
+				chars[len(chars)-1] += string(c)
+			case isUnspacedScript(c):
+				if len(other) > 0 {
+					flush()
+				}
+				chars = append(chars, string(c))
+			default:
+				if len(chars) > 0 {
+					flush()
+				}
+				other = append(other, c)
+			}
+		}
+		flush()
+	}
+	return filtered
+}
+
+// KeywordNormalizer is the `Normalizer` keeping only the letters, digits and,
+// from `FoldingNormalizationVersion` on, the combining marks.
+func KeywordNormalizer(token string, normalization Normalization) string {
+	return normalization.normalize(token, nil)
+}
+
+// IdentifierNormalizer is the `Normalizer` for the tokens of emails and URLs,
+// which also keeps their punctuation.
+func IdentifierNormalizer(token string, normalization Normalization) string {
+	return normalization.normalize(token, isIdentifierPunctuation)
+}
+
+// The IDs of the built-in analyzers.
+const (
+	ProseAnalyzerID      = "prose"
+	CodeAnalyzerID       = "code"
+	IdentifierAnalyzerID = "identifier"
+	CJKAnalyzerID        = "cjk"
+)
+
+// DefaultAnalyzerID is the ID of the analyzer used by the TLFs registered
+// without one, including all the TLFs registered before analyzers existed.
+const DefaultAnalyzerID = ProseAnalyzerID
+
+// The built-in analyzers.  `ProseAnalyzer` keeps each word whole, while
+// `CodeAnalyzer` splits the identifiers of source code into their camelCase
+// and snake_case parts, `IdentifierAnalyzer` keeps emails and URLs searchable
+// both whole and by their components, and `CJKAnalyzer` splits the text of
+// Chinese, Japanese and Thai into characters and bigrams.
+var (
+	ProseAnalyzer      = CreateAnalyzer(ProseAnalyzerID, WholeWordTokenizer, nil, KeywordNormalizer)
+	CodeAnalyzer       = CreateAnalyzer(CodeAnalyzerID, IdentifierTokenizer, []TokenFilter{IdentifierPartsFilter}, KeywordNormalizer)
+	IdentifierAnalyzer = CreateAnalyzer(IdentifierAnalyzerID, EmailURLTokenizer, nil, IdentifierNormalizer)
+	CJKAnalyzer        = CreateAnalyzer(CJKAnalyzerID, WholeWordTokenizer, []TokenFilter{CJKBigramFilter}, KeywordNormalizer)
+)
+
+// analyzersLock protects `analyzers`.
+var analyzersLock sync.RWMutex
+
+// analyzers is the map from the IDs to the registered analyzers.
+var analyzers = map[string]Analyzer{
+	ProseAnalyzerID:      ProseAnalyzer,
+	CodeAnalyzerID:       CodeAnalyzer,
+	IdentifierAnalyzerID: IdentifierAnalyzer,
+	CJKAnalyzerID:        CJKAnalyzer,
+}
+
+// RegisterAnalyzer makes `analyzer` available under its ID, so that it can be
+// recorded in the TLF information.  Returns an error if the ID is already
+// taken.
+func RegisterAnalyzer(analyzer Analyzer) error {
+	analyzersLock.Lock()
+	defer analyzersLock.Unlock()
+	if analyzer.ID() == "" {
+		return errors.New("empty analyzer ID")
+	}
+	if _, ok := analyzers[analyzer.ID()]; ok {
+		return errors.New("analyzer ID already registered")
+	}
+	analyzers[analyzer.ID()] = analyzer
+	return nil
+}
+
+// GetAnalyzer returns the registered analyzer with `id`.  An empty `id` stands
+// for `DefaultAnalyzerID`.  The analyzer uses `CurrentNormalization`, see
+// `Analyzer.WithNormalization` for the other schemes.
+func GetAnalyzer(id string) (Analyzer, error) {
+	if id == "" {
+		id = DefaultAnalyzerID
+	}
+	analyzersLock.RLock()
+	defer analyzersLock.RUnlock()
+	analyzer, ok := analyzers[id]
+	if !ok {
+		return nil, errors.New("unknown analyzer \"" + id + "\"")
+	}
+	return analyzer, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testKeywordsHelper checks that `analyzer` turns `word` into the `expected`
+// keywords.
+func testKeywordsHelper(t *testing.T, analyzer Analyzer, word string, expected []string) {
+	actual := analyzer.Keywords(word)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("incorrect keywords of \"%s\" for analyzer %s: expected %q actual %q", word, analyzer.ID(), expected, actual)
+	}
+	for _, keyword := range actual {
+		if normalized := analyzer.Normalize(keyword); normalized != keyword {
+			t.Fatalf("keyword \"%s\" of analyzer %s not stable under normalization: %s", keyword, analyzer.ID(), normalized)
+		}
+	}
+}
+
+// TestSplitIdentifier tests the `splitIdentifier` function.  Checks that the
+// identifiers are split at the underscores and the case changes.
+func TestSplitIdentifier(t *testing.T) {
+	testCases := map[string][]string{
+		"word":                {"word"},
+		"snake_case_names":    {"snake", "case", "names"},
+		"camelCaseNames":      {"camel", "Case", "Names"},
+		"parseHTTPRequest_v2": {"parse", "HTTP", "Request", "v2"},
+		"__init__":            {"init"},
+		"URL":                 {"URL"},
+	}
+	for identifier, expected := range testCases {
+		if actual := splitIdentifier(identifier); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect parts of \"%s\": expected %q actual %q", identifier, expected, actual)
+		}
+	}
+}
+
+// TestAnalyzers tests the built-in analyzers.  Checks the keywords of a few
+// typical words.
+func TestAnalyzers(t *testing.T) {
+	testKeywordsHelper(t, ProseAnalyzer, "TOP-NOTCH,", []string{"topnotch"})
+	testKeywordsHelper(t, ProseAnalyzer, "foo.bar@baz.com", []string{"foobarbazcom"})
+	testKeywordsHelper(t, ProseAnalyzer, "...", []string{})
+
+	testKeywordsHelper(t, CodeAnalyzer, "snake_case_names", []string{"snakecasenames", "snake", "case", "names"})
+	testKeywordsHelper(t, CodeAnalyzer, "obj.getUserName()", []string{"obj", "getusername", "get", "user", "name"})
+	testKeywordsHelper(t, CodeAnalyzer, "x", []string{"x"})
+
+	testKeywordsHelper(t, IdentifierAnalyzer, "<Foo.Bar@baz.com>,", []string{"foo.bar@baz.com", "foo.bar", "baz.com", "foo", "bar", "baz", "com"})
+	testKeywordsHelper(t, IdentifierAnalyzer, "https://keybase.io/docs", []string{"https://keybase.io/docs", "https", "keybase.io", "docs", "keybase", "io"})
+	testKeywordsHelper(t, IdentifierAnalyzer, "Hello!", []string{"hello"})
+
+	testKeywordsHelper(t, CJKAnalyzer, "KBFS里搜索。", []string{"kbfs", "里", "搜", "里搜", "索", "搜索"})
+	testKeywordsHelper(t, CJKAnalyzer, "東京都", []string{"東", "京", "東京", "都", "京都"})
+	testKeywordsHelper(t, CJKAnalyzer, "ｺｰﾋｰ", []string{"コ", "ー", "コー", "ヒ", "ーヒ", "ヒー"})
+	testKeywordsHelper(t, CJKAnalyzer, "ภาษาไทย", []string{"ภ", "า", "ภา", "ษ", "าษ", "ษา", "ไ", "าไ", "ท", "ไท", "ย", "ทย"})
+	testKeywordsHelper(t, CJKAnalyzer, "Hello!", []string{"hello"})
+}
+
+// TestWithNormalization tests the `WithNormalization` method.  Checks that the
+// keywords follow the normalization scheme of the analyzer, and that the
+// original analyzer is left unchanged.
+func TestWithNormalization(t *testing.T) {
+	legacy := IdentifierAnalyzer.WithNormalization(LegacyNormalization)
+	if legacy.ID() != IdentifierAnalyzerID || legacy.Normalization() != LegacyNormalization {
+		t.Fatalf("incorrect legacy analyzer")
+	}
+	if IdentifierAnalyzer.Normalization() != CurrentNormalization {
+		t.Fatalf("original analyzer modified")
+	}
+	testKeywordsHelper(t, legacy, "Straße@Example.com", []string{"straße@example.com", "straße", "example.com", "example", "com"})
+	testKeywordsHelper(t, IdentifierAnalyzer, "Straße@Example.com", []string{"strasse@example.com", "strasse", "example.com", "example", "com"})
+
+	stripped := ProseAnalyzer.WithNormalization(Normalization{Version: CurrentNormalizationVersion, StripDiacritics: true})
+	testKeywordsHelper(t, stripped, "Café,", []string{"cafe"})
+	testKeywordsHelper(t, ProseAnalyzer, "Café,", []string{"café"})
+}
+
+// TestGetAnalyzer tests the `GetAnalyzer` and `RegisterAnalyzer` functions.
+// Checks that the empty ID stands for the default analyzer, and that custom
+// analyzers can be registered once.
+func TestGetAnalyzer(t *testing.T) {
+	analyzer, err := GetAnalyzer("")
+	if err != nil || analyzer.ID() != DefaultAnalyzerID {
+		t.Fatalf("incorrect analyzer for the empty ID: %v, %v", analyzer, err)
+	}
+	if _, err := GetAnalyzer("unknown"); err == nil {
+		t.Fatalf("no error returned for an unknown analyzer")
+	}
+
+	custom := CreateAnalyzer("testCustom", IdentifierTokenizer, nil, KeywordNormalizer)
+	if err := RegisterAnalyzer(custom); err != nil {
+		t.Fatalf("error when registering the analyzer: %s", err)
+	}
+	if err := RegisterAnalyzer(custom); err == nil {
+		t.Fatalf("no error returned when registering the analyzer twice")
+	}
+	if analyzer, err := GetAnalyzer("testCustom"); err != nil || analyzer != custom {
+		t.Fatalf("incorrect registered analyzer: %v, %v", analyzer, err)
+	}
+}
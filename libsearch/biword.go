@@ -0,0 +1,78 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"hash"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// When the phrase search is enabled for a TLF, the index of a document also
+// contains the codewords of its biwords, i.e. the pairs of keywords adjacent
+// in the document as read by `ScanKeywords`.  The codewords are derived from
+// the trapdoors of the two keywords joined by `biwordSeparator`, with a domain
+// separation, so that a biword is never confused with a keyword.  A phrase is
+// searched for as the conjunction of its biwords, which may also be found
+// apart from each other, so the results need to be verified.
+
+// biwordSeparator joins the two keywords of a biword.  It never appears in a
+// keyword.
+const biwordSeparator = "\x00"
+
+// biwordTrapdoor derives the trapdoor of a biword from one of the trapdoors
+// of its joined keywords.
+func biwordTrapdoor(h func() hash.Hash, trapdoor []byte) []byte {
+	mac := hmac.New(h, trapdoor)
+	mac.Write([]byte("kbfs_search_biword"))
+	return mac.Sum(nil)
+}
+
+// Biwords returns true if the biwords of the documents are indexed by the
+// builder for the phrase searches.
+func (sib *SecureIndexBuilder) Biwords() bool {
+	return sib.biwords
+}
+
+// computeBiwordTrapdoors computes the trapdoor values for the joined `biword`.
+func (sib *SecureIndexBuilder) computeBiwordTrapdoors(biword string) [][]byte {
+	trapdoors := sib.trapdoorFunc(biword)
+	for i, trapdoor := range trapdoors {
+		trapdoors[i] = biwordTrapdoor(sib.hash, trapdoor)
+	}
+	return trapdoors
+}
+
+// ComputeBiwordTrapdoors computes the trapdoor values matching the documents
+// where the keyword `first` is immediately followed by the keyword `second`.
+// Both keywords must already be normalized.  Returns nil if the biwords are
+// not indexed.
+func (sib *SecureIndexBuilder) ComputeBiwordTrapdoors(first, second string) [][]byte {
+	if !sib.biwords {
+		return nil
+	}
+	return sib.computeBiwordTrapdoors(first + biwordSeparator + second)
+}
+
+// addBiword adds the biword of the adjacent keywords `prev` and `word` to
+// `biwords`, unless the biwords are not indexed or `prev` is empty.
+func (sib *SecureIndexBuilder) addBiword(biwords map[string]bool, prev, word string) {
+	if sib.biwords && prev != "" {
+		biwords[prev+biwordSeparator+word] = true
+	}
+}
+
+// insertBiwords inserts the codewords of the joined `biwords` into the bloom
+// filter `bf` of an index with `nonce`.  Returns the number of biwords
+// inserted.
+func (sib *SecureIndexBuilder) insertBiwords(bf bitarray.BitArray, nonce uint64, biwords map[string]bool) int64 {
+	for biword := range biwords {
+		for _, trapdoor := range sib.computeBiwordTrapdoors(biword) {
+			bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
+		}
+	}
+	return int64(len(biwords))
+}
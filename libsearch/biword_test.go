@@ -0,0 +1,55 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestBiwordSearch tests the biword trapdoors.  Checks that the adjacent
+// keywords are found in order in the indexes built with biwords, with or
+// without a term frequency sketch, and that a biword is not confused with a
+// keyword.
+func TestBiwordSearch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{Phrases: true})
+	if !sib.Biwords() {
+		t.Fatalf("biwords not enabled for the builder")
+	}
+	content := "The quick brown fox -- jumps"
+	secIndex := buildTestSecureIndex(t, sib, content)
+	streamIndex, _, err := sib.BuildSecureIndexStream(bytes.NewReader([]byte(content)), int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the index: %s", err)
+	}
+
+	for _, index := range []SecureIndex{secIndex, streamIndex} {
+		for _, biword := range [][2]string{{"the", "quick"}, {"quick", "brown"}, {"fox", "jumps"}} {
+			if !SearchSecureIndex(index, sserver1.Trapdoor{Codeword: sib.ComputeBiwordTrapdoors(biword[0], biword[1])}) {
+				t.Fatalf("biword %v not found in the index", biword)
+			}
+		}
+		for _, biword := range [][2]string{{"quick", "the"}, {"quick", "fox"}, {"jumps", "the"}} {
+			if SearchSecureIndex(index, sserver1.Trapdoor{Codeword: sib.ComputeBiwordTrapdoors(biword[0], biword[1])}) {
+				t.Fatalf("biword %v found in the index", biword)
+			}
+		}
+		if SearchSecureIndex(index, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("quick" + biwordSeparator + "brown")}) {
+			t.Fatalf("biword found as a keyword in the index")
+		}
+	}
+
+	sib = CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	if trapdoors := sib.ComputeBiwordTrapdoors("quick", "brown"); trapdoors != nil {
+		t.Fatalf("biword trapdoors computed without indexed biwords")
+	}
+}
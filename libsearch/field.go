@@ -0,0 +1,134 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"hash"
+	"path/filepath"
+	"strings"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// When the field search is enabled for a TLF, the index of a document also
+// contains the codewords of the words of its relative pathname, tagged with
+// the field they appear in: `FieldName` for the base name of the file,
+// `FieldPath` for any component of the pathname, and `FieldExt` for the
+// extension.  The codewords are derived from the trapdoors of the keywords
+// with a domain separation per field, so that a word of the pathname is never
+// confused with the same word in the content, or in another field.
+
+// The fields of the pathname of a document.
+const (
+	FieldName = "name" // The words of the base name.
+	FieldPath = "path" // The words of all the components.
+	FieldExt  = "ext"  // The extension, without its leading dot.
+)
+
+// IsField returns true if `field` is one of the fields of a pathname.
+func IsField(field string) bool {
+	return field == FieldName || field == FieldPath || field == FieldExt
+}
+
+// fieldTrapdoor derives the trapdoor of a keyword in `field` from one of the
+// trapdoors of the keyword.
+func fieldTrapdoor(h func() hash.Hash, trapdoor []byte, field string) []byte {
+	mac := hmac.New(h, trapdoor)
+	mac.Write([]byte("kbfs_search_field_" + field))
+	return mac.Sum(nil)
+}
+
+// FieldKeywords returns the distinct keywords of `value` given by `analyzer`,
+// after splitting it at the characters other than letters and digits, so that
+// the words of a pathname are separated even by the analyzers keeping the
+// punctuation inside the keywords.
+func FieldKeywords(value string, analyzer Analyzer) []string {
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(value, func(c rune) bool { return !isAlphanumeric(c) }) {
+		for _, keyword := range analyzer.Keywords(word) {
+			if !seen[keyword] {
+				seen[keyword] = true
+				keywords = append(keywords, keyword)
+			}
+		}
+	}
+	return keywords
+}
+
+// PathFields returns the keywords of each of the fields of the relative
+// `pathname` given by `analyzer`.  A field without any keyword is left out.
+func PathFields(pathname string, analyzer Analyzer) map[string][]string {
+	name := filepath.Base(pathname)
+	values := map[string]string{
+		FieldName: name,
+		FieldPath: pathname,
+		FieldExt:  strings.TrimPrefix(filepath.Ext(name), "."),
+	}
+	fields := make(map[string][]string)
+	for field, value := range values {
+		if keywords := FieldKeywords(value, analyzer); len(keywords) > 0 {
+			fields[field] = keywords
+		}
+	}
+	return fields
+}
+
+// Paths returns true if the words of the pathnames of the documents are
+// indexed by the builder for the field searches.
+func (sib *SecureIndexBuilder) Paths() bool {
+	return sib.paths
+}
+
+// ComputeFieldTrapdoors computes the trapdoor values matching the documents
+// with `keyword` in `field`, which is either a field of their pathnames or a
+// metadata field.  The keyword must already be normalized.  Returns nil if the
+// field is not indexed.
+func (sib *SecureIndexBuilder) ComputeFieldTrapdoors(field, keyword string) [][]byte {
+	if (IsField(field) && !sib.paths) || (IsMetadataField(field) && !sib.metadata) {
+		return nil
+	}
+	trapdoors := sib.trapdoorFunc(keyword)
+	for i, trapdoor := range trapdoors {
+		trapdoors[i] = fieldTrapdoor(sib.hash, trapdoor, field)
+	}
+	return trapdoors
+}
+
+// insertFields inserts the codewords of the fields of the relative `pathname`
+// into the bloom filter `bf` of an index with `nonce`.  Returns the number of
+// field keywords inserted.
+func (sib *SecureIndexBuilder) insertFields(bf bitarray.BitArray, nonce uint64, pathname string) int64 {
+	if !sib.paths || pathname == "" {
+		return 0
+	}
+	var numInserted int64
+	for field, keywords := range PathFields(pathname, sib.analyzer) {
+		for _, keyword := range keywords {
+			for _, trapdoor := range sib.ComputeFieldTrapdoors(field, keyword) {
+				bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
+			}
+			numInserted++
+		}
+	}
+	return numInserted
+}
+
+// maxFieldInsertions returns the number of field keywords and metadata values
+// up to which the index of a document with the relative `pathname` is
+// blinded.  Each of the three fields of the pathname has no more keywords than
+// twice the bytes of the pathname, whose length is already revealed by the
+// document ID, and every document has the same number of metadata values.
+func (sib *SecureIndexBuilder) maxFieldInsertions(pathname string) int64 {
+	var maxInsertions int64
+	if sib.paths {
+		maxInsertions += 6 * int64(len(pathname))
+	}
+	if sib.metadata {
+		maxInsertions += numMetadataValues
+	}
+	return maxInsertions
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestPathFields tests the `PathFields` function.  Checks that the pathname
+// is split into words at the punctuation, even for the analyzers keeping it
+// inside the keywords, and that the fields without keywords are left out.
+func TestPathFields(t *testing.T) {
+	fields := PathFields("Invoices/2024/q3_Report.tar.gz", IdentifierAnalyzer)
+	expected := map[string][]string{
+		FieldName: {"q3", "report", "tar", "gz"},
+		FieldPath: {"invoices", "2024", "q3", "report", "tar", "gz"},
+		FieldExt:  {"gz"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("incorrect fields: expected %v actual %v", expected, fields)
+	}
+
+	fields = PathFields("README", ProseAnalyzer)
+	expected = map[string][]string{
+		FieldName: {"readme"},
+		FieldPath: {"readme"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("incorrect fields: expected %v actual %v", expected, fields)
+	}
+}
+
+// TestFieldSearch tests the field trapdoors.  Checks that the words of the
+// pathname are found in their fields only, and are not confused with the
+// keywords of the content.
+func TestFieldSearch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{Paths: true})
+	if !sib.Paths() {
+		t.Fatalf("paths not enabled for the builder")
+	}
+	content := "The quarterly report"
+	secIndex, _, err := sib.BuildSecureIndexStreamWithFields(bytes.NewReader([]byte(content)), "invoices/2024/q3.pdf", nil, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the index: %s", err)
+	}
+
+	found := [][2]string{{FieldName, "q3"}, {FieldName, "pdf"}, {FieldPath, "invoices"}, {FieldPath, "2024"}, {FieldPath, "q3"}, {FieldExt, "pdf"}}
+	for _, field := range found {
+		if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(field[0], field[1])}) {
+			t.Fatalf("field keyword %v not found in the index", field)
+		}
+	}
+	notFound := [][2]string{{FieldName, "invoices"}, {FieldExt, "q3"}, {FieldPath, "report"}, {FieldName, "quarterly"}}
+	for _, field := range notFound {
+		if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(field[0], field[1])}) {
+			t.Fatalf("field keyword %v found in the index", field)
+		}
+	}
+	if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("invoices")}) {
+		t.Fatalf("word of the pathname found in the content")
+	}
+	if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("quarterly")}) {
+		t.Fatalf("word of the content not found in the index")
+	}
+
+	sib = CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	if trapdoors := sib.ComputeFieldTrapdoors(FieldName, "q3"); trapdoors != nil {
+		t.Fatalf("field trapdoors computed without indexed paths")
+	}
+}
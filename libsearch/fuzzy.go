@@ -0,0 +1,133 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"hash"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// When the fuzzy search is enabled for a TLF, the index of a document also
+// contains the codewords of the character trigrams of its keywords.  The
+// keywords are delimited by `trigramBoundary` beforehand, so that a keyword of
+// n characters has n trigrams and even the keywords of one or two characters
+// have one.  The trigram trapdoors are computed with their own keys, derived
+// from the keys of the words with a domain separation, so that a trigram is
+// never confused with a keyword of the same value.
+//
+// A single edit changes at most three trigrams of a keyword, so a keyword
+// within an edit distance of d from a word with n unique trigrams shares at
+// least n-3d of them.  The fuzzy searches therefore look for the indexes
+// matching at least that many of the trigram trapdoors, and the candidates
+// need to be verified by computing the actual edit distances.
+
+// trigramBoundary marks the start and the end of a keyword when splitting it
+// into trigrams.  It never appears in a keyword.
+const trigramBoundary = '\x00'
+
+// trigramKey derives the key of the trigram trapdoors from one of the keys of
+// the word trapdoors.
+func trigramKey(h func() hash.Hash, key []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte("kbfs_search_trigram"))
+	return mac.Sum(nil)
+}
+
+// Trigrams returns the unique character trigrams of `keyword`, delimited by
+// the boundary character, in the order of their first occurrences.
+func Trigrams(keyword string) []string {
+	chars := append(append([]rune{trigramBoundary}, []rune(keyword)...), trigramBoundary)
+	seen := make(map[string]bool)
+	var trigrams []string
+	for i := 0; i+3 <= len(chars); i++ {
+		trigram := string(chars[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			trigrams = append(trigrams, trigram)
+		}
+	}
+	return trigrams
+}
+
+// MinTrigramMatches returns the minimum number of the `numTrigrams` unique
+// trigrams of a word that a keyword within an edit distance of `maxDistance`
+// from it shares, which is at least 1.  A keyword can only be found by the
+// fuzzy searches if it shares at least this many trigrams.
+func MinTrigramMatches(numTrigrams, maxDistance int) int {
+	minMatches := numTrigrams - 3*maxDistance
+	if minMatches < 1 {
+		return 1
+	}
+	return minMatches
+}
+
+// Fuzzy returns true if the trigrams of the keywords are indexed by the
+// builder for the fuzzy searches.
+func (sib *SecureIndexBuilder) Fuzzy() bool {
+	return sib.trigramKeys != nil
+}
+
+// computeTrigramTrapdoors computes the trapdoor values for `trigram`.
+func (sib *SecureIndexBuilder) computeTrigramTrapdoors(trigram string) [][]byte {
+	trapdoors := make([][]byte, len(sib.trigramKeys))
+	for i, key := range sib.trigramKeys {
+		mac := hmac.New(sib.hash, key)
+		mac.Write([]byte(trigram))
+		trapdoors[i] = mac.Sum(nil)
+	}
+	return trapdoors
+}
+
+// ComputeTrigramTrapdoors computes the trapdoors of each of the unique
+// trigrams of `keyword`, which must already be normalized.  Returns nil if
+// the trigrams are not indexed.
+func (sib *SecureIndexBuilder) ComputeTrigramTrapdoors(keyword string) []sserver1.Trapdoor {
+	if !sib.Fuzzy() {
+		return nil
+	}
+	trigrams := Trigrams(keyword)
+	trapdoors := make([]sserver1.Trapdoor, len(trigrams))
+	for i, trigram := range trigrams {
+		trapdoors[i] = sserver1.Trapdoor{Codeword: sib.computeTrigramTrapdoors(trigram)}
+	}
+	return trapdoors
+}
+
+// insertTrigrams inserts the codewords of the trigrams of `word` into the
+// bloom filter `bf` of an index with `nonce`, skipping the trigrams already in
+// `inserted`.  Returns the number of trigrams inserted.
+func (sib *SecureIndexBuilder) insertTrigrams(bf bitarray.BitArray, nonce uint64, word string, inserted map[string]bool) int64 {
+	if !sib.Fuzzy() {
+		return 0
+	}
+	var numInserted int64
+	for _, trigram := range Trigrams(word) {
+		if inserted[trigram] {
+			continue
+		}
+		inserted[trigram] = true
+		for _, trapdoor := range sib.computeTrigramTrapdoors(trigram) {
+			bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
+		}
+		numInserted++
+	}
+	return numInserted
+}
+
+// CountSecureIndexMatches returns the number of the `trapdoors` whose words
+// are possibly contained in the index `secIndex`.
+// NOTE: Overestimations are possible due to false positives.
+func CountSecureIndexMatches(secIndex SecureIndex, trapdoors []sserver1.Trapdoor) int {
+	numMatches := 0
+	for _, trapdoor := range trapdoors {
+		if SearchSecureIndex(secIndex, trapdoor) {
+			numMatches++
+		}
+	}
+	return numMatches
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestTrigrams tests the `Trigrams` function.  Checks that the keywords are
+// delimited by the boundary character, that the trigrams are made of
+// characters rather than bytes, and that the duplicates are removed.
+func TestTrigrams(t *testing.T) {
+	testCases := []struct {
+		keyword  string
+		expected []string
+	}{
+		{"cat", []string{"\x00ca", "cat", "at\x00"}},
+		{"a", []string{"\x00a\x00"}},
+		{"café", []string{"\x00ca", "caf", "afé", "fé\x00"}},
+		{"aaaa", []string{"\x00aa", "aaa", "aa\x00"}},
+		{"", nil},
+	}
+	for _, testCase := range testCases {
+		if actual := Trigrams(testCase.keyword); !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("incorrect trigrams of \"%s\": expected %q actual %q", testCase.keyword, testCase.expected, actual)
+		}
+	}
+}
+
+// TestMinTrigramMatches tests the `MinTrigramMatches` function.
+func TestMinTrigramMatches(t *testing.T) {
+	testCases := []struct {
+		numTrigrams, maxDistance, expected int
+	}{
+		{10, 0, 10},
+		{10, 1, 7},
+		{10, 2, 4},
+		{5, 2, 1},
+		{3, 1, 1},
+	}
+	for _, testCase := range testCases {
+		if actual := MinTrigramMatches(testCase.numTrigrams, testCase.maxDistance); actual != testCase.expected {
+			t.Fatalf("incorrect minimum matches for %d trigrams within %d edits: expected %d actual %d", testCase.numTrigrams, testCase.maxDistance, testCase.expected, actual)
+		}
+	}
+}
+
+// TestFuzzySearch tests the trigram trapdoors.  Checks that a misspelled word
+// matches enough of the trigrams of the indexed word, that a trigram is not
+// confused with a word of the same value, and that no trigram is indexed when
+// the fuzzy search is disabled.
+func TestFuzzySearch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{Fuzzy: true})
+	if !sib.Fuzzy() {
+		t.Fatalf("fuzzy search not enabled for the builder")
+	}
+	secIndex := buildTestSecureIndex(t, sib, "The necessary configuration")
+
+	// "neccessary" has 10 trigrams, and is one edit away from "necessary".
+	trapdoors := sib.ComputeTrigramTrapdoors("neccessary")
+	if len(trapdoors) != 10 {
+		t.Fatalf("incorrect number of trigram trapdoors: %d", len(trapdoors))
+	}
+	if numMatches := CountSecureIndexMatches(secIndex, trapdoors); numMatches < MinTrigramMatches(len(trapdoors), 1) {
+		t.Fatalf("too few trigrams matched for a misspelled word: %d", numMatches)
+	}
+	if numMatches := CountSecureIndexMatches(secIndex, sib.ComputeTrigramTrapdoors("zebra")); numMatches != 0 {
+		t.Fatalf("trigrams of an absent word matched: %d", numMatches)
+	}
+	if numMatches := CountSecureIndexMatches(secIndex, sib.ComputeTrigramTrapdoors("the")); numMatches != 3 {
+		t.Fatalf("incorrect number of trigrams matched for an indexed word: %d", numMatches)
+	}
+	if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("nec")}) {
+		t.Fatalf("trigram found as a word in the index")
+	}
+
+	sib = CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	if trapdoors := sib.ComputeTrigramTrapdoors("necessary"); trapdoors != nil {
+		t.Fatalf("trigram trapdoors computed without indexed trigrams")
+	}
+}
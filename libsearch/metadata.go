@@ -0,0 +1,202 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"math/bits"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// When the metadata search is enabled for a TLF, the index of a document also
+// contains the codewords of the buckets of its metadata, tagged with their
+// fields in the same way as the words of the pathnames.  The exact values are
+// never indexed, so a range of values is searched for as the set of the
+// buckets it overlaps, and the results need to be verified:
+//
+//   - `FieldSize` has one bucket per power of two of the size in bytes.
+//   - `FieldModTime` has one bucket per level of a hierarchy over the UTC days
+//     since the Unix epoch, the bucket of level l holding 2^l days, so that any
+//     range of days is covered by a few buckets of different levels.
+//   - `FieldType` has the MIME type of the document along with its major type,
+//     such as "image/png" and "image/*".
+
+// The metadata fields of a document.
+const (
+	FieldSize    = "size"  // The bucket of the size.
+	FieldModTime = "mtime" // The buckets of the modification time.
+	FieldType    = "type"  // The MIME type and the major type.
+)
+
+// numModTimeLevels is the number of levels of the buckets of the modification
+// times.  The buckets of the last level hold about 179 years.
+const numModTimeLevels = 17
+
+// maxModTimeDay is the last day since the Unix epoch with its own bucket.  The
+// later modification times share its buckets.
+const maxModTimeDay = 1<<numModTimeLevels - 1
+
+// secondsPerDay is the number of seconds in a UTC day.
+const secondsPerDay = 24 * 60 * 60
+
+// numMetadataValues is the number of values of the metadata fields of every
+// document.
+const numMetadataValues = 1 + numModTimeLevels + 2
+
+// IsMetadataField returns true if `field` is one of the metadata fields.
+func IsMetadataField(field string) bool {
+	return field == FieldSize || field == FieldModTime || field == FieldType
+}
+
+// Metadata holds the metadata of a document indexed for the metadata search.
+type Metadata struct {
+	Size    int64     // The size in bytes.
+	ModTime time.Time // The modification time.
+	Type    string    // The MIME type without parameters, such as "text/plain".
+}
+
+// BucketRange is a bucket of a metadata field, along with the range of values
+// within it that are searched for.
+type BucketRange struct {
+	Bucket string // The value of the bucket in its field.
+	Min    int64  // The smallest value searched for in the bucket.
+	Max    int64  // The largest value searched for in the bucket.
+}
+
+// sizeBucket returns the bucket of `size`, which holds the sizes with the
+// same number of significant bits.
+func sizeBucket(size int64) int {
+	if size < 0 {
+		size = 0
+	}
+	return bits.Len64(uint64(size))
+}
+
+// modTimeDay returns the UTC day since the Unix epoch of the Unix time `sec`,
+// clamped to the days with their own buckets.
+func modTimeDay(sec int64) int64 {
+	if sec < 0 {
+		return 0
+	}
+	day := sec / secondsPerDay
+	if day > maxModTimeDay {
+		return maxModTimeDay
+	}
+	return day
+}
+
+// modTimeBucket returns the bucket of level `level` holding `day`.
+func modTimeBucket(level uint, day int64) string {
+	return strconv.Itoa(int(level)) + ":" + strconv.FormatInt(day>>level, 10)
+}
+
+// Fields returns the values of each of the metadata fields of the document.
+func (m Metadata) Fields() map[string][]string {
+	day := modTimeDay(m.ModTime.Unix())
+	modTimes := make([]string, numModTimeLevels)
+	for level := range modTimes {
+		modTimes[level] = modTimeBucket(uint(level), day)
+	}
+	fileType := strings.ToLower(m.Type)
+	types := []string{fileType}
+	if i := strings.Index(fileType, "/"); i >= 0 {
+		types = append(types, fileType[:i+1]+"*")
+	}
+	return map[string][]string{
+		FieldSize:    {strconv.Itoa(sizeBucket(m.Size))},
+		FieldModTime: modTimes,
+		FieldType:    types,
+	}
+}
+
+// SizeRanges returns the buckets covering the sizes from `min` to `max` bytes,
+// both inclusive.  Returns nil if the range is empty.
+func SizeRanges(min, max int64) []BucketRange {
+	if min < 0 {
+		min = 0
+	}
+	if min > max {
+		return nil
+	}
+	var ranges []BucketRange
+	for bucket := sizeBucket(min); bucket <= sizeBucket(max); bucket++ {
+		r := BucketRange{Bucket: strconv.Itoa(bucket), Min: min, Max: max}
+		if bucket > 0 && int64(1)<<uint(bucket-1) > r.Min {
+			r.Min = int64(1) << uint(bucket-1)
+		}
+		if bucket < 63 && int64(1)<<uint(bucket)-1 < r.Max {
+			r.Max = int64(1)<<uint(bucket) - 1
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// ModTimeRanges returns the buckets covering the modification times from the
+// Unix times `min` to `max` in seconds, both inclusive.  The range of days is
+// covered by the largest buckets fitting in it, so that there are at most two
+// buckets per level.  Returns nil if the range is empty.
+func ModTimeRanges(min, max int64) []BucketRange {
+	if min > max {
+		return nil
+	}
+	var ranges []BucketRange
+	for day, lastDay := modTimeDay(min), modTimeDay(max); day <= lastDay; {
+		level := uint(0)
+		for level+1 < numModTimeLevels && day%(1<<(level+1)) == 0 && day+1<<(level+1)-1 <= lastDay {
+			level++
+		}
+		ranges = append(ranges, BucketRange{Bucket: modTimeBucket(level, day), Min: min, Max: max})
+		day += 1 << level
+	}
+	return ranges
+}
+
+// FileType returns the MIME type of the file at `pathname` without its
+// parameters, from its extension if known, or else from the leading bytes
+// `head` of its content.
+// NOTE: The known extensions depend on the MIME database of the system, so
+// all the clients of a TLF should run on similar systems.
+func FileType(pathname string, head []byte) string {
+	fileType := mime.TypeByExtension(filepath.Ext(pathname))
+	if fileType == "" {
+		fileType = http.DetectContentType(head)
+	}
+	if mediaType, _, err := mime.ParseMediaType(fileType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(fileType)
+}
+
+// Metadata returns true if the metadata of the documents are indexed by the
+// builder for the metadata searches.
+func (sib *SecureIndexBuilder) Metadata() bool {
+	return sib.metadata
+}
+
+// insertMetadata inserts the codewords of the metadata fields of `metadata`
+// into the bloom filter `bf` of an index with `nonce`.  Returns the number of
+// values inserted.
+func (sib *SecureIndexBuilder) insertMetadata(bf bitarray.BitArray, nonce uint64, metadata *Metadata) int64 {
+	if !sib.metadata || metadata == nil {
+		return 0
+	}
+	var numInserted int64
+	for field, values := range metadata.Fields() {
+		for _, value := range values {
+			for _, trapdoor := range sib.ComputeFieldTrapdoors(field, value) {
+				bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
+			}
+			numInserted++
+		}
+	}
+	return numInserted
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestSizeRanges tests the `SizeRanges` function.  Checks that the buckets
+// cover the range with their parts of it.
+func TestSizeRanges(t *testing.T) {
+	expected := []BucketRange{{"10", 1000, 1023}, {"11", 1024, 2047}, {"12", 2048, 3000}}
+	if ranges := SizeRanges(1000, 3000); !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("incorrect size ranges: expected %v actual %v", expected, ranges)
+	}
+	expected = []BucketRange{{"0", 0, 0}, {"1", 1, 1}}
+	if ranges := SizeRanges(-5, 1); !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("incorrect size ranges: expected %v actual %v", expected, ranges)
+	}
+	ranges := SizeRanges(1<<40, math.MaxInt64)
+	if len(ranges) != 23 || ranges[len(ranges)-1] != (BucketRange{"63", 1 << 62, math.MaxInt64}) {
+		t.Fatalf("incorrect unbounded size ranges: %v", ranges)
+	}
+	if ranges := SizeRanges(10, 9); ranges != nil {
+		t.Fatalf("size ranges returned for an empty range: %v", ranges)
+	}
+}
+
+// TestModTimeRanges tests the `ModTimeRanges` function.  Checks that every day
+// of the range is covered by exactly one bucket, and that no other day is.
+func TestModTimeRanges(t *testing.T) {
+	for _, days := range [][2]int64{{0, 0}, {3, 17}, {20000, 20454}, {1, maxModTimeDay}} {
+		ranges := ModTimeRanges(days[0]*secondsPerDay+5, days[1]*secondsPerDay+5)
+		if len(ranges) > 2*numModTimeLevels {
+			t.Fatalf("too many buckets for the days %v: %d", days, len(ranges))
+		}
+		covered := make(map[int64]int)
+		for _, r := range ranges {
+			parts := strings.Split(r.Bucket, ":")
+			level, _ := strconv.ParseUint(parts[0], 10, 32)
+			index, _ := strconv.ParseInt(parts[1], 10, 64)
+			for day := index << level; day < (index+1)<<level; day++ {
+				covered[day]++
+			}
+		}
+		if int64(len(covered)) != days[1]-days[0]+1 {
+			t.Fatalf("incorrect number of days covered for %v: %d", days, len(covered))
+		}
+		for day, count := range covered {
+			if day < days[0] || day > days[1] || count != 1 {
+				t.Fatalf("incorrect coverage of the day %d for %v: %d", day, days, count)
+			}
+		}
+	}
+	if ranges := ModTimeRanges(10, 9); ranges != nil {
+		t.Fatalf("buckets returned for an empty range: %v", ranges)
+	}
+}
+
+// TestFileType tests the `FileType` function.  Checks that the type is given
+// by the extension if known, and by the content otherwise.
+func TestFileType(t *testing.T) {
+	testCases := []struct {
+		pathname string
+		head     string
+		expected string
+	}{
+		{"report.PDF", "", "application/pdf"},
+		{"photo.png", "not really", "image/png"},
+		{"notes", "plain text", "text/plain"},
+		{"document", "%PDF-1.4", "application/pdf"},
+	}
+	for _, testCase := range testCases {
+		if fileType := FileType(testCase.pathname, []byte(testCase.head)); fileType != testCase.expected {
+			t.Fatalf("incorrect type of \"%s\": expected %s actual %s", testCase.pathname, testCase.expected, fileType)
+		}
+	}
+}
+
+// TestMetadataSearch tests the metadata trapdoors.  Checks that the buckets of
+// the metadata are found in their fields only.
+func TestMetadataSearch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{Metadata: true})
+	if !sib.Metadata() {
+		t.Fatalf("metadata not enabled for the builder")
+	}
+	content := "The quarterly report"
+	metadata := Metadata{Size: 1500, ModTime: time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC), Type: "application/pdf"}
+	secIndex, _, err := sib.BuildSecureIndexStreamWithFields(bytes.NewReader([]byte(content)), "", &metadata, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the index: %s", err)
+	}
+
+	for field, values := range metadata.Fields() {
+		for _, value := range values {
+			if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(field, value)}) {
+				t.Fatalf("metadata value %s:%s not found in the index", field, value)
+			}
+		}
+	}
+	found := func(field string, ranges []BucketRange) bool {
+		for _, r := range ranges {
+			if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(field, r.Bucket)}) {
+				return true
+			}
+		}
+		return false
+	}
+	day := int64(20526) * secondsPerDay // 2026-03-14
+	if !found(FieldSize, SizeRanges(1000, 3000)) || found(FieldSize, SizeRanges(0, 1000)) {
+		t.Fatalf("incorrect size buckets found in the index")
+	}
+	if !found(FieldModTime, ModTimeRanges(day-7*secondsPerDay, math.MaxInt64)) || found(FieldModTime, ModTimeRanges(0, day-1)) {
+		t.Fatalf("incorrect modification time buckets found in the index")
+	}
+	if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(FieldType, "application/*")}) {
+		t.Fatalf("major type not found in the index")
+	}
+	if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeFieldTrapdoors(FieldType, "image/*")}) {
+		t.Fatalf("incorrect major type found in the index")
+	}
+	if trapdoors := sib.ComputeFieldTrapdoors(FieldName, "report"); trapdoors != nil {
+		t.Fatalf("field trapdoors computed without indexed paths")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The versions of the normalization schemes turning the tokens into keywords.
+// The indexes built under different versions have different keywords for the
+// same words, so the version is recorded along with each index.  The folding
+// schemes depend on the Unicode edition of the tables of the `unicode` package
+// and of `golang.org/x/text/unicode/norm`, which are both picked by the Go
+// version, so each edition has its own version.  `CurrentNormalizationVersion`
+// is defined along with `unicodeEdition` for each Go version.
+const (
+	// LegacyNormalizationVersion converts each character to lower case on its
+	// own and keeps only the letters and digits.  The precomposed and
+	// decomposed forms of a character therefore yield different keywords.
+	// All the indexes built before the normalization was versioned use it.
+	LegacyNormalizationVersion = 1
+	// FoldingNormalizationVersion applies the NFKC normalization and case
+	// folding, and keeps the letters, digits and combining marks in NFC,
+	// with the tables of Unicode 15.0.0.
+	FoldingNormalizationVersion = 2
+	// Folding17NormalizationVersion is `FoldingNormalizationVersion` with the
+	// tables of Unicode 17.0.0.
+	Folding17NormalizationVersion = 3
+)
+
+// Normalization is a versioned normalization scheme.  The folding schemes are
+// always computed with the tables of `unicodeEdition`, so the keywords of the
+// other folding versions can differ for the few characters added or changed
+// since their edition.
+type Normalization struct {
+	Version         int  // The version of the scheme.
+	StripDiacritics bool // Whether the diacritics are removed, so that "café" matches "cafe".  Ignored by the legacy version.
+}
+
+// The normalization schemes without diacritic stripping.
+var (
+	LegacyNormalization  = Normalization{Version: LegacyNormalizationVersion}
+	CurrentNormalization = Normalization{Version: CurrentNormalizationVersion}
+)
+
+// fullCaseFoldings are the characters whose full case folding is not given by
+// `unicode.ToLower(unicode.ToUpper(c))`.  The dotted and dotless i of Turkish
+// are both folded to "i", so that the folding does not depend on the locale.
+var fullCaseFoldings = map[rune]string{
+	'ß': "ss",
+	'ẞ': "ss",
+	'İ': "i",
+	'ı': "i",
+	'ŉ': "ʼn",
+}
+
+// foldCase returns the case folding of `str`, which must be in NFKC.  This is
+// an approximation of the full case folding of CaseFolding.txt: the simple
+// foldings are taken as `unicode.ToLower(unicode.ToUpper(c))`, which differs
+// for a few characters such as the Cherokee lower case letters, and only the
+// full foldings of `fullCaseFoldings` are applied.
+func foldCase(str string) string {
+	folded := make([]rune, 0, len(str))
+	for _, c := range str {
+		if s, ok := fullCaseFoldings[c]; ok {
+			folded = append(folded, []rune(s)...)
+		} else {
+			folded = append(folded, unicode.ToLower(unicode.ToUpper(c)))
+		}
+	}
+	return string(folded)
+}
+
+// normalize maps `token` to its keyword under the scheme `n`, keeping the
+// characters for which `keep` returns true in addition to the letters and
+// digits.  `keep` can be nil.
+func (n Normalization) normalize(token string, keep func(rune) bool) string {
+	if n.Version == LegacyNormalizationVersion {
+		normalized := make([]rune, 0, len(token))
+		for _, c := range token {
+			if isAlphanumeric(c) || (keep != nil && keep(c)) {
+				normalized = append(normalized, unicode.ToLower(c))
+			}
+		}
+		return string(normalized)
+	}
+
+	// The iota subscript is only folded once decomposed from its vowel.
+	decomposed := norm.NFD.String(foldCase(norm.NFKC.String(token)))
+	normalized := make([]rune, 0, len(decomposed))
+	for _, c := range decomposed {
+		if c == '\u0345' {
+			c = 'ι'
+		}
+		if n.StripDiacritics && unicode.Is(unicode.Mn, c) {
+			continue
+		}
+		if isAlphanumeric(c) || unicode.IsMark(c) || (keep != nil && keep(c)) {
+			normalized = append(normalized, c)
+		}
+	}
+	return norm.NFC.String(string(normalized))
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"testing"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// testNormalizeHelper checks that `normalization` maps `token` to `expected`,
+// and that normalizing the result again leaves it unchanged.
+func testNormalizeHelper(t *testing.T, normalization Normalization, token, expected string) {
+	actual := KeywordNormalizer(token, normalization)
+	if actual != expected {
+		t.Fatalf("incorrect normalization of \"%s\" under %+v: expected \"%s\" actual \"%s\"", token, normalization, expected, actual)
+	}
+	if again := KeywordNormalizer(actual, normalization); again != actual {
+		t.Fatalf("keyword \"%s\" not stable under %+v: %s", actual, normalization, again)
+	}
+}
+
+// TestNormalization tests the normalization schemes.  Checks that the
+// equivalent forms of a word are only normalized to the same keyword from
+// `FoldingNormalizationVersion` on, and that the diacritics are only removed
+// when requested.
+func TestNormalization(t *testing.T) {
+	stripped := Normalization{Version: FoldingNormalizationVersion, StripDiacritics: true}
+
+	// The precomposed and decomposed forms of "café".
+	testNormalizeHelper(t, LegacyNormalization, "café", "café")
+	testNormalizeHelper(t, LegacyNormalization, "café", "cafe")
+	testNormalizeHelper(t, CurrentNormalization, "café", "café")
+	testNormalizeHelper(t, CurrentNormalization, "CAFÉ", "café")
+	testNormalizeHelper(t, stripped, "Café!", "cafe")
+	testNormalizeHelper(t, stripped, "café", "cafe")
+
+	// Full case folding.
+	testNormalizeHelper(t, LegacyNormalization, "Straße", "straße")
+	testNormalizeHelper(t, CurrentNormalization, "Straße", "strasse")
+	testNormalizeHelper(t, CurrentNormalization, "STRASSE", "strasse")
+	testNormalizeHelper(t, CurrentNormalization, "İSTANBUL", "istanbul")
+	testNormalizeHelper(t, CurrentNormalization, "ılık", "ilik")
+	testNormalizeHelper(t, CurrentNormalization, "ΣΊΣΥΦΟΣ", "σίσυφοσ")
+	testNormalizeHelper(t, CurrentNormalization, "Σίσυφος", "σίσυφοσ")
+	testNormalizeHelper(t, CurrentNormalization, "ᾼ", "αι")
+	testNormalizeHelper(t, stripped, "Ἄθηνᾶ", "αθηνα")
+
+	// The compatibility characters.
+	testNormalizeHelper(t, CurrentNormalization, "ﬁne", "fine")
+	testNormalizeHelper(t, CurrentNormalization, "ＳＨＡ-２５６", "sha256")
+
+	// The combining marks of other scripts are kept unless stripped.
+	testNormalizeHelper(t, LegacyNormalization, "नमस्ते", "नमसत")
+	testNormalizeHelper(t, CurrentNormalization, "नमस्ते", "नमस्ते")
+	testNormalizeHelper(t, CurrentNormalization, "苟利国家生死以！", "苟利国家生死以")
+	testNormalizeHelper(t, CurrentNormalization, ".,;'[]'", "")
+}
+
+// TestUnicodeEdition tests the `unicodeEdition` constant.  Checks that the
+// `unicode` package and `golang.org/x/text/unicode/norm` use the tables of the
+// Unicode edition recorded by `CurrentNormalizationVersion`.
+func TestUnicodeEdition(t *testing.T) {
+	if unicode.Version != unicodeEdition || norm.Version != unicodeEdition {
+		t.Fatalf("incorrect Unicode edition: expected %s actual %s and %s", unicodeEdition, unicode.Version, norm.Version)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build go1.27
+// +build go1.27
+
+package libsearch
+
+// unicodeEdition is the Unicode edition of the tables of the `unicode` package
+// and of `golang.org/x/text/unicode/norm` from Go 1.27 on.
+const unicodeEdition = "17.0.0"
+
+// CurrentNormalizationVersion is the version the new indexes are built with,
+// the folding scheme with the tables of `unicodeEdition`.
+const CurrentNormalizationVersion = Folding17NormalizationVersion
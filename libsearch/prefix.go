@@ -0,0 +1,113 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"hash"
+	"unicode/utf8"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// When the prefix search is enabled for a TLF, the index of a document also
+// contains the codewords of the prefixes of its keywords, up to the maximum
+// prefix length in characters.  The codewords are derived from the prefix
+// trapdoors with a domain separation, so that a prefix is never confused with
+// a keyword of the same value.  A longer prefix is searched for by its
+// truncation to the maximum length, and the results need to be verified.
+
+// prefixTrapdoor derives the trapdoor of a prefix from one of the trapdoors of
+// the same string as a keyword.
+func prefixTrapdoor(h func() hash.Hash, trapdoor []byte) []byte {
+	mac := hmac.New(h, trapdoor)
+	mac.Write([]byte("kbfs_search_prefix"))
+	return mac.Sum(nil)
+}
+
+// truncatePrefix returns the first `maxLen` characters of `prefix`.
+func truncatePrefix(prefix string, maxLen int) string {
+	numChars := 0
+	for i := range prefix {
+		if numChars == maxLen {
+			return prefix[:i]
+		}
+		numChars++
+	}
+	return prefix
+}
+
+// MaxPrefixLength returns the maximum length in characters of the prefixes
+// indexed by the builder, or 0 if the prefixes are not indexed.
+func (sib *SecureIndexBuilder) MaxPrefixLength() int {
+	return sib.maxPrefixLen
+}
+
+// computePrefixTrapdoors computes the trapdoor values for the normalized
+// `prefix`, which must be at most `sib.maxPrefixLen` characters long.
+func (sib *SecureIndexBuilder) computePrefixTrapdoors(prefix string) [][]byte {
+	trapdoors := sib.trapdoorFunc(prefix)
+	for i, trapdoor := range trapdoors {
+		trapdoors[i] = prefixTrapdoor(sib.hash, trapdoor)
+	}
+	return trapdoors
+}
+
+// ComputePrefixTrapdoors computes the trapdoor values matching the documents
+// with a keyword starting with `prefix`, after normalizing it with the
+// analyzer of the builder.  The prefix is truncated to the maximum prefix
+// length, so a longer prefix also matches the keywords sharing only its
+// truncation.  Returns nil if the prefixes are not indexed.
+func (sib *SecureIndexBuilder) ComputePrefixTrapdoors(prefix string) [][]byte {
+	if sib.maxPrefixLen == 0 {
+		return nil
+	}
+	return sib.computePrefixTrapdoors(truncatePrefix(sib.analyzer.Normalize(prefix), sib.maxPrefixLen))
+}
+
+// insertPrefixes inserts the codewords of the prefixes of `word` into the
+// bloom filter `bf` of an index with `nonce`, skipping the prefixes already in
+// `inserted`.  Returns the number of prefixes inserted.
+func (sib *SecureIndexBuilder) insertPrefixes(bf bitarray.BitArray, nonce uint64, word string, inserted map[string]bool) int64 {
+	var numInserted int64
+	numChars := 0
+	for i := range word {
+		if numChars == sib.maxPrefixLen {
+			return numInserted
+		}
+		numChars++
+		_, size := utf8.DecodeRuneInString(word[i:])
+		prefix := word[:i+size]
+		if inserted[prefix] {
+			continue
+		}
+		inserted[prefix] = true
+		for _, trapdoor := range sib.computePrefixTrapdoors(prefix) {
+			bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
+		}
+		numInserted++
+	}
+	return numInserted
+}
+
+// maxInsertions returns the number of words, prefixes, trigrams and biwords
+// up to which the index of a document with an *encrypted* length of
+// `paddedLen` is blinded.  A document has fewer than `paddedLen` unique words,
+// and both the unique prefixes and the unique trigrams of the words are no
+// more than the characters of the words themselves.  The unique biwords are
+// no more than the keywords read from the document.
+func (sib *SecureIndexBuilder) maxInsertions(paddedLen int64) int64 {
+	maxInsertions := paddedLen
+	if sib.maxPrefixLen > 0 {
+		maxInsertions += paddedLen
+	}
+	if sib.Fuzzy() {
+		maxInsertions += paddedLen
+	}
+	if sib.biwords {
+		maxInsertions += paddedLen
+	}
+	return maxInsertions
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestTruncatePrefix tests the `truncatePrefix` function.  Checks that the
+// prefixes are truncated by characters rather than bytes.
+func TestTruncatePrefix(t *testing.T) {
+	testCases := []struct {
+		prefix   string
+		maxLen   int
+		expected string
+	}{
+		{"config", 4, "conf"},
+		{"con", 4, "con"},
+		{"", 4, ""},
+		{"日本語です", 2, "日本"},
+		{"straße", 5, "straß"},
+	}
+	for _, testCase := range testCases {
+		if actual := truncatePrefix(testCase.prefix, testCase.maxLen); actual != testCase.expected {
+			t.Fatalf("incorrect truncation of \"%s\" to %d: expected \"%s\" actual \"%s\"", testCase.prefix, testCase.maxLen, testCase.expected, actual)
+		}
+	}
+}
+
+// TestPrefixSearch tests the prefix trapdoors.  Checks that the prefixes of
+// the words are found in an index built with prefixes, including the prefixes
+// longer than the maximum length, and that a prefix is not confused with a
+// word of the same value.
+func TestPrefixSearch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{MaxPrefixLen: 4})
+	if sib.MaxPrefixLength() != 4 {
+		t.Fatalf("incorrect maximum prefix length: %d", sib.MaxPrefixLength())
+	}
+	secIndex := buildTestSecureIndex(t, sib, "Configuration of the file system")
+
+	for _, prefix := range []string{"c", "CONF", "configure", "the", "fi", "file", "of"} {
+		if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputePrefixTrapdoors(prefix)}) {
+			t.Fatalf("prefix \"%s\" not found in the index", prefix)
+		}
+	}
+	for _, prefix := range []string{"x", "fix", "sister"} {
+		if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputePrefixTrapdoors(prefix)}) {
+			t.Fatalf("prefix \"%s\" found in the index", prefix)
+		}
+	}
+	if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("conf")}) {
+		t.Fatalf("prefix found as a word in the index")
+	}
+
+	sib = CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	if trapdoors := sib.ComputePrefixTrapdoors("conf"); trapdoors != nil {
+		t.Fatalf("prefix trapdoors computed without indexed prefixes")
+	}
+}
@@ -5,16 +5,15 @@
 package libsearch
 
 import (
-	"bufio"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"hash"
-	"math/big"
-	"os"
+	"io"
 
 	"github.com/jxguan/go-datastructures/bitarray"
+	sserver1 "github.com/keybase/search/protocol/sserver"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -30,12 +29,42 @@ type SecureIndexBuilder struct {
 	hash         func() hash.Hash      // The hash function to be used for HMAC.
 	trapdoorFunc func(string) [][]byte // The trapdoor function for the words
 	size         uint64                // The size of each index, i.e. the number of buckets in the bloom filter.  Smaller size will lead to higher false positive rates.
+	analyzer     Analyzer              // The analyzer turning the words into keywords.
+	maxPrefixLen int                   // The maximum length in characters of the indexed prefixes of the keywords, or 0 if the prefixes are not indexed.
+	trigramKeys  [][]byte              // The keys for the PRFs of the trigrams.  Derived from `keys`, or nil if the trigrams are not indexed.
+	biwords      bool                  // Whether the pairs of adjacent keywords are indexed.
+	paths        bool                  // Whether the words of the pathnames are indexed in their fields.
+	metadata     bool                  // Whether the buckets of the metadata are indexed in their fields.
+}
+
+// IndexOptions selects what a `SecureIndexBuilder` indexes besides the
+// keywords of the documents.  The zero value only indexes the keywords.
+type IndexOptions struct {
+	MaxPrefixLen int  // The maximum length in characters of the prefixes of the keywords indexed for the prefix searches, or 0 if the prefixes are not indexed.
+	Fuzzy        bool // Whether the trigrams of the keywords are indexed for the fuzzy searches.
+	Phrases      bool // Whether the pairs of adjacent keywords are indexed for the phrase searches.
+	Paths        bool // Whether the words of the pathnames given to `BuildSecureIndexStreamWithFields` are indexed for the field searches.
+	Metadata     bool // Whether the buckets of the metadata given to `BuildSecureIndexStreamWithFields` are indexed for the metadata searches.
+}
+
+// IndexOptionsFromTlfInfo returns the index options recorded in `tlfInfo`,
+// which all the clients of the TLF build their indexes with.
+func IndexOptionsFromTlfInfo(tlfInfo sserver1.TlfInfo) IndexOptions {
+	return IndexOptions{
+		MaxPrefixLen: tlfInfo.MaxPrefixLen,
+		Fuzzy:        tlfInfo.Fuzzy,
+		Phrases:      tlfInfo.Phrases,
+		Paths:        tlfInfo.Paths,
+		Metadata:     tlfInfo.Metadata,
+	}
 }
 
 // CreateSecureIndexBuilder instantiates a `SecureIndexBuilder`.  Sets up the
 // hash function, and derives the keys from the master secret and salts by using
-// PBKDF2.  Finally, sets up the trapdoor function for the words.
-func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][]byte, size uint64) *SecureIndexBuilder {
+// PBKDF2.  Finally, sets up the trapdoor function for the words.  The documents
+// and the searched words are turned into keywords with `analyzer`, and `opts`
+// selects what else is indexed.
+func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][]byte, size uint64, analyzer Analyzer, opts IndexOptions) *SecureIndexBuilder {
 	sib := new(SecureIndexBuilder)
 	sib.keys = make([][]byte, len(salts))
 	for index, salt := range salts {
@@ -43,6 +72,17 @@ func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][
 	}
 	sib.hash = h
 	sib.size = size
+	sib.analyzer = analyzer
+	sib.maxPrefixLen = opts.MaxPrefixLen
+	sib.biwords = opts.Phrases
+	sib.paths = opts.Paths
+	sib.metadata = opts.Metadata
+	if opts.Fuzzy {
+		sib.trigramKeys = make([][]byte, len(sib.keys))
+		for i, key := range sib.keys {
+			sib.trigramKeys[i] = trigramKey(h, key)
+		}
+	}
 	sib.trapdoorFunc = func(word string) [][]byte {
 		trapdoors := make([][]byte, len(salts))
 		for i := 0; i < len(salts); i++ {
@@ -56,31 +96,40 @@ func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][
 }
 
 // Builds the bloom filter for the document and returns the result in a sparse
-// bit array and the number of unique words in the document.  The result should
-// not be directly used as the index, as obfuscation need to be added to the
-// bloom filter.
-func (sib *SecureIndexBuilder) buildBloomFilter(nonce uint64, document *os.File) (bitarray.BitArray, int64) {
-	scanner := bufio.NewScanner(document)
-	scanner.Split(bufio.ScanWords)
+// bit array and the number of unique words, prefixes, trigrams and biwords
+// inserted.  The result should not be directly used as the index, as
+// obfuscation need to be added to the bloom filter.
+func (sib *SecureIndexBuilder) buildBloomFilter(nonce uint64, document io.Reader) (bitarray.BitArray, int64, error) {
 	bf := bitarray.NewSparseBitArray()
 	words := make(map[string]bool)
-	for scanner.Scan() {
-		word := scanner.Text()
-		word = NormalizeKeyword(word)
-
+	prefixes := make(map[string]bool)
+	trigrams := make(map[string]bool)
+	biwords := make(map[string]bool)
+	var numExtras int64
+	prev := ""
+	err := ScanKeywords(document, sib.analyzer, func(word string) bool {
+		sib.addBiword(biwords, prev, word)
+		prev = word
 		if words[word] {
-			continue
+			return true
 		}
 		words[word] = true
-		trapdoors := sib.trapdoorFunc(word)
-		for _, trapdoor := range trapdoors {
-			mac := hmac.New(sib.hash, trapdoor)
-			mac.Write(big.NewInt(int64(nonce)).Bytes())
-			codeword, _ := binary.Uvarint(mac.Sum(nil))
-			bf.SetBit(codeword % sib.size)
-		}
+		sib.insertWord(bf, nonce, word)
+		numExtras += sib.insertPrefixes(bf, nonce, word, prefixes)
+		numExtras += sib.insertTrigrams(bf, nonce, word, trigrams)
+		return true
+	})
+	numExtras += sib.insertBiwords(bf, nonce, biwords)
+	return bf, int64(len(words)) + numExtras, err
+}
+
+// insertWord inserts the codewords of `word` into the bloom filter `bf` of an
+// index with `nonce`.
+func (sib *SecureIndexBuilder) insertWord(bf bitarray.BitArray, nonce uint64, word string) {
+	trapdoors := sib.trapdoorFunc(word)
+	for _, trapdoor := range trapdoors {
+		bf.SetBit(computeCodeword(sib.hash, trapdoor, nonce, sib.size))
 	}
-	return bf, int64(len(words))
 }
 
 // Blinds the bloom filter by setting random bits to be on for `numIterations`
@@ -110,20 +159,61 @@ func (sib *SecureIndexBuilder) blindBloomFilter(bf bitarray.BitArray, numIterati
 	return nil
 }
 
-// BuildSecureIndex builds the index for `document` and an *encrypted* length of
-// `fileLen`.
-func (sib *SecureIndexBuilder) BuildSecureIndex(document *os.File, fileLen int64) (SecureIndex, error) {
+// BuildSecureIndex builds the index for the content read from `document` and
+// an *encrypted* length of `paddedLen`, which must be at least the length of
+// the content.  Returns an error if the content cannot be read, including when
+// it contains a word longer than `bufio.MaxScanTokenSize`.  Use
+// `BuildSecureIndexStream` for such content.
+func (sib *SecureIndexBuilder) BuildSecureIndex(document io.Reader, paddedLen int64) (SecureIndex, error) {
 	nonce, err := RandUint64()
 	if err != nil {
 		return SecureIndex{}, err
 	}
-	bf, numUniqWords := sib.buildBloomFilter(nonce, document)
-	err = sib.blindBloomFilter(bf, (fileLen-numUniqWords)*int64(len(sib.keys)))
+	bf, numInserted, err := sib.buildBloomFilter(nonce, document)
+	if err != nil {
+		return SecureIndex{}, err
+	}
+	err = sib.blindBloomFilter(bf, (sib.maxInsertions(paddedLen)-numInserted)*int64(len(sib.keys)))
 	return SecureIndex{BloomFilter: bf, Nonce: nonce, Size: sib.size, Hash: sib.hash}, err
 }
 
-// ComputeTrapdoors computes the trapdoor values for `word`.  This acts as the
-// public getter for the trapdoorFunc field of SecureIndexBuilder.
-func (sib *SecureIndexBuilder) ComputeTrapdoors(word string) [][]byte {
-	return sib.trapdoorFunc(NormalizeKeyword(word))
+// BuildSecureIndexStream is the streaming variant of
+// `BuildSecureIndexWithTfSketch` for very large documents.  The content is read
+// with `ScanKeywordsStream`, so the words too long for `BuildSecureIndex` are
+// cut into bounded pieces, each indexed as a word, instead of stopping the
+// reading.
+func (sib *SecureIndexBuilder) BuildSecureIndexStream(document io.Reader, paddedLen int64) (SecureIndex, SecureIndex, error) {
+	return sib.BuildSecureIndexStreamWithFields(document, "", nil, paddedLen)
+}
+
+// BuildSecureIndexStreamWithFields is similar to `BuildSecureIndexStream`, but
+// also indexes the words of the relative `pathname` of the document and the
+// buckets of its `metadata` in their fields if the builder indexes them.
+func (sib *SecureIndexBuilder) BuildSecureIndexStreamWithFields(document io.Reader, pathname string, metadata *Metadata, paddedLen int64) (SecureIndex, SecureIndex, error) {
+	counts, biwords, err := sib.countWords(document, ScanKeywordsStream)
+	if err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+	return sib.buildIndexAndTfSketch(counts, biwords, pathname, metadata, paddedLen)
+}
+
+// ComputeTrapdoors computes the trapdoor values for `keyword`, after
+// normalizing it with the analyzer of the builder.  This acts as the public
+// getter for the trapdoorFunc field of SecureIndexBuilder.  A word with
+// several keywords needs the trapdoors of each of its `Keywords`.
+func (sib *SecureIndexBuilder) ComputeTrapdoors(keyword string) [][]byte {
+	return sib.trapdoorFunc(sib.analyzer.Normalize(keyword))
+}
+
+// ComputeKeywordTrapdoors computes the trapdoor values for `keyword` as it is,
+// without normalizing it first.  Used for the keywords that have already been
+// normalized, possibly under an older normalization scheme than the one of the
+// analyzer of the builder.
+func (sib *SecureIndexBuilder) ComputeKeywordTrapdoors(keyword string) [][]byte {
+	return sib.trapdoorFunc(keyword)
+}
+
+// Analyzer returns the analyzer of the builder.
+func (sib *SecureIndexBuilder) Analyzer() Analyzer {
+	return sib.analyzer
 }
@@ -5,6 +5,7 @@
 package libsearch
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
@@ -12,9 +13,11 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/jxguan/go-datastructures/bitarray"
+	sserver1 "github.com/keybase/search/protocol/sserver"
 )
 
 // Tests the constructor for `SecureIndexBuilder`.  Makes sure that all the
@@ -27,8 +30,8 @@ func TestCreateSecureIndexBuilder(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error in generating the salts")
 	}
-	sib1 := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
-	sib2 := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	sib1 := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size, ProseAnalyzer, IndexOptions{})
+	sib2 := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size, ProseAnalyzer, IndexOptions{})
 	if sib1.hash == nil || sib2.hash == nil {
 		t.Fatalf("hash function is not set correctly")
 	}
@@ -61,6 +64,26 @@ func TestCreateSecureIndexBuilder(t *testing.T) {
 	}
 }
 
+// Tests the `IndexOptionsFromTlfInfo` function.  Checks that the options are
+// those recorded in the TLF information, and that the builder picks them up.
+func TestIndexOptionsFromTlfInfo(t *testing.T) {
+	tlfInfo := sserver1.TlfInfo{MaxPrefixLen: 3, Fuzzy: true, Phrases: true, Paths: false, Metadata: true}
+	opts := IndexOptionsFromTlfInfo(tlfInfo)
+	expected := IndexOptions{MaxPrefixLen: 3, Fuzzy: true, Phrases: true, Paths: false, Metadata: true}
+	if opts != expected {
+		t.Fatalf("incorrect index options: expected %+v actual %+v", expected, opts)
+	}
+
+	salts, err := GenerateSalts(4, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(100000), ProseAnalyzer, opts)
+	if sib.maxPrefixLen != 3 || sib.trigramKeys == nil || !sib.biwords || sib.paths || !sib.metadata {
+		t.Fatalf("index options not set up correctly")
+	}
+}
+
 // Helper function that checks if a word is contained in the bloom filter.
 func bfContainsWord(bf bitarray.BitArray, sib *SecureIndexBuilder, nonce uint64, word string) bool {
 	trapdoors := sib.trapdoorFunc(word)
@@ -87,7 +110,7 @@ func TestBuildBloomFilter(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error in generating the salts")
 	}
-	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size, ProseAnalyzer, IndexOptions{})
 	doc, err := ioutil.TempFile("", "bfTest")
 	docContent := "This is a TOP-NOTCH test file."
 	docWords := []string{"this", "is", "a", "topnotch", "test", "file"}
@@ -103,17 +126,26 @@ func TestBuildBloomFilter(t *testing.T) {
 	if _, err := doc.Seek(0, 0); err != nil {
 		t.Errorf("cannot rewind the temporary test file for `TestBuildBloomFilter")
 	}
-	bf1, count := sib.buildBloomFilter(nonce, doc)
+	bf1, count, err := sib.buildBloomFilter(nonce, doc)
+	if err != nil {
+		t.Fatalf("error when building the bloom filter: %s", err)
+	}
 	// Rewinds the file again
 	if _, err := doc.Seek(0, 0); err != nil {
 		t.Errorf("cannot rewind the temporary test file for `TestBuildBloomFilter")
 	}
-	bf2, _ := sib.buildBloomFilter(nonce, doc)
+	bf2, _, err := sib.buildBloomFilter(nonce, doc)
+	if err != nil {
+		t.Fatalf("error when building the bloom filter: %s", err)
+	}
 	// Rewinds the file yet again
 	if _, err := doc.Seek(0, 0); err != nil {
 		t.Errorf("cannot rewind the temporary test file for `TestBuildBloomFilter")
 	}
-	bf3, _ := sib.buildBloomFilter(nonce+1, doc)
+	bf3, _, err := sib.buildBloomFilter(nonce+1, doc)
+	if err != nil {
+		t.Fatalf("error when building the bloom filter: %s", err)
+	}
 	if !bf1.Equals(bf2) {
 		t.Fatalf("the two bloom filters are different.  `buildBloomFilter` is likely non-deterministic")
 	}
@@ -140,7 +172,7 @@ func TestBlindBloomFilter(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error in generating the salts")
 	}
-	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size, ProseAnalyzer, IndexOptions{})
 	bf := bitarray.NewSparseBitArray()
 	err = sib.blindBloomFilter(bf, 1000000)
 	if err != nil {
@@ -164,7 +196,7 @@ func TestBuildSecureIndex(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error in generating the salts")
 	}
-	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size, ProseAnalyzer, IndexOptions{})
 	doc, err := ioutil.TempFile("", "indexTest")
 	docContent := "This is a TOP-NOTCH test file."
 	docWords := []string{"this", "is", "a", "topnotch", "test", "file"}
@@ -207,3 +239,33 @@ func TestBuildSecureIndex(t *testing.T) {
 		}
 	}
 }
+
+// TestBuildSecureIndexStream tests the `BuildSecureIndexStream` function.
+// Checks that an in-memory document with a word too long for
+// `BuildSecureIndexWithTfSketch` is fully indexed, the long word being cut into
+// pieces.
+func TestBuildSecureIndexStream(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	docContent := "before " + strings.Repeat("x", bufio.MaxScanTokenSize) + " after after"
+
+	if _, _, err := sib.BuildSecureIndexWithTfSketch(strings.NewReader(docContent), int64(len(docContent))); err == nil {
+		t.Fatalf("no error returned for a word too long")
+	}
+	secIndex, tfSketch, err := sib.BuildSecureIndexStream(strings.NewReader(docContent), int64(len(docContent)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	for word, level := range map[string]int{"before": 0, "after": 1, "x": 0} {
+		trapdoor := sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors(word)}
+		if !SearchSecureIndex(secIndex, trapdoor) {
+			t.Fatalf("word \"%s\" cannot be found in the index", word)
+		}
+		if actual := SearchTfSketch(tfSketch, trapdoor); actual != level {
+			t.Fatalf("incorrect level for \"%s\": expected %d actual %d", word, level, actual)
+		}
+	}
+}
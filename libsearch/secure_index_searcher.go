@@ -0,0 +1,107 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// computeCodeword computes the position in a bloom filter of `size` buckets
+// that `trapdoor` maps to for an index with `nonce`.  This is shared by the
+// index builder and the searcher so that both always agree on the hashing.
+func computeCodeword(h func() hash.Hash, trapdoor []byte, nonce uint64, size uint64) uint64 {
+	mac := hmac.New(h, trapdoor)
+	mac.Write(big.NewInt(int64(nonce)).Bytes())
+	// Ignore the error as we need to truncate the 256-bit hash into 64 bits
+	codeword, _ := binary.Uvarint(mac.Sum(nil))
+	return codeword % size
+}
+
+// SearchSecureIndex searches the index `secIndex` for a word with `trapdoor`,
+// and returns true if the word has been found, and false otherwise.  An index
+// without any bucket contains no word.
+// NOTE: False positives are possible.
+func SearchSecureIndex(secIndex SecureIndex, trapdoor sserver1.Trapdoor) bool {
+	if secIndex.Size == 0 {
+		return false
+	}
+	for _, codeword := range trapdoor.Codeword {
+		if found, _ := secIndex.BloomFilter.GetBit(computeCodeword(secIndex.Hash, codeword, secIndex.Nonce, secIndex.Size)); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchSecureIndexes searches each of the `secIndexes` for a word with
+// `trapdoor`, and returns the positions in `secIndexes` of the indexes possibly
+// containing the word in increasing order.  All the indexes must have been
+// built with the key generation that `trapdoor` is computed for.
+// NOTE: False positives are possible.
+func SearchSecureIndexes(secIndexes []SecureIndex, trapdoor sserver1.Trapdoor) []int {
+	var matches []int
+	for i, secIndex := range secIndexes {
+		if SearchSecureIndex(secIndex, trapdoor) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// EvaluateQuery evaluates the boolean `query` in postfix notation, calling
+// `match` to find out whether the term at a given position is matched.
+// Returns an error if `query` is malformed or refers to a term outside of
+// [0, numTerms).
+func EvaluateQuery(query []sserver1.QueryToken, numTerms int, match func(term int) bool) (bool, error) {
+	stack := make([]bool, 0, len(query))
+	for _, token := range query {
+		switch token.Op {
+		case sserver1.QueryOp_TERM:
+			if token.Term < 0 || token.Term >= numTerms {
+				return false, errors.New("query term out of range")
+			}
+			stack = append(stack, match(token.Term))
+		case sserver1.QueryOp_NOT:
+			if len(stack) < 1 {
+				return false, errors.New("malformed query")
+			}
+			stack[len(stack)-1] = !stack[len(stack)-1]
+		case sserver1.QueryOp_AND, sserver1.QueryOp_OR:
+			if len(stack) < 2 {
+				return false, errors.New("malformed query")
+			}
+			lhs, rhs := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if token.Op == sserver1.QueryOp_AND {
+				stack[len(stack)-1] = lhs && rhs
+			} else {
+				stack[len(stack)-1] = lhs || rhs
+			}
+		default:
+			return false, errors.New("invalid query operator")
+		}
+	}
+	if len(stack) != 1 {
+		return false, errors.New("malformed query")
+	}
+	return stack[0], nil
+}
+
+// SearchSecureIndexQuery evaluates the boolean `query` against the index
+// `secIndex`, where `trapdoors` holds the trapdoor of each term in the query.
+// NOTE: False positives are possible.  As a term under a NOT operator may
+// itself be a false positive, false negatives are possible for queries using
+// NOT, with a probability no greater than the false positive rate.
+func SearchSecureIndexQuery(secIndex SecureIndex, query []sserver1.QueryToken, trapdoors []sserver1.Trapdoor) (bool, error) {
+	return EvaluateQuery(query, len(trapdoors), func(term int) bool {
+		return SearchSecureIndex(secIndex, trapdoors[term])
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// buildTestSecureIndex builds a secure index for `content` with `sib`.
+func buildTestSecureIndex(t *testing.T, sib *SecureIndexBuilder, content string) SecureIndex {
+	doc, err := ioutil.TempFile("", "indexTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file: %s", err)
+	}
+	defer os.Remove(doc.Name()) // clean up
+	defer doc.Close()
+	if _, err := doc.Write([]byte(content)); err != nil {
+		t.Fatalf("cannot write to the temporary test file: %s", err)
+	}
+	// Rewinds the file
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file: %s", err)
+	}
+	secIndex, err := sib.BuildSecureIndex(doc, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	return secIndex
+}
+
+// Tests the `SearchSecureIndex` function.  Checks that searching for words
+// within the document returns true, and words that are not in the document
+// yields false (with high probability), and that an index without any bucket
+// contains no word.
+func TestSearchSecureIndex(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	docContent := "This is a test file. It has a pretty random content."
+	secIndex := buildTestSecureIndex(t, sib, docContent)
+
+	for _, word := range strings.Split(docContent, " ") {
+		if !SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors(word)}) {
+			t.Fatalf("one or more words cannot be found in the index")
+		}
+	}
+
+	numFound := 0
+	for i := 0; i < 10000; i++ {
+		if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("nonDocWord" + strconv.Itoa(i))}) {
+			numFound++
+		}
+	}
+	if numFound > 1 {
+		t.Fatalf("multiple false positives reported")
+	}
+
+	secIndex.Size = 0
+	if SearchSecureIndex(secIndex, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("test")}) {
+		t.Fatalf("word found in an index without any bucket")
+	}
+}
+
+// Tests the `SearchSecureIndexes` function.  Checks that the positions of the
+// matching indexes are returned in increasing order.
+func TestSearchSecureIndexes(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	secIndexes := []SecureIndex{
+		buildTestSecureIndex(t, sib, "the first file"),
+		buildTestSecureIndex(t, sib, "the second file"),
+		buildTestSecureIndex(t, sib, "the third file"),
+		buildTestSecureIndex(t, sib, "the second-to-last file"),
+	}
+
+	if matches := SearchSecureIndexes(secIndexes, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("file")}); !reflect.DeepEqual(matches, []int{0, 1, 2, 3}) {
+		t.Fatalf("incorrect matches for \"file\": %v", matches)
+	}
+	if matches := SearchSecureIndexes(secIndexes, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("third")}); !reflect.DeepEqual(matches, []int{2}) {
+		t.Fatalf("incorrect matches for \"third\": %v", matches)
+	}
+	if matches := SearchSecureIndexes(secIndexes, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("missing")}); len(matches) != 0 {
+		t.Fatalf("incorrect matches for \"missing\": %v", matches)
+	}
+}
+
+// Tests the `EvaluateQuery` function.  Checks that postfix queries are
+// correctly evaluated and that malformed queries are rejected.
+func TestEvaluateQuery(t *testing.T) {
+	term := func(i int) sserver1.QueryToken { return sserver1.QueryToken{Op: sserver1.QueryOp_TERM, Term: i} }
+	op := func(op sserver1.QueryOp) sserver1.QueryToken { return sserver1.QueryToken{Op: op} }
+	values := []bool{true, false, true}
+	match := func(i int) bool { return values[i] }
+
+	testCases := []struct {
+		query    []sserver1.QueryToken
+		expected bool
+		isError  bool
+	}{
+		{[]sserver1.QueryToken{term(0)}, true, false},
+		{[]sserver1.QueryToken{term(0), term(1), op(sserver1.QueryOp_AND)}, false, false},
+		{[]sserver1.QueryToken{term(0), term(1), op(sserver1.QueryOp_OR)}, true, false},
+		{[]sserver1.QueryToken{term(1), op(sserver1.QueryOp_NOT), term(2), op(sserver1.QueryOp_AND)}, true, false},
+		{[]sserver1.QueryToken{term(0), term(1), term(2), op(sserver1.QueryOp_OR), op(sserver1.QueryOp_AND), op(sserver1.QueryOp_NOT)}, false, false},
+		{[]sserver1.QueryToken{}, false, true},
+		{[]sserver1.QueryToken{term(3)}, false, true},
+		{[]sserver1.QueryToken{term(0), op(sserver1.QueryOp_AND)}, false, true},
+		{[]sserver1.QueryToken{op(sserver1.QueryOp_NOT)}, false, true},
+		{[]sserver1.QueryToken{term(0), term(1)}, false, true},
+		{[]sserver1.QueryToken{term(0), op(sserver1.QueryOp(42))}, false, true},
+	}
+	for i, testCase := range testCases {
+		actual, err := EvaluateQuery(testCase.query, len(values), match)
+		if testCase.isError {
+			if err == nil {
+				t.Fatalf("expecting error for test case %d, no error returned", i)
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error for test case %d: %s", i, err)
+		} else if actual != testCase.expected {
+			t.Fatalf("incorrect result for test case %d: expected %t actual %t", i, testCase.expected, actual)
+		}
+	}
+}
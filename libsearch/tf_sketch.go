@@ -0,0 +1,159 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"hash"
+	"io"
+	"strconv"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// NumTfLevels is the number of term frequency levels distinguished by a term
+// frequency sketch.  A word occurring `count` times in a document is at level
+// `floor(log2(count))`, capped at `NumTfLevels-1`.
+const NumTfLevels = 8
+
+// A term frequency sketch is a blinded bloom filter stored in the same format
+// as a `SecureIndex`.  For a word at level `l`, the sketch contains the
+// codewords of the word for every level in [1, l].  The codewords are derived
+// from the word trapdoors with a domain separation, so the server can only
+// learn the coarse frequency of the words it has been given the trapdoors of.
+
+// tfLevelTrapdoor derives the trapdoor for `level` of a word from one of the
+// trapdoors of the word.
+func tfLevelTrapdoor(h func() hash.Hash, trapdoor []byte, level int) []byte {
+	mac := hmac.New(h, trapdoor)
+	mac.Write([]byte("kbfs_search_tf_level" + strconv.Itoa(level)))
+	return mac.Sum(nil)
+}
+
+// getTfLevel returns the term frequency level of a word occurring `count`
+// times in a document.
+func getTfLevel(count int) int {
+	level := 0
+	for level+1 < NumTfLevels && count >= 1<<uint(level+1) {
+		level++
+	}
+	return level
+}
+
+// countWords returns the number of occurrences of each keyword in `document`,
+// reading it with `scan` and the analyzer of the builder, along with the set
+// of its biwords, which is empty if the biwords are not indexed.
+func (sib *SecureIndexBuilder) countWords(document io.Reader, scan func(io.Reader, Analyzer, func(string) bool) error) (map[string]int, map[string]bool, error) {
+	counts := make(map[string]int)
+	biwords := make(map[string]bool)
+	prev := ""
+	err := scan(document, sib.analyzer, func(word string) bool {
+		counts[word]++
+		sib.addBiword(biwords, prev, word)
+		prev = word
+		return true
+	})
+	return counts, biwords, err
+}
+
+// buildTfSketch builds the term frequency sketch for the words with `counts`
+// and returns the result in a sparse bit array and the number of level
+// codewords inserted for each key.  As with `buildBloomFilter`, the result
+// needs to be blinded before being used.
+func (sib *SecureIndexBuilder) buildTfSketch(nonce uint64, counts map[string]int) (bitarray.BitArray, int64) {
+	sketch := bitarray.NewSparseBitArray()
+	var numInserted int64
+	for word, count := range counts {
+		level := getTfLevel(count)
+		if level == 0 {
+			continue
+		}
+		trapdoors := sib.trapdoorFunc(word)
+		for l := 1; l <= level; l++ {
+			for _, trapdoor := range trapdoors {
+				sketch.SetBit(computeCodeword(sib.hash, tfLevelTrapdoor(sib.hash, trapdoor, l), nonce, sib.size))
+			}
+			numInserted++
+		}
+	}
+	return sketch, numInserted
+}
+
+// BuildSecureIndexWithTfSketch builds the index for the content read from
+// `document` and an *encrypted* length of `paddedLen`, as well as its term
+// frequency sketch.  The document is only read once.  As with
+// `BuildSecureIndex`, an error is returned if the content contains a word
+// longer than `bufio.MaxScanTokenSize`.
+func (sib *SecureIndexBuilder) BuildSecureIndexWithTfSketch(document io.Reader, paddedLen int64) (SecureIndex, SecureIndex, error) {
+	counts, biwords, err := sib.countWords(document, ScanKeywords)
+	if err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+	return sib.buildIndexAndTfSketch(counts, biwords, "", nil, paddedLen)
+}
+
+// buildIndexAndTfSketch builds the index and the term frequency sketch for a
+// document of the words with `counts`, the `biwords`, the relative
+// `pathname`, which is empty if unknown, the `metadata`, which is nil if
+// unknown, and an *encrypted* length of `paddedLen`.
+func (sib *SecureIndexBuilder) buildIndexAndTfSketch(counts map[string]int, biwords map[string]bool, pathname string, metadata *Metadata, paddedLen int64) (SecureIndex, SecureIndex, error) {
+	nonce, err := RandUint64()
+	if err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+	sketchNonce, err := RandUint64()
+	if err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+
+	bf := bitarray.NewSparseBitArray()
+	prefixes := make(map[string]bool)
+	trigrams := make(map[string]bool)
+	numInserted := int64(len(counts))
+	for word := range counts {
+		sib.insertWord(bf, nonce, word)
+		numInserted += sib.insertPrefixes(bf, nonce, word, prefixes)
+		numInserted += sib.insertTrigrams(bf, nonce, word, trigrams)
+	}
+	numInserted += sib.insertBiwords(bf, nonce, biwords)
+	numInserted += sib.insertFields(bf, nonce, pathname)
+	numInserted += sib.insertMetadata(bf, nonce, metadata)
+	maxInsertions := sib.maxInsertions(paddedLen) + sib.maxFieldInsertions(pathname)
+	if err := sib.blindBloomFilter(bf, (maxInsertions-numInserted)*int64(len(sib.keys))); err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+
+	// A document of `paddedLen` bytes can have at most `paddedLen/2` level
+	// codewords inserted, as each level of a word requires at least twice as
+	// many occurrences as the previous one.
+	sketch, numLevels := sib.buildTfSketch(sketchNonce, counts)
+	if err := sib.blindBloomFilter(sketch, (paddedLen/2-numLevels)*int64(len(sib.keys))); err != nil {
+		return SecureIndex{}, SecureIndex{}, err
+	}
+
+	return SecureIndex{BloomFilter: bf, Nonce: nonce, Size: sib.size, Hash: sib.hash},
+		SecureIndex{BloomFilter: sketch, Nonce: sketchNonce, Size: sib.size, Hash: sib.hash}, nil
+}
+
+// SearchTfSketch returns the term frequency level in the sketch `tfSketch` of
+// the word with `trapdoor`.  The result is only meaningful if the word has been
+// found in the corresponding index, and is 0 for a sketch without any bucket.
+// NOTE: Overestimations are possible due to false positives.
+func SearchTfSketch(tfSketch SecureIndex, trapdoor sserver1.Trapdoor) int {
+	level := 0
+	if tfSketch.Size == 0 {
+		return level
+	}
+	for l := 1; l < NumTfLevels; l++ {
+		for _, codeword := range trapdoor.Codeword {
+			if found, _ := tfSketch.BloomFilter.GetBit(computeCodeword(tfSketch.Hash, tfLevelTrapdoor(tfSketch.Hash, codeword, l), tfSketch.Nonce, tfSketch.Size)); !found {
+				return level
+			}
+		}
+		level = l
+	}
+	return level
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// Tests the `getTfLevel` function.  Checks that the levels are logarithmic in
+// the counts and capped at `NumTfLevels-1`.
+func TestGetTfLevel(t *testing.T) {
+	testCases := []struct {
+		count    int
+		expected int
+	}{
+		{1, 0}, {2, 1}, {3, 1}, {4, 2}, {7, 2}, {8, 3}, {1 << 20, NumTfLevels - 1},
+	}
+	for _, testCase := range testCases {
+		if actual := getTfLevel(testCase.count); actual != testCase.expected {
+			t.Fatalf("incorrect level for count %d: expected %d actual %d", testCase.count, testCase.expected, actual)
+		}
+	}
+}
+
+// Tests the `BuildSecureIndexWithTfSketch` and `SearchTfSketch` functions.
+// Checks that the index is built as usual, and that the term frequency levels
+// of the words can be recovered from the sketch.
+func TestSearchTfSketch(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000), ProseAnalyzer, IndexOptions{})
+	docContent := "once twice twice four four four four " + strings.Repeat("many ", 40)
+
+	doc, err := ioutil.TempFile("", "tfSketchTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file: %s", err)
+	}
+	defer os.Remove(doc.Name()) // clean up
+	defer doc.Close()
+	if _, err := doc.Write([]byte(docContent)); err != nil {
+		t.Fatalf("cannot write to the temporary test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file: %s", err)
+	}
+	secIndex, tfSketch, err := sib.BuildSecureIndexWithTfSketch(doc, int64(len(docContent)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+
+	testCases := []struct {
+		word  string
+		level int
+	}{
+		{"once", 0}, {"twice", 1}, {"four", 2}, {"many", 5},
+	}
+	for _, testCase := range testCases {
+		trapdoor := sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors(testCase.word)}
+		if !SearchSecureIndex(secIndex, trapdoor) {
+			t.Fatalf("word \"%s\" cannot be found in the index", testCase.word)
+		}
+		if level := SearchTfSketch(tfSketch, trapdoor); level != testCase.level {
+			t.Fatalf("incorrect level for \"%s\": expected %d actual %d", testCase.word, testCase.level, level)
+		}
+	}
+
+	tfSketch.Size = 0
+	if level := SearchTfSketch(tfSketch, sserver1.Trapdoor{Codeword: sib.ComputeTrapdoors("many")}); level != 0 {
+		t.Fatalf("incorrect level in a sketch without any bucket: %d", level)
+	}
+}
@@ -5,18 +5,21 @@
 package libsearch
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"io"
 	"io/ioutil"
 	"math"
 	"math/big"
 	"os"
 	"path/filepath"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/keybase/kbfs/libkbfs"
 	sserver1 "github.com/keybase/search/protocol/sserver"
@@ -145,18 +148,76 @@ func WriteFileAtomic(pathname string, content []byte) error {
 	return err
 }
 
-// NormalizeKeyword normalizes a keyword for adding into the index by converting
-// it to lower case and keeping only the alphanumeric characters.
+// NormalizeKeyword normalizes a keyword under `LegacyNormalization` by
+// converting it to lower case and keeping only the alphanumeric characters.
+// The analyzers use `CurrentNormalization` instead.
 func NormalizeKeyword(keyword string) string {
-	normalizedKeyword := make([]rune, 0, len(keyword))
+	return KeywordNormalizer(keyword, LegacyNormalization)
+}
 
-	for _, c := range keyword {
-		if unicode.IsDigit(c) || unicode.IsLetter(c) {
-			normalizedKeyword = append(normalizedKeyword, unicode.ToLower(c))
+// ScanKeywords reads `document` word by word in the same way as the index
+// builder, and calls `f` with each of the keywords of the words given by
+// `analyzer`.  Scanning stops early if `f` returns false.  Returns any error
+// encountered while reading, including `bufio.ErrTooLong` for a word longer
+// than `bufio.MaxScanTokenSize`.
+func ScanKeywords(document io.Reader, analyzer Analyzer, f func(keyword string) bool) error {
+	scanner := bufio.NewScanner(document)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		for _, keyword := range analyzer.Keywords(scanner.Text()) {
+			if !f(keyword) {
+				return nil
+			}
 		}
 	}
+	return scanner.Err()
+}
 
-	return string(normalizedKeyword)
+// ScanKeywordsStream is the streaming variant of `ScanKeywords`, which has no
+// limit on the length of the words.  The words are split and analyzed in the
+// same way, except that the words of `bufio.MaxScanTokenSize` bytes or longer,
+// such as the lines of minified code, are cut into pieces shorter than that,
+// each analyzed as a word, so that the memory used stays bounded.
+func ScanKeywordsStream(document io.Reader, analyzer Analyzer, f func(keyword string) bool) error {
+	reader := bufio.NewReader(document)
+	word := make([]byte, 0, 64)
+	var runeBuf [utf8.UTFMax]byte
+	// flush calls `f` with the keywords of the word read so far, and returns
+	// false if the scanning stops.
+	flush := func() bool {
+		if len(word) > 0 {
+			for _, keyword := range analyzer.Keywords(string(word)) {
+				if !f(keyword) {
+					return false
+				}
+			}
+		}
+		word = word[:0]
+		return true
+	}
+	for {
+		r, size, err := reader.ReadRune()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF || unicode.IsSpace(r) {
+			if !flush() || err == io.EOF {
+				return nil
+			}
+			continue
+		}
+		if len(word)+size >= bufio.MaxScanTokenSize && !flush() {
+			return nil
+		}
+		if r == utf8.RuneError && size == 1 {
+			// Keeps the invalid byte as it is, as `bufio.ScanWords` does.
+			reader.UnreadRune()
+			b, _ := reader.ReadByte()
+			word = append(word, b)
+			continue
+		}
+		word = append(word, runeBuf[:utf8.EncodeRune(runeBuf[:], r)]...)
+	}
 }
 
 // PathnameKeyType is the type of key used to encrypt the pathnames into
@@ -205,12 +266,21 @@ func DocIDToPathname(docID sserver1.DocumentID, keys []PathnameKeyType) (string,
 	if err != nil {
 		return "", err
 	}
+	if len(docIDRaw) < docIDPrefixLength {
+		return "", errors.New("invalid document ID")
+	}
 
 	var keyGen int64
 	versionBuf := bytes.NewBuffer(docIDRaw[0:docIDVersionLength])
 	if err := binary.Read(versionBuf, binary.LittleEndian, &keyGen); err != nil {
 		return "", err
 	}
+	if keyGen == int64(libkbfs.PublicKeyGen) {
+		keyGen = libkbfs.FirstValidKeyGen
+	}
+	if keyGen < libkbfs.FirstValidKeyGen || keyGen-libkbfs.FirstValidKeyGen >= int64(len(keys)) {
+		return "", errors.New("no key for the key generation of the document ID")
+	}
 	key := keys[keyGen-libkbfs.FirstValidKeyGen]
 	keyBytes := [32]byte(key)
 
@@ -234,6 +304,9 @@ func GetKeyGenFromDocID(docID sserver1.DocumentID) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if len(docIDRaw) < docIDVersionLength {
+		return 0, errors.New("document ID too short")
+	}
 
 	var keyGen int64
 	versionBuf := bytes.NewBuffer(docIDRaw[0:docIDVersionLength])
@@ -5,11 +5,15 @@
 package libsearch
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/keybase/kbfs/libkbfs"
@@ -180,6 +184,56 @@ func TestNormalizeKeyword(t *testing.T) {
 	testNormalizeKeywordHelper(t, "苟利国家生死以！", "苟利国家生死以")
 }
 
+// scanAllKeywords returns all the keywords read from `content` with `scan`.
+func scanAllKeywords(scan func(io.Reader, Analyzer, func(string) bool) error, content string) ([]string, error) {
+	var keywords []string
+	err := scan(strings.NewReader(content), ProseAnalyzer, func(keyword string) bool {
+		keywords = append(keywords, keyword)
+		return true
+	})
+	return keywords, err
+}
+
+// TestScanKeywordsStream tests the `ScanKeywordsStream` function.  Checks that
+// the keywords are the same as those of `ScanKeywords`, except that the words
+// that are too long are cut into pieces instead of stopping the scanning.
+func TestScanKeywordsStream(t *testing.T) {
+	content := "  The quick\tBROWN fox,\n\xffjumps\u00a0over the\u3000lazy 狗 \xfe "
+	expected, err := scanAllKeywords(ScanKeywords, content)
+	if err != nil {
+		t.Fatalf("error when scanning the keywords: %s", err)
+	}
+	actual, err := scanAllKeywords(ScanKeywordsStream, content)
+	if err != nil {
+		t.Fatalf("error when streaming the keywords: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect keywords: expected %q actual %q", expected, actual)
+	}
+
+	content = "before " + strings.Repeat("x", bufio.MaxScanTokenSize) + " after"
+	if _, err := scanAllKeywords(ScanKeywords, content); err != bufio.ErrTooLong {
+		t.Fatalf("incorrect error for a word too long: %v", err)
+	}
+	actual, err = scanAllKeywords(ScanKeywordsStream, content)
+	if err != nil {
+		t.Fatalf("error when streaming the keywords: %s", err)
+	}
+	expected = []string{"before", strings.Repeat("x", bufio.MaxScanTokenSize-1), "x", "after"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect keywords: expected %q actual %q", expected, actual)
+	}
+
+	numScanned := 0
+	ScanKeywordsStream(strings.NewReader("one two three"), ProseAnalyzer, func(string) bool {
+		numScanned++
+		return numScanned < 2
+	})
+	if numScanned != 2 {
+		t.Fatalf("scanning does not stop early: %d keywords scanned", numScanned)
+	}
+}
+
 // TestDocID tests the `PathnameToDocID` and the `DocIDToPathname` functions.
 // Checks that the orginal pathname is retrieved after encrypting and
 // decrypting, and that decrypting with a different key yields an error.
@@ -214,6 +268,17 @@ func TestDocID(t *testing.T) {
 	if err == nil && pathname == pathname2 {
 		t.Fatalf("encrypted pathname decrypted with a different key")
 	}
+
+	docID3, err := PathnameToDocID(3, pathname, key1)
+	if err != nil {
+		t.Fatalf("error when encrypting the pathname: %s", err)
+	}
+	if _, err := DocIDToPathname(docID3, []PathnameKeyType{key1}); err == nil {
+		t.Fatalf("no error returned for a document ID of an unknown key generation")
+	}
+	if _, err := DocIDToPathname("c2hvcnQ", []PathnameKeyType{key1}); err == nil {
+		t.Fatalf("no error returned for a truncated document ID")
+	}
 }
 
 // TestGetKeyGenFromDocID tests the `GetKeyGenFromDocID` function.  Checks that
@@ -240,6 +305,10 @@ func TestGetKeyGenFromDocID(t *testing.T) {
 	if int(expectedKeyGen) != actualKeyGen {
 		t.Fatalf("key generations do not match: expected %d actual %d", expectedKeyGen, actualKeyGen)
 	}
+
+	if _, err := GetKeyGenFromDocID("short"); err == nil {
+		t.Fatalf("no error returned for a truncated document ID")
+	}
 }
 
 // testNextPowerOfTwoHelper checks that `nextPowerOfTwo(n) == expected`.
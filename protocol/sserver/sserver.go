@@ -11,20 +11,86 @@ import (
 type DocumentID string
 type FolderID string
 type TlfInfo struct {
-	Salts [][]byte `codec:"salts" json:"salts"`
-	Size  int64    `codec:"size" json:"size"`
+	Salts           [][]byte `codec:"salts" json:"salts"`
+	Size            int64    `codec:"size" json:"size"`
+	Analyzer        string   `codec:"analyzer" json:"analyzer"`
+	StripDiacritics bool     `codec:"stripDiacritics" json:"stripDiacritics"`
+	MaxPrefixLen    int      `codec:"maxPrefixLen" json:"maxPrefixLen"`
+	Fuzzy           bool     `codec:"fuzzy" json:"fuzzy"`
+	Phrases         bool     `codec:"phrases" json:"phrases"`
+	Paths           bool     `codec:"paths" json:"paths"`
+	Metadata        bool     `codec:"metadata" json:"metadata"`
 }
 
 type Trapdoor struct {
 	Codeword [][]byte `codec:"codeword" json:"codeword"`
 }
 
+type QueryOp int
+
+const (
+	QueryOp_TERM QueryOp = 0
+	QueryOp_AND  QueryOp = 1
+	QueryOp_OR   QueryOp = 2
+	QueryOp_NOT  QueryOp = 3
+)
+
+var QueryOpMap = map[string]QueryOp{
+	"TERM": 0,
+	"AND":  1,
+	"OR":   2,
+	"NOT":  3,
+}
+
+type QueryToken struct {
+	Op   QueryOp `codec:"op" json:"op"`
+	Term int     `codec:"term" json:"term"`
+}
+
+type IndexItem struct {
+	DocID       DocumentID `codec:"docID" json:"docID"`
+	SecureIndex []byte     `codec:"secureIndex" json:"secureIndex"`
+	TfSketch    []byte     `codec:"tfSketch" json:"tfSketch"`
+}
+
+type WriteIndexResult struct {
+	DocID DocumentID `codec:"docID" json:"docID"`
+	Error string     `codec:"error" json:"error"`
+}
+
+type RankedDocument struct {
+	DocID DocumentID `codec:"docID" json:"docID"`
+	Score int        `codec:"score" json:"score"`
+}
+
+type RankedSearchResult struct {
+	Documents      []RankedDocument `codec:"documents" json:"documents"`
+	DocFrequencies []int            `codec:"docFrequencies" json:"docFrequencies"`
+	NumDocuments   int              `codec:"numDocuments" json:"numDocuments"`
+}
+
+type DocumentList struct {
+	Documents  []DocumentID `codec:"documents" json:"documents"`
+	NextCursor DocumentID   `codec:"nextCursor" json:"nextCursor"`
+}
+
 type WriteIndexArg struct {
 	TlfID       FolderID   `codec:"tlfID" json:"tlfID"`
 	SecureIndex []byte     `codec:"secureIndex" json:"secureIndex"`
 	DocID       DocumentID `codec:"docID" json:"docID"`
 }
 
+type WriteTfSketchArg struct {
+	TlfID    FolderID   `codec:"tlfID" json:"tlfID"`
+	TfSketch []byte     `codec:"tfSketch" json:"tfSketch"`
+	DocID    DocumentID `codec:"docID" json:"docID"`
+}
+
+type WriteIndexesArg struct {
+	TlfID   FolderID    `codec:"tlfID" json:"tlfID"`
+	Indexes []IndexItem `codec:"indexes" json:"indexes"`
+}
+
 type RenameIndexArg struct {
 	TlfID FolderID   `codec:"tlfID" json:"tlfID"`
 	Orig  DocumentID `codec:"orig" json:"orig"`
@@ -40,24 +106,61 @@ type GetKeyGensArg struct {
 	TlfID FolderID `codec:"tlfID" json:"tlfID"`
 }
 
+type ListDocumentsArg struct {
+	TlfID  FolderID   `codec:"tlfID" json:"tlfID"`
+	Cursor DocumentID `codec:"cursor" json:"cursor"`
+	Limit  int        `codec:"limit" json:"limit"`
+}
+
 type SearchWordArg struct {
 	TlfID     FolderID            `codec:"tlfID" json:"tlfID"`
 	Trapdoors map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
 }
 
+type SearchQueryArg struct {
+	TlfID     FolderID              `codec:"tlfID" json:"tlfID"`
+	Query     []QueryToken          `codec:"query" json:"query"`
+	Trapdoors map[string][]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+}
+
+type SearchRankedArg struct {
+	TlfID     FolderID              `codec:"tlfID" json:"tlfID"`
+	Query     []QueryToken          `codec:"query" json:"query"`
+	Trapdoors map[string][]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+}
+
+type SearchFuzzyArg struct {
+	TlfID      FolderID              `codec:"tlfID" json:"tlfID"`
+	Trapdoors  map[string][]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	MinMatches int                   `codec:"minMatches" json:"minMatches"`
+}
+
 type RegisterTlfIfNotExistsArg struct {
-	TlfID        FolderID `codec:"tlfID" json:"tlfID"`
-	LenSalt      int      `codec:"lenSalt" json:"lenSalt"`
-	FpRate       float64  `codec:"fpRate" json:"fpRate"`
-	NumUniqWords int64    `codec:"numUniqWords" json:"numUniqWords"`
+	TlfID           FolderID `codec:"tlfID" json:"tlfID"`
+	LenSalt         int      `codec:"lenSalt" json:"lenSalt"`
+	FpRate          float64  `codec:"fpRate" json:"fpRate"`
+	NumUniqWords    int64    `codec:"numUniqWords" json:"numUniqWords"`
+	Analyzer        string   `codec:"analyzer" json:"analyzer"`
+	StripDiacritics bool     `codec:"stripDiacritics" json:"stripDiacritics"`
+	MaxPrefixLen    int      `codec:"maxPrefixLen" json:"maxPrefixLen"`
+	Fuzzy           bool     `codec:"fuzzy" json:"fuzzy"`
+	Phrases         bool     `codec:"phrases" json:"phrases"`
+	Paths           bool     `codec:"paths" json:"paths"`
+	Metadata        bool     `codec:"metadata" json:"metadata"`
 }
 
 type SearchServerInterface interface {
 	WriteIndex(context.Context, WriteIndexArg) error
+	WriteTfSketch(context.Context, WriteTfSketchArg) error
+	WriteIndexes(context.Context, WriteIndexesArg) ([]WriteIndexResult, error)
 	RenameIndex(context.Context, RenameIndexArg) error
 	DeleteIndex(context.Context, DeleteIndexArg) error
 	GetKeyGens(context.Context, FolderID) ([]int, error)
+	ListDocuments(context.Context, ListDocumentsArg) (DocumentList, error)
 	SearchWord(context.Context, SearchWordArg) ([]DocumentID, error)
+	SearchQuery(context.Context, SearchQueryArg) ([]DocumentID, error)
+	SearchRanked(context.Context, SearchRankedArg) (RankedSearchResult, error)
+	SearchFuzzy(context.Context, SearchFuzzyArg) ([]RankedDocument, error)
 	RegisterTlfIfNotExists(context.Context, RegisterTlfIfNotExistsArg) (TlfInfo, error)
 }
 
@@ -81,6 +184,38 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 				},
 				MethodType: rpc.MethodCall,
 			},
+			"writeTfSketch": {
+				MakeArg: func() interface{} {
+					ret := make([]WriteTfSketchArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]WriteTfSketchArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]WriteTfSketchArg)(nil), args)
+						return
+					}
+					err = i.WriteTfSketch(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"writeIndexes": {
+				MakeArg: func() interface{} {
+					ret := make([]WriteIndexesArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]WriteIndexesArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]WriteIndexesArg)(nil), args)
+						return
+					}
+					ret, err = i.WriteIndexes(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
 			"renameIndex": {
 				MakeArg: func() interface{} {
 					ret := make([]RenameIndexArg, 1)
@@ -129,6 +264,22 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 				},
 				MethodType: rpc.MethodCall,
 			},
+			"listDocuments": {
+				MakeArg: func() interface{} {
+					ret := make([]ListDocumentsArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]ListDocumentsArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]ListDocumentsArg)(nil), args)
+						return
+					}
+					ret, err = i.ListDocuments(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
 			"searchWord": {
 				MakeArg: func() interface{} {
 					ret := make([]SearchWordArg, 1)
@@ -145,6 +296,54 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 				},
 				MethodType: rpc.MethodCall,
 			},
+			"searchQuery": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchQueryArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchQueryArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchQueryArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchQuery(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"searchRanked": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchRankedArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchRankedArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchRankedArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchRanked(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"searchFuzzy": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchFuzzyArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchFuzzyArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchFuzzyArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchFuzzy(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
 			"registerTlfIfNotExists": {
 				MakeArg: func() interface{} {
 					ret := make([]RegisterTlfIfNotExistsArg, 1)
@@ -174,6 +373,16 @@ func (c SearchServerClient) WriteIndex(ctx context.Context, __arg WriteIndexArg)
 	return
 }
 
+func (c SearchServerClient) WriteTfSketch(ctx context.Context, __arg WriteTfSketchArg) (err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.writeTfSketch", []interface{}{__arg}, nil)
+	return
+}
+
+func (c SearchServerClient) WriteIndexes(ctx context.Context, __arg WriteIndexesArg) (res []WriteIndexResult, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.writeIndexes", []interface{}{__arg}, &res)
+	return
+}
+
 func (c SearchServerClient) RenameIndex(ctx context.Context, __arg RenameIndexArg) (err error) {
 	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.renameIndex", []interface{}{__arg}, nil)
 	return
@@ -190,11 +399,31 @@ func (c SearchServerClient) GetKeyGens(ctx context.Context, tlfID FolderID) (res
 	return
 }
 
+func (c SearchServerClient) ListDocuments(ctx context.Context, __arg ListDocumentsArg) (res DocumentList, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.listDocuments", []interface{}{__arg}, &res)
+	return
+}
+
 func (c SearchServerClient) SearchWord(ctx context.Context, __arg SearchWordArg) (res []DocumentID, err error) {
 	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchWord", []interface{}{__arg}, &res)
 	return
 }
 
+func (c SearchServerClient) SearchQuery(ctx context.Context, __arg SearchQueryArg) (res []DocumentID, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchQuery", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) SearchRanked(ctx context.Context, __arg SearchRankedArg) (res RankedSearchResult, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchRanked", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) SearchFuzzy(ctx context.Context, __arg SearchFuzzyArg) (res []RankedDocument, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchFuzzy", []interface{}{__arg}, &res)
+	return
+}
+
 func (c SearchServerClient) RegisterTlfIfNotExists(ctx context.Context, __arg RegisterTlfIfNotExistsArg) (res TlfInfo, err error) {
 	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.registerTlfIfNotExists", []interface{}{__arg}, &res)
 	return
@@ -11,14 +11,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"search/prototype/indexer"
 	"search/prototype/server"
 	"search/prototype/util"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 // Client stores the necessary information for a client.
@@ -144,21 +142,21 @@ func (c *Client) getFile(docID int) error {
 // searchWordHelper downloads all the `possibleDocs` and then performs a local
 // search of `word` on them.
 func (c *Client) searchWordHelper(word string, possibleDocs []int) ([]string, float64, error) {
-	args := make([]string, len(possibleDocs)+2)
-	args[0] = "-lZw"
-	args[1] = word
-	for index, docID := range possibleDocs {
+	var filenames []string
+	for _, docID := range possibleDocs {
 		err := c.getFile(docID)
 		if err != nil {
 			return nil, 0, err
 		}
-		args[index+2] = path.Join(c.directory, c.lookupTable[strconv.Itoa(docID)])
-	}
-	output, _ := exec.Command("grep", args...).Output()
-	filenames := strings.Split(string(output), "\x00")
-	filenames = filenames[:len(filenames)-1]
-	for i := range filenames {
-		_, filenames[i] = path.Split(filenames[i])
+		filename := path.Join(c.directory, c.lookupTable[strconv.Itoa(docID)])
+		found, err := util.FileContainsWord(filename, word)
+		if err != nil {
+			return nil, 0, err
+		}
+		if found {
+			_, name := path.Split(filename)
+			filenames = append(filenames, name)
+		}
 	}
 	return filenames, float64(len(possibleDocs)-len(filenames)) / float64(len(c.lookupTable)-len(filenames)), nil
 }
@@ -5,8 +5,10 @@
 package util
 
 import (
+	"bufio"
 	"crypto/rand"
 	"math/big"
+	"os"
 )
 
 // GenerateSalts generates `numKeys` salts with length `lenSalt`.  Returns an
@@ -42,3 +44,23 @@ func XorBytes(one, two []byte, len int) []byte {
 	}
 	return result
 }
+
+// FileContainsWord returns true if the file at `filename` contains `word`.  The
+// file is split into words in the same way as the index builder, so only exact
+// matches of whole words are found.
+func FileContainsWord(filename, word string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		if scanner.Text() == word {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
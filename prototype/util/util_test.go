@@ -7,6 +7,8 @@ package util
 import (
 	"bytes"
 	"crypto/rand"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -58,3 +60,28 @@ func TestXorBytes(t *testing.T) {
 		}
 	}
 }
+
+// Tests the `FileContainsWord` function.  Checks that only whole words are
+// matched, and that an error is returned for a missing file.
+func TestFileContainsWord(t *testing.T) {
+	file, err := ioutil.TempFile("", "containsTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file")
+	}
+	defer os.Remove(file.Name())
+	file.Write([]byte("This is a simple test file."))
+	file.Close()
+
+	if found, err := FileContainsWord(file.Name(), "simple"); err != nil || !found {
+		t.Fatalf("word in the file not found")
+	}
+	if found, err := FileContainsWord(file.Name(), "test file"); err != nil || found {
+		t.Fatalf("non-word found in the file")
+	}
+	if found, err := FileContainsWord(file.Name(), "sim"); err != nil || found {
+		t.Fatalf("partial word found in the file")
+	}
+	if _, err := FileContainsWord(file.Name()+"missing", "simple"); err == nil {
+		t.Fatalf("no error returned for missing file")
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// memoryTlf holds the data of a single TLF stored in a `MemoryServer`.
+type memoryTlf struct {
+	tlfInfo    sserver1.TlfInfo                              // The information of the TLF.
+	keyGens    map[int]bool                                  // The set of key generations of the indexes ever written.
+	indexes    map[sserver1.DocumentID]libsearch.SecureIndex // The map from the document IDs to the unmarshaled indexes.
+	tfSketches map[sserver1.DocumentID]libsearch.SecureIndex // The map from the document IDs to the unmarshaled term frequency sketches.
+}
+
+// docIDSlice attaches the methods of sort.Interface to []DocumentID, sorting
+// in increasing order.
+type docIDSlice []sserver1.DocumentID
+
+func (p docIDSlice) Len() int           { return len(p) }
+func (p docIDSlice) Less(i, j int) bool { return p[i] < p[j] }
+func (p docIDSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// MemoryServer is a goroutine-safe implementation of the
+// SearchServerInterface that keeps all the data in memory.  Intended for tests
+// and for embedding the search server in other processes.
+type MemoryServer struct {
+	lock sync.RWMutex                     // The RWMutex to protect `tlfs`.
+	tlfs map[sserver1.FolderID]*memoryTlf // The map from the TLF IDs to the TLF data.
+}
+
+// CreateMemoryServer creates a new empty `MemoryServer`.
+func CreateMemoryServer() *MemoryServer {
+	return &MemoryServer{tlfs: make(map[sserver1.FolderID]*memoryTlf)}
+}
+
+// getTlf returns the data of `tlfID`, or an error if `tlfID` has not been
+// registered.  The caller must hold the lock.
+func (s *MemoryServer) getTlf(tlfID sserver1.FolderID) (*memoryTlf, error) {
+	tlf, ok := s.tlfs[tlfID]
+	if !ok {
+		return nil, errors.New("TLF not registered")
+	}
+	return tlf, nil
+}
+
+// WriteIndex implements the SearchServerInterface interface.
+func (s *MemoryServer) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) error {
+	keyGen, err := libsearch.GetKeyGenFromDocID(arg.DocID)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tlf, err := s.getTlf(arg.TlfID)
+	if err != nil {
+		return err
+	}
+	secIndex, err := unmarshalSecureIndex(arg.SecureIndex, tlf.tlfInfo)
+	if err != nil {
+		return err
+	}
+	tlf.keyGens[keyGen] = true
+	tlf.indexes[arg.DocID] = secIndex
+	delete(tlf.tfSketches, arg.DocID)
+	return nil
+}
+
+// WriteTfSketch implements the SearchServerInterface interface.
+func (s *MemoryServer) WriteTfSketch(_ context.Context, arg sserver1.WriteTfSketchArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tlf, err := s.getTlf(arg.TlfID)
+	if err != nil {
+		return err
+	}
+	tfSketch, err := unmarshalSecureIndex(arg.TfSketch, tlf.tlfInfo)
+	if err != nil {
+		return err
+	}
+	tlf.tfSketches[arg.DocID] = tfSketch
+	return nil
+}
+
+// WriteIndexes implements the SearchServerInterface interface.
+func (s *MemoryServer) WriteIndexes(_ context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteIndexResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tlf, err := s.getTlf(arg.TlfID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]sserver1.WriteIndexResult, len(arg.Indexes))
+	for i, item := range arg.Indexes {
+		results[i].DocID = item.DocID
+		keyGen, secIndex, tfSketch, err := unmarshalIndexItem(item, tlf.tlfInfo)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		tlf.keyGens[keyGen] = true
+		tlf.indexes[item.DocID] = secIndex
+		if tfSketch != nil {
+			tlf.tfSketches[item.DocID] = *tfSketch
+		} else {
+			delete(tlf.tfSketches, item.DocID)
+		}
+	}
+	return results, nil
+}
+
+// RenameIndex implements the SearchServerInterface interface.
+func (s *MemoryServer) RenameIndex(_ context.Context, arg sserver1.RenameIndexArg) error {
+	keyGen, err := libsearch.GetKeyGenFromDocID(arg.Curr)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tlf, ok := s.tlfs[arg.TlfID]
+	if !ok {
+		return nil
+	}
+	secIndex, ok := tlf.indexes[arg.Orig]
+	if !ok {
+		return nil
+	}
+	delete(tlf.indexes, arg.Orig)
+	tlf.keyGens[keyGen] = true
+	tlf.indexes[arg.Curr] = secIndex
+	if tfSketch, ok := tlf.tfSketches[arg.Orig]; ok {
+		delete(tlf.tfSketches, arg.Orig)
+		tlf.tfSketches[arg.Curr] = tfSketch
+	} else {
+		delete(tlf.tfSketches, arg.Curr)
+	}
+	return nil
+}
+
+// DeleteIndex implements the SearchServerInterface interface.
+func (s *MemoryServer) DeleteIndex(_ context.Context, arg sserver1.DeleteIndexArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if tlf, ok := s.tlfs[arg.TlfID]; ok {
+		delete(tlf.indexes, arg.DocID)
+		delete(tlf.tfSketches, arg.DocID)
+	}
+	return nil
+}
+
+// GetKeyGens implements the SearchServerInterface interface.
+func (s *MemoryServer) GetKeyGens(_ context.Context, tlfID sserver1.FolderID) ([]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keyGens := make([]int, 0, 1)
+	if tlf, ok := s.tlfs[tlfID]; ok {
+		for keyGen := range tlf.keyGens {
+			keyGens = append(keyGens, keyGen)
+		}
+	}
+	sort.Ints(keyGens)
+	return keyGens, nil
+}
+
+// ListDocuments implements the SearchServerInterface interface.
+func (s *MemoryServer) ListDocuments(_ context.Context, arg sserver1.ListDocumentsArg) (sserver1.DocumentList, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	docIDs := make([]sserver1.DocumentID, 0, 1)
+	if tlf, ok := s.tlfs[arg.TlfID]; ok {
+		for docID := range tlf.indexes {
+			if docID > arg.Cursor {
+				docIDs = append(docIDs, docID)
+			}
+		}
+	}
+
+	sort.Sort(docIDSlice(docIDs))
+	list := sserver1.DocumentList{Documents: docIDs}
+	if limit := listLimit(arg.Limit); len(docIDs) > limit {
+		list.Documents = docIDs[:limit]
+		list.NextCursor = docIDs[limit-1]
+	}
+	return list, nil
+}
+
+// SearchWord implements the SearchServerInterface interface.  The document IDs
+// are returned in increasing order to match the iteration order of `Server`.
+func (s *MemoryServer) SearchWord(_ context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tlf, ok := s.tlfs[arg.TlfID]
+	if !ok {
+		return nil, nil
+	}
+
+	// Groups the indexes by their key generations so that each group can be
+	// evaluated against its trapdoor in one batch.
+	groupDocIDs := make(map[string][]sserver1.DocumentID)
+	groupIndexes := make(map[string][]libsearch.SecureIndex)
+	for docID, secIndex := range tlf.indexes {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		group := strconv.Itoa(keyGen)
+		groupDocIDs[group] = append(groupDocIDs[group], docID)
+		groupIndexes[group] = append(groupIndexes[group], secIndex)
+	}
+
+	var docIDs []sserver1.DocumentID
+	for group, trapdoor := range arg.Trapdoors {
+		for _, i := range libsearch.SearchSecureIndexes(groupIndexes[group], trapdoor) {
+			docIDs = append(docIDs, groupDocIDs[group][i])
+		}
+	}
+
+	sort.Sort(docIDSlice(docIDs))
+	return docIDs, nil
+}
+
+// SearchQuery implements the SearchServerInterface interface.  The document IDs
+// are returned in increasing order to match the iteration order of `Server`.
+func (s *MemoryServer) SearchQuery(_ context.Context, arg sserver1.SearchQueryArg) ([]sserver1.DocumentID, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tlf, ok := s.tlfs[arg.TlfID]
+	if !ok {
+		return nil, nil
+	}
+
+	var docIDs []sserver1.DocumentID
+	for docID, secIndex := range tlf.indexes {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		found, err := libsearch.SearchSecureIndexQuery(secIndex, arg.Query, trapdoors)
+		if err != nil {
+			return nil, err
+		} else if found {
+			docIDs = append(docIDs, docID)
+		}
+	}
+
+	sort.Sort(docIDSlice(docIDs))
+	return docIDs, nil
+}
+
+// SearchRanked implements the SearchServerInterface interface.
+func (s *MemoryServer) SearchRanked(_ context.Context, arg sserver1.SearchRankedArg) (sserver1.RankedSearchResult, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ranker := newRanker(arg.Query, arg.Trapdoors)
+	tlf, ok := s.tlfs[arg.TlfID]
+	if !ok {
+		return ranker.result(), nil
+	}
+
+	for docID, secIndex := range tlf.indexes {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return sserver1.RankedSearchResult{}, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		var tfSketch *libsearch.SecureIndex
+		if sketch, ok := tlf.tfSketches[docID]; ok {
+			tfSketch = &sketch
+		}
+		if err := ranker.addDocument(docID, secIndex, tfSketch, arg.Query, trapdoors); err != nil {
+			return sserver1.RankedSearchResult{}, err
+		}
+	}
+
+	return ranker.result(), nil
+}
+
+// SearchFuzzy implements the SearchServerInterface interface.
+func (s *MemoryServer) SearchFuzzy(_ context.Context, arg sserver1.SearchFuzzyArg) ([]sserver1.RankedDocument, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tlf, ok := s.tlfs[arg.TlfID]
+	if !ok {
+		return nil, nil
+	}
+
+	var documents []sserver1.RankedDocument
+	for docID, secIndex := range tlf.indexes {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		if document, ok := scoreFuzzy(docID, secIndex, trapdoors, arg.MinMatches); ok {
+			documents = append(documents, document)
+		}
+	}
+
+	sort.Sort(rankedDocumentSlice(documents))
+	return documents, nil
+}
+
+// RegisterTlfIfNotExists implements the SearchServerInterface interface.
+func (s *MemoryServer) RegisterTlfIfNotExists(_ context.Context, arg sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if tlf, ok := s.tlfs[arg.TlfID]; ok {
+		return tlf.tlfInfo, nil
+	}
+
+	tlfInfo, err := createTlfInfo(arg)
+	if err != nil {
+		return tlfInfo, err
+	}
+
+	s.tlfs[arg.TlfID] = &memoryTlf{
+		tlfInfo:    tlfInfo,
+		keyGens:    make(map[int]bool),
+		indexes:    make(map[sserver1.DocumentID]libsearch.SecureIndex),
+		tfSketches: make(map[sserver1.DocumentID]libsearch.SecureIndex),
+	}
+	return tlfInfo, nil
+}
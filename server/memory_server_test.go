@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestMemoryServerConcurrent tests that a `MemoryServer` can be written to
+// and searched from multiple goroutines at once, and that the search results
+// are grouped by the key generations of the document IDs.
+func TestMemoryServerConcurrent(t *testing.T) {
+	s := CreateMemoryServer()
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sibs := []*libsearch.SecureIndexBuilder{
+		libsearch.CreateSecureIndexBuilder(sha256.New, []byte("keygen1"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{}),
+		libsearch.CreateSecureIndexBuilder(sha256.New, []byte("keygen2"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{}),
+	}
+	var pathnameKey libsearch.PathnameKeyType
+	secIndex := [][]byte{buildTestIndex(t, sibs[0], "shared word"), buildTestIndex(t, sibs[1], "shared word")}
+
+	numDocs := 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*numDocs)
+	for i := 0; i < numDocs; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			keyGen := i%2 + 1
+			docID, err := libsearch.PathnameToDocID(libkbfs.KeyGen(keyGen), "file"+strconv.Itoa(i), pathnameKey)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: secIndex[keyGen-1], DocID: docID})
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: tlfID, Trapdoors: map[string]sserver1.Trapdoor{"1": {Codeword: sibs[0].ComputeTrapdoors("shared")}}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("error in concurrent operations: %s", err)
+		}
+	}
+
+	result, err := s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: tlfID, Trapdoors: map[string]sserver1.Trapdoor{"1": {Codeword: sibs[0].ComputeTrapdoors("shared")}}})
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if len(result) != numDocs/2 {
+		t.Fatalf("incorrect number of results for key generation 1: %d", len(result))
+	}
+
+	result, err = s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: tlfID, Trapdoors: map[string]sserver1.Trapdoor{"1": {Codeword: sibs[0].ComputeTrapdoors("shared")}, "2": {Codeword: sibs[1].ComputeTrapdoors("shared")}}})
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	if len(result) != numDocs {
+		t.Fatalf("incorrect number of results for both key generations: %d", len(result))
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"sort"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// rankedDocumentSlice attaches the methods of sort.Interface to
+// []RankedDocument, sorting in decreasing order of the scores, and then in
+// increasing order of the document IDs.
+type rankedDocumentSlice []sserver1.RankedDocument
+
+func (p rankedDocumentSlice) Len() int { return len(p) }
+func (p rankedDocumentSlice) Less(i, j int) bool {
+	if p[i].Score != p[j].Score {
+		return p[i].Score > p[j].Score
+	}
+	return p[i].DocID < p[j].DocID
+}
+func (p rankedDocumentSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// ranker accumulates the statistics of a ranked search across the indexes of a
+// TLF.  Shared by all the server implementations so that they rank the
+// documents in exactly the same way.
+type ranker struct {
+	positive       []bool                    // Whether each term is used positively by the query, rather than only under NOT operators.
+	documents      []sserver1.RankedDocument // The documents matching the query.
+	docFrequencies []int                     // The number of indexes possibly containing each term.
+	numDocuments   int                       // The number of indexes searched.
+}
+
+// newRanker creates a `ranker` for `query` with the trapdoors of each key
+// generation in `trapdoors`.
+func newRanker(query []sserver1.QueryToken, trapdoors map[string][]sserver1.Trapdoor) *ranker {
+	numTerms := 0
+	for _, keyGenTrapdoors := range trapdoors {
+		if len(keyGenTrapdoors) > numTerms {
+			numTerms = len(keyGenTrapdoors)
+		}
+	}
+	return &ranker{positive: positiveTerms(query, numTerms), docFrequencies: make([]int, numTerms)}
+}
+
+// positiveTerms returns whether each of the `numTerms` terms of `query` is not
+// negated, i.e. whether its presence contributes to a document matching the
+// query.  The malformed parts of the query are ignored, as they are rejected
+// when the query is evaluated.
+func positiveTerms(query []sserver1.QueryToken, numTerms int) []bool {
+	type polarTerm struct {
+		term    int
+		negated bool
+	}
+	var stack [][]polarTerm
+	for _, token := range query {
+		switch token.Op {
+		case sserver1.QueryOp_TERM:
+			stack = append(stack, []polarTerm{{term: token.Term}})
+		case sserver1.QueryOp_NOT:
+			if len(stack) < 1 {
+				continue
+			}
+			top := stack[len(stack)-1]
+			for i := range top {
+				top[i].negated = !top[i].negated
+			}
+		case sserver1.QueryOp_AND, sserver1.QueryOp_OR:
+			if len(stack) < 2 {
+				continue
+			}
+			lhs, rhs := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1] = append(lhs, rhs...)
+		}
+	}
+
+	positive := make([]bool, numTerms)
+	for _, entry := range stack {
+		for _, polar := range entry {
+			if !polar.negated && polar.term >= 0 && polar.term < numTerms {
+				positive[polar.term] = true
+			}
+		}
+	}
+	return positive
+}
+
+// addDocument evaluates `query` against the index `secIndex` of `docID`, and
+// records the document if it matches.  The score of a matching document is the
+// sum over the positive terms of the query found in it of one plus their term
+// frequency levels in `tfSketch`, which may be nil if the client has not
+// uploaded a sketch.
+func (r *ranker) addDocument(docID sserver1.DocumentID, secIndex libsearch.SecureIndex, tfSketch *libsearch.SecureIndex, query []sserver1.QueryToken, trapdoors []sserver1.Trapdoor) error {
+	found := make([]bool, len(trapdoors))
+	for i, trapdoor := range trapdoors {
+		found[i] = libsearch.SearchSecureIndex(secIndex, trapdoor)
+		if found[i] {
+			r.docFrequencies[i]++
+		}
+	}
+	r.numDocuments++
+
+	matched, err := libsearch.EvaluateQuery(query, len(trapdoors), func(term int) bool {
+		return found[term]
+	})
+	if err != nil || !matched {
+		return err
+	}
+
+	score := 0
+	for i, trapdoor := range trapdoors {
+		if !found[i] || !r.positive[i] {
+			continue
+		}
+		score++
+		if tfSketch != nil {
+			score += libsearch.SearchTfSketch(*tfSketch, trapdoor)
+		}
+	}
+	r.documents = append(r.documents, sserver1.RankedDocument{DocID: docID, Score: score})
+	return nil
+}
+
+// result returns the ranked search result with the documents sorted by their
+// scores.
+func (r *ranker) result() sserver1.RankedSearchResult {
+	sort.Sort(rankedDocumentSlice(r.documents))
+	return sserver1.RankedSearchResult{
+		Documents:      r.documents,
+		DocFrequencies: r.docFrequencies,
+		NumDocuments:   r.numDocuments,
+	}
+}
+
+// scoreFuzzy scores the index `secIndex` of `docID` by the number of the
+// `trapdoors` it matches.  The second return value is false if fewer than
+// `minMatches` of them are matched, or if `trapdoors` is empty.
+func scoreFuzzy(docID sserver1.DocumentID, secIndex libsearch.SecureIndex, trapdoors []sserver1.Trapdoor, minMatches int) (sserver1.RankedDocument, bool) {
+	numMatches := libsearch.CountSecureIndexMatches(secIndex, trapdoors)
+	if numMatches == 0 || numMatches < minMatches {
+		return sserver1.RankedDocument{}, false
+	}
+	return sserver1.RankedDocument{DocID: docID, Score: numMatches}, true
+}
@@ -0,0 +1,589 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/libkb"
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/net/context"
+)
+
+// The prefixes of the keys stored in the database.
+const tlfInfoPrefix = "tlfinfo:"
+const keyGenPrefix = "keygen:"
+const indexPrefix = "index:"
+const tfSketchPrefix = "tfsketch:"
+
+// maxListLimit is the maximum number of document IDs returned in a single page
+// by `ListDocuments`.
+const maxListLimit = 1000
+
+// Server contains all the necessary information for a KBFS Search Server.
+type Server struct {
+	db      *leveldb.DB // The database storing the TLF information and the indexes.
+	tlfLock sync.Mutex  // The mutex to make the registration of TLFs atomic.
+	verbose bool        // Whether log outputs should be printed out.
+}
+
+// logOutput is a simple log output that prints to the console.
+type logOutput struct {
+	verbose bool // Whether log outputs should be printed out
+}
+
+func (l logOutput) log(ch string, fmts string, args []interface{}) {
+	if !l.verbose {
+		return
+	}
+	fmts = fmt.Sprintf("[%s] %s", ch, fmts)
+	fmt.Println(fmts, args)
+}
+func (l logOutput) Info(fmt string, args ...interface{})    { l.log("I", fmt, args) }
+func (l logOutput) Error(fmt string, args ...interface{})   { l.log("E", fmt, args) }
+func (l logOutput) Debug(fmt string, args ...interface{})   { l.log("D", fmt, args) }
+func (l logOutput) Warning(fmt string, args ...interface{}) { l.log("W", fmt, args) }
+func (l logOutput) Profile(fmt string, args ...interface{}) { l.log("P", fmt, args) }
+
+// CreateServer creates a new `Server` instance that stores its data in a
+// goleveldb database at `dbPath`.  The database is created if it does not
+// exist yet.  Returns an error on any failure.
+func CreateServer(dbPath string, verbose bool) (*Server, error) {
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{db: db, verbose: verbose}, nil
+}
+
+// Close closes the underlying database of the server.
+func (s *Server) Close() error {
+	return s.db.Close()
+}
+
+// Serve accepts incoming connections on `listener` and serves the search
+// server protocol on each of them.  Blocks until `listener` returns an error.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn serves the search server protocol on `conn` until the connection
+// is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	xp := rpc.NewTransport(conn, rpc.NewSimpleLogFactory(logOutput{verbose: s.verbose}, nil), libkb.WrapError)
+	srv := rpc.NewServer(xp, libkb.WrapError)
+	if err := srv.Register(sserver1.SearchServerProtocol(s)); err != nil {
+		return
+	}
+	<-srv.Run()
+}
+
+// tlfInfoKey returns the database key for the information of `tlfID`.
+func tlfInfoKey(tlfID sserver1.FolderID) []byte {
+	return []byte(tlfInfoPrefix + tlfID.String())
+}
+
+// keyGenKey returns the database key that marks `keyGen` as being used in
+// `tlfID`.
+func keyGenKey(tlfID sserver1.FolderID, keyGen int) []byte {
+	return []byte(keyGenPrefix + tlfID.String() + ":" + strconv.Itoa(keyGen))
+}
+
+// indexKey returns the database key for the index of `docID` in `tlfID`.
+func indexKey(tlfID sserver1.FolderID, docID sserver1.DocumentID) []byte {
+	return []byte(indexPrefix + tlfID.String() + ":" + docID.String())
+}
+
+// tfSketchKey returns the database key for the term frequency sketch of `docID`
+// in `tlfID`.
+func tfSketchKey(tlfID sserver1.FolderID, docID sserver1.DocumentID) []byte {
+	return []byte(tfSketchPrefix + tlfID.String() + ":" + docID.String())
+}
+
+// getTlfInfo returns the information of `tlfID`, or an error if `tlfID` has
+// not been registered.
+func (s *Server) getTlfInfo(tlfID sserver1.FolderID) (sserver1.TlfInfo, error) {
+	var tlfInfo sserver1.TlfInfo
+	tlfInfoJSON, err := s.db.Get(tlfInfoKey(tlfID), nil)
+	if err == leveldb.ErrNotFound {
+		return tlfInfo, errors.New("TLF not registered")
+	} else if err != nil {
+		return tlfInfo, err
+	}
+	err = json.Unmarshal(tlfInfoJSON, &tlfInfo)
+	return tlfInfo, err
+}
+
+// WriteIndex implements the SearchServerInterface interface.  Stores the
+// `SecureIndex` for `DocID`, overwriting any existing index for the document.
+// Indexes that do not have the index size of the TLF are rejected, as they
+// cannot be searched.  The previous term frequency sketch of the document is
+// deleted, as it does not describe the new index.
+func (s *Server) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) error {
+	tlfInfo, err := s.getTlfInfo(arg.TlfID)
+	if err != nil {
+		return err
+	}
+
+	keyGen, err := libsearch.GetKeyGenFromDocID(arg.DocID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := unmarshalSecureIndex(arg.SecureIndex, tlfInfo); err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(keyGenKey(arg.TlfID, keyGen), []byte{})
+	batch.Put(indexKey(arg.TlfID, arg.DocID), arg.SecureIndex)
+	batch.Delete(tfSketchKey(arg.TlfID, arg.DocID))
+	return s.db.Write(batch, nil)
+}
+
+// WriteTfSketch implements the SearchServerInterface interface.  Stores the
+// term frequency sketch for `DocID`, which is used to rank the results of
+// `SearchRanked`.
+func (s *Server) WriteTfSketch(_ context.Context, arg sserver1.WriteTfSketchArg) error {
+	tlfInfo, err := s.getTlfInfo(arg.TlfID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := unmarshalSecureIndex(arg.TfSketch, tlfInfo); err != nil {
+		return err
+	}
+
+	return s.db.Put(tfSketchKey(arg.TlfID, arg.DocID), arg.TfSketch, nil)
+}
+
+// WriteIndexes implements the SearchServerInterface interface.  Each of the
+// indexes is validated on its own, and all the valid ones are written in a
+// single batch.  An index written without a term frequency sketch replaces the
+// previous sketch of the document as well.
+func (s *Server) WriteIndexes(_ context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteIndexResult, error) {
+	tlfInfo, err := s.getTlfInfo(arg.TlfID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]sserver1.WriteIndexResult, len(arg.Indexes))
+	batch := new(leveldb.Batch)
+	for i, item := range arg.Indexes {
+		results[i].DocID = item.DocID
+		keyGen, _, _, err := unmarshalIndexItem(item, tlfInfo)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		batch.Put(keyGenKey(arg.TlfID, keyGen), []byte{})
+		batch.Put(indexKey(arg.TlfID, item.DocID), item.SecureIndex)
+		if len(item.TfSketch) > 0 {
+			batch.Put(tfSketchKey(arg.TlfID, item.DocID), item.TfSketch)
+		} else {
+			batch.Delete(tfSketchKey(arg.TlfID, item.DocID))
+		}
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// RenameIndex implements the SearchServerInterface interface.  Moves the index
+// and the term frequency sketch of `Orig` to `Curr`, deleting any sketch of
+// `Curr` if `Orig` has none.  Renaming a non-existing
+// index is a no-op, but `Curr` must be a valid document ID either way.
+func (s *Server) RenameIndex(_ context.Context, arg sserver1.RenameIndexArg) error {
+	keyGen, err := libsearch.GetKeyGenFromDocID(arg.Curr)
+	if err != nil {
+		return err
+	}
+
+	secIndex, err := s.db.Get(indexKey(arg.TlfID, arg.Orig), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	tfSketch, err := s.db.Get(tfSketchKey(arg.TlfID, arg.Orig), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(indexKey(arg.TlfID, arg.Orig))
+	batch.Delete(tfSketchKey(arg.TlfID, arg.Orig))
+	batch.Put(keyGenKey(arg.TlfID, keyGen), []byte{})
+	batch.Put(indexKey(arg.TlfID, arg.Curr), secIndex)
+	if tfSketch != nil {
+		batch.Put(tfSketchKey(arg.TlfID, arg.Curr), tfSketch)
+	} else {
+		batch.Delete(tfSketchKey(arg.TlfID, arg.Curr))
+	}
+	return s.db.Write(batch, nil)
+}
+
+// DeleteIndex implements the SearchServerInterface interface.  Deleting a
+// non-existing index is a no-op.
+func (s *Server) DeleteIndex(_ context.Context, arg sserver1.DeleteIndexArg) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(indexKey(arg.TlfID, arg.DocID))
+	batch.Delete(tfSketchKey(arg.TlfID, arg.DocID))
+	return s.db.Write(batch, nil)
+}
+
+// GetKeyGens implements the SearchServerInterface interface.  Returns the key
+// generations of all the indexes ever written to `tlfID` in increasing order.
+func (s *Server) GetKeyGens(_ context.Context, tlfID sserver1.FolderID) ([]int, error) {
+	prefix := keyGenPrefix + tlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	keyGens := make([]int, 0, 1)
+	for iter.Next() {
+		keyGen, err := strconv.Atoi(strings.TrimPrefix(string(iter.Key()), prefix))
+		if err != nil {
+			return nil, err
+		}
+		keyGens = append(keyGens, keyGen)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.Ints(keyGens)
+	return keyGens, nil
+}
+
+// listLimit returns the number of document IDs to return in a page of
+// `ListDocuments` when `limit` is requested.
+func listLimit(limit int) int {
+	if limit <= 0 || limit > maxListLimit {
+		return maxListLimit
+	}
+	return limit
+}
+
+// ListDocuments implements the SearchServerInterface interface.  Returns the
+// page of the document IDs in `TlfID` following `Cursor`, in increasing order.
+func (s *Server) ListDocuments(_ context.Context, arg sserver1.ListDocumentsArg) (sserver1.DocumentList, error) {
+	prefix := indexPrefix + arg.TlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	limit := listLimit(arg.Limit)
+	list := sserver1.DocumentList{Documents: make([]sserver1.DocumentID, 0, 1)}
+	for ok := iter.Seek([]byte(prefix + arg.Cursor.String())); ok; ok = iter.Next() {
+		docID := sserver1.DocumentID(strings.TrimPrefix(string(iter.Key()), prefix))
+		if docID <= arg.Cursor {
+			continue
+		}
+		if len(list.Documents) == limit {
+			list.NextCursor = list.Documents[limit-1]
+			break
+		}
+		list.Documents = append(list.Documents, docID)
+	}
+	if err := iter.Error(); err != nil {
+		return sserver1.DocumentList{}, err
+	}
+
+	return list, nil
+}
+
+// SearchWord implements the SearchServerInterface interface.  Checks every
+// index in the TLF against the trapdoors of its key generation, and returns
+// the document IDs of the indexes possibly containing the word.
+func (s *Server) SearchWord(_ context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	prefix := indexPrefix + arg.TlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var docIDs []sserver1.DocumentID
+	for iter.Next() {
+		docID := sserver1.DocumentID(strings.TrimPrefix(string(iter.Key()), prefix))
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		trapdoor, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		var secIndex libsearch.SecureIndex
+		if err := secIndex.UnmarshalBinary(iter.Value()); err != nil {
+			return nil, err
+		}
+		if libsearch.SearchSecureIndex(secIndex, trapdoor) {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return docIDs, nil
+}
+
+// SearchQuery implements the SearchServerInterface interface.  Evaluates the
+// boolean query against every index in the TLF with the trapdoors of its key
+// generation, and returns the document IDs of the indexes possibly matching
+// the query.
+func (s *Server) SearchQuery(_ context.Context, arg sserver1.SearchQueryArg) ([]sserver1.DocumentID, error) {
+	prefix := indexPrefix + arg.TlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var docIDs []sserver1.DocumentID
+	for iter.Next() {
+		docID := sserver1.DocumentID(strings.TrimPrefix(string(iter.Key()), prefix))
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		var secIndex libsearch.SecureIndex
+		if err := secIndex.UnmarshalBinary(iter.Value()); err != nil {
+			return nil, err
+		}
+		found, err := libsearch.SearchSecureIndexQuery(secIndex, arg.Query, trapdoors)
+		if err != nil {
+			return nil, err
+		} else if found {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return docIDs, nil
+}
+
+// SearchRanked implements the SearchServerInterface interface.  Evaluates the
+// boolean query in the same way as `SearchQuery`, and ranks the matching
+// documents by the coarse term frequencies in their sketches.
+func (s *Server) SearchRanked(_ context.Context, arg sserver1.SearchRankedArg) (sserver1.RankedSearchResult, error) {
+	prefix := indexPrefix + arg.TlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	ranker := newRanker(arg.Query, arg.Trapdoors)
+	for iter.Next() {
+		docID := sserver1.DocumentID(strings.TrimPrefix(string(iter.Key()), prefix))
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return sserver1.RankedSearchResult{}, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		var secIndex libsearch.SecureIndex
+		if err := secIndex.UnmarshalBinary(iter.Value()); err != nil {
+			return sserver1.RankedSearchResult{}, err
+		}
+		var tfSketch *libsearch.SecureIndex
+		tfSketchBytes, err := s.db.Get(tfSketchKey(arg.TlfID, docID), nil)
+		if err == nil {
+			tfSketch = new(libsearch.SecureIndex)
+			if err := tfSketch.UnmarshalBinary(tfSketchBytes); err != nil {
+				return sserver1.RankedSearchResult{}, err
+			}
+		} else if err != leveldb.ErrNotFound {
+			return sserver1.RankedSearchResult{}, err
+		}
+		if err := ranker.addDocument(docID, secIndex, tfSketch, arg.Query, trapdoors); err != nil {
+			return sserver1.RankedSearchResult{}, err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return sserver1.RankedSearchResult{}, err
+	}
+
+	return ranker.result(), nil
+}
+
+// SearchFuzzy implements the SearchServerInterface interface.  Counts the
+// trapdoors matched by each index, and returns the documents matching at
+// least `MinMatches` of them in decreasing order of the counts.
+func (s *Server) SearchFuzzy(_ context.Context, arg sserver1.SearchFuzzyArg) ([]sserver1.RankedDocument, error) {
+	prefix := indexPrefix + arg.TlfID.String() + ":"
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var documents []sserver1.RankedDocument
+	for iter.Next() {
+		docID := sserver1.DocumentID(strings.TrimPrefix(string(iter.Key()), prefix))
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		trapdoors, ok := arg.Trapdoors[strconv.Itoa(keyGen)]
+		if !ok {
+			continue
+		}
+		var secIndex libsearch.SecureIndex
+		if err := secIndex.UnmarshalBinary(iter.Value()); err != nil {
+			return nil, err
+		}
+		if document, ok := scoreFuzzy(docID, secIndex, trapdoors, arg.MinMatches); ok {
+			documents = append(documents, document)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(rankedDocumentSlice(documents))
+	return documents, nil
+}
+
+// RegisterTlfIfNotExists implements the SearchServerInterface interface.
+// Generates the salts and the index size for `TlfID` and records its analyzer
+// and normalization options on its first registration, and returns the stored
+// information on later ones, so that all the clients of the TLF agree on them.
+func (s *Server) RegisterTlfIfNotExists(_ context.Context, arg sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
+	s.tlfLock.Lock()
+	defer s.tlfLock.Unlock()
+
+	var tlfInfo sserver1.TlfInfo
+	tlfInfoJSON, err := s.db.Get(tlfInfoKey(arg.TlfID), nil)
+	if err == nil {
+		err = json.Unmarshal(tlfInfoJSON, &tlfInfo)
+		return tlfInfo, err
+	} else if err != leveldb.ErrNotFound {
+		return tlfInfo, err
+	}
+
+	tlfInfo, err = createTlfInfo(arg)
+	if err != nil {
+		return tlfInfo, err
+	}
+
+	tlfInfoJSON, err = json.Marshal(tlfInfo)
+	if err != nil {
+		return tlfInfo, err
+	}
+
+	return tlfInfo, s.db.Put(tlfInfoKey(arg.TlfID), tlfInfoJSON, nil)
+}
+
+// trigramsPerWord is the estimated number of unique trigrams that each unique
+// word adds to an index.  A word of n characters has n trigrams, but the
+// trigrams shared with the other words of the document are only inserted once.
+const trigramsPerWord = 6
+
+// biwordsPerWord is the estimated number of unique biwords that each unique
+// word adds to an index.  A word that occurs several times in a document is
+// usually followed by different words.
+const biwordsPerWord = 2
+
+// createTlfInfo generates the information for a new TLF registered with
+// `arg`.  The number of salts is given by `r = -log2(fpRate)`, and the size of
+// the indexes is chosen so that a bloom filter with `numUniqWords` words has a
+// false positive rate of `fpRate`.  When the prefixes of the words are indexed
+// as well, each word accounts for up to `maxPrefixLen` more insertions, when
+// the trigrams are indexed, for `trigramsPerWord` more, and when the biwords
+// are indexed, for `biwordsPerWord` more.  The few words of the pathnames and
+// buckets of the metadata indexed for the field searches are not accounted
+// for.  The analyzer and the normalization options are only used by the
+// clients, so they are recorded as is, except that an empty analyzer ID stands
+// for the default analyzer.
+func createTlfInfo(arg sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
+	if arg.LenSalt <= 0 || arg.FpRate <= 0 || arg.FpRate >= 1 || arg.NumUniqWords <= 0 || arg.MaxPrefixLen < 0 {
+		return sserver1.TlfInfo{}, errors.New("invalid TLF parameters")
+	}
+	analyzerID := arg.Analyzer
+	if analyzerID == "" {
+		analyzerID = libsearch.DefaultAnalyzerID
+	}
+	r := int(math.Ceil(-math.Log2(arg.FpRate)))
+	insertionsPerWord := 1 + arg.MaxPrefixLen
+	if arg.Fuzzy {
+		insertionsPerWord += trigramsPerWord
+	}
+	if arg.Phrases {
+		insertionsPerWord += biwordsPerWord
+	}
+	numInsertions := arg.NumUniqWords * int64(insertionsPerWord)
+	size := int64(math.Ceil(float64(numInsertions) * float64(r) / math.Log(2)))
+	salts, err := libsearch.GenerateSalts(r, arg.LenSalt)
+	if err != nil {
+		return sserver1.TlfInfo{}, err
+	}
+	return sserver1.TlfInfo{Salts: salts, Size: size, Analyzer: analyzerID, StripDiacritics: arg.StripDiacritics, MaxPrefixLen: arg.MaxPrefixLen, Fuzzy: arg.Fuzzy, Phrases: arg.Phrases, Paths: arg.Paths, Metadata: arg.Metadata}, nil
+}
+
+// indexHashSize is the output size of SHA-256, the hash function that the
+// clients build the indexes and the term frequency sketches with.
+const indexHashSize = sha256.Size
+
+// unmarshalSecureIndex unmarshals a secure index or a term frequency sketch
+// written to the TLF with `tlfInfo`, and checks that it can be searched with
+// the trapdoors of the clients: it must have the index size of the TLF and be
+// built with the hash function of the clients.
+func unmarshalSecureIndex(data []byte, tlfInfo sserver1.TlfInfo) (libsearch.SecureIndex, error) {
+	var secIndex libsearch.SecureIndex
+	if err := secIndex.UnmarshalBinary(data); err != nil {
+		return secIndex, err
+	}
+	if secIndex.Size == 0 || secIndex.Size != uint64(tlfInfo.Size) {
+		return secIndex, errors.New("invalid index size")
+	}
+	if secIndex.Hash().Size() != indexHashSize {
+		return secIndex, errors.New("invalid hash function")
+	}
+	return secIndex, nil
+}
+
+// unmarshalIndexItem validates an index to be written in a batch to the TLF
+// with `tlfInfo`, and returns its key generation along with the unmarshaled
+// index and term frequency sketch.  The sketch is nil if the item does not
+// have one.
+func unmarshalIndexItem(item sserver1.IndexItem, tlfInfo sserver1.TlfInfo) (int, libsearch.SecureIndex, *libsearch.SecureIndex, error) {
+	keyGen, err := libsearch.GetKeyGenFromDocID(item.DocID)
+	if err != nil {
+		return 0, libsearch.SecureIndex{}, nil, err
+	}
+	secIndex, err := unmarshalSecureIndex(item.SecureIndex, tlfInfo)
+	if err != nil {
+		return 0, secIndex, nil, err
+	}
+	if len(item.TfSketch) == 0 {
+		return keyGen, secIndex, nil, nil
+	}
+	tfSketch, err := unmarshalSecureIndex(item.TfSketch, tlfInfo)
+	if err != nil {
+		return 0, secIndex, nil, err
+	}
+	return keyGen, secIndex, &tfSketch, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/keybase/search/libsearch"
+	"github.com/keybase/search/server"
+)
+
+var port = flag.Int("port", 8022, "the port that the search server listens on")
+var ipAddr = flag.String("ip_addr", "127.0.0.1", "the IP address that the search server listens on")
+var dbDir = flag.String("db_dir", "search_server_db", "the directory of the database storing the TLF information and the indexes")
+var certFile = flag.String("cert_file", "", "the PEM-encoded TLS certificate of the server; the test certificate is used if empty")
+var keyFile = flag.String("key_file", "", "the PEM-encoded TLS private key of the server; the test key is used if empty")
+var verbose = flag.Bool("v", false, "whether log outputs should be printed out")
+
+// loadCertificate loads the TLS certificate from the files specified by the
+// flags, or falls back to the test certificate if none is given.
+func loadCertificate() (tls.Certificate, error) {
+	if *certFile == "" && *keyFile == "" {
+		return tls.X509KeyPair([]byte(libsearch.TestRootCert), []byte(libsearch.TestRootKey))
+	}
+	certPEM, err := ioutil.ReadFile(*certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func main() {
+	flag.Parse()
+
+	cert, err := loadCertificate()
+	if err != nil {
+		fmt.Printf("Cannot load the TLS certificate: %s\n", err)
+		os.Exit(1)
+	}
+
+	srv, err := server.CreateServer(*dbDir, *verbose)
+	if err != nil {
+		fmt.Printf("Cannot initialize the server: %s\n", err)
+		os.Exit(1)
+	}
+	defer srv.Close()
+
+	listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", *ipAddr, *port), &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		fmt.Printf("Cannot listen on %s:%d: %s\n", *ipAddr, *port, err)
+		os.Exit(1)
+	}
+
+	if *verbose {
+		fmt.Printf("Search server listening on %s\n", listener.Addr())
+	}
+
+	if err := srv.Serve(listener); err != nil {
+		fmt.Printf("Error when serving: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,616 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// startTestServer creates a server backed by a temporary database and returns
+// it along with the database directory, which needs to be cleaned up later.
+func startTestServer(t *testing.T) (*Server, string) {
+	dir, err := ioutil.TempDir("", "TestServer")
+	if err != nil {
+		t.Fatalf("error when creating the test server directory: %s", err)
+	}
+	s, err := CreateServer(dir, false)
+	if err != nil {
+		t.Fatalf("error when creating the server: %s", err)
+	}
+	return s, dir
+}
+
+// buildTestIndex builds a marshaled secure index for `content` with `sib`.
+func buildTestIndex(t *testing.T, sib *libsearch.SecureIndexBuilder, content string) []byte {
+	doc, err := ioutil.TempFile("", "indexTest")
+	if err != nil {
+		t.Fatalf("error when creating the test file: %s", err)
+	}
+	defer os.Remove(doc.Name())
+	defer doc.Close()
+	if _, err := doc.Write([]byte(content)); err != nil {
+		t.Fatalf("error when writing the test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("error when rewinding the test file: %s", err)
+	}
+	secIndex, err := sib.BuildSecureIndex(doc, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the secure index: %s", err)
+	}
+	return secIndexBytes
+}
+
+// buildInvalidTestIndexes builds marshaled secure indexes that the server must
+// reject for the TLF with `tlfInfo`: an index with a different size, and an
+// index without any bucket.
+func buildInvalidTestIndexes(t *testing.T, tlfInfo sserver1.TlfInfo) [][]byte {
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size)+1, libsearch.ProseAnalyzer, libsearch.IndexOptions{})
+	wrongSize := buildTestIndex(t, sib, "invalid content")
+	var secIndex libsearch.SecureIndex
+	if err := secIndex.UnmarshalBinary(wrongSize); err != nil {
+		t.Fatalf("error when unmarshaling the secure index: %s", err)
+	}
+	secIndex.Size = 0
+	zeroSize, err := secIndex.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the secure index: %s", err)
+	}
+	return [][]byte{wrongSize, zeroSize}
+}
+
+// buildTestIndexWithTfSketch builds a marshaled secure index and term frequency
+// sketch for `content` with `sib`.
+func buildTestIndexWithTfSketch(t *testing.T, sib *libsearch.SecureIndexBuilder, content string) ([]byte, []byte) {
+	doc, err := ioutil.TempFile("", "indexTest")
+	if err != nil {
+		t.Fatalf("error when creating the test file: %s", err)
+	}
+	defer os.Remove(doc.Name())
+	defer doc.Close()
+	if _, err := doc.Write([]byte(content)); err != nil {
+		t.Fatalf("error when writing the test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("error when rewinding the test file: %s", err)
+	}
+	secIndex, tfSketch, err := sib.BuildSecureIndexWithTfSketch(doc, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the secure index: %s", err)
+	}
+	tfSketchBytes, err := tfSketch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the term frequency sketch: %s", err)
+	}
+	return secIndexBytes, tfSketchBytes
+}
+
+// TestRegisterTlfIfNotExists tests the `RegisterTlfIfNotExists` function.
+// Checks that the TLF information is generated on the first registration and
+// kept unchanged afterwards, even across restarts of the server.
+func TestRegisterTlfIfNotExists(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+
+	arg := sserver1.RegisterTlfIfNotExistsArg{TlfID: "tlf", LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000}
+	tlfInfo1, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if len(tlfInfo1.Salts) != 20 || len(tlfInfo1.Salts[0]) != 8 {
+		t.Fatalf("incorrect salts generated")
+	}
+	if tlfInfo1.Size != 28854 {
+		t.Fatalf("incorrect size generated: %d", tlfInfo1.Size)
+	}
+	if tlfInfo1.Analyzer != libsearch.DefaultAnalyzerID {
+		t.Fatalf("incorrect default analyzer recorded: %s", tlfInfo1.Analyzer)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("error when closing the server: %s", err)
+	}
+	s, err = CreateServer(dir, false)
+	if err != nil {
+		t.Fatalf("error when reopening the server: %s", err)
+	}
+	defer s.Close()
+
+	arg.LenSalt = 16
+	arg.Analyzer = libsearch.CodeAnalyzerID
+	arg.StripDiacritics = true
+	tlfInfo2, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if !reflect.DeepEqual(tlfInfo1, tlfInfo2) {
+		t.Fatalf("TLF information changed after the second registration")
+	}
+
+	arg.TlfID = "code"
+	tlfInfo3, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if tlfInfo3.Analyzer != libsearch.CodeAnalyzerID {
+		t.Fatalf("incorrect analyzer recorded: %s", tlfInfo3.Analyzer)
+	}
+	if !tlfInfo3.StripDiacritics || tlfInfo1.StripDiacritics {
+		t.Fatalf("incorrect diacritic stripping recorded")
+	}
+
+	arg.TlfID = "prefix"
+	arg.MaxPrefixLen = 3
+	tlfInfo4, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if tlfInfo4.MaxPrefixLen != 3 || tlfInfo4.Size != 115416 {
+		t.Fatalf("incorrect prefix TLF information: %d %d", tlfInfo4.MaxPrefixLen, tlfInfo4.Size)
+	}
+
+	arg.TlfID = "fuzzy"
+	arg.MaxPrefixLen = 0
+	arg.Fuzzy = true
+	tlfInfo5, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if !tlfInfo5.Fuzzy || tlfInfo5.Size != 201978 {
+		t.Fatalf("incorrect fuzzy TLF information: %t %d", tlfInfo5.Fuzzy, tlfInfo5.Size)
+	}
+
+	arg.TlfID = "phrases"
+	arg.Fuzzy = false
+	arg.Phrases = true
+	tlfInfo6, err := s.RegisterTlfIfNotExists(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if !tlfInfo6.Phrases || tlfInfo6.Size != 86562 {
+		t.Fatalf("incorrect phrase TLF information: %t %d", tlfInfo6.Phrases, tlfInfo6.Size)
+	}
+
+	arg.TlfID = "invalid"
+	arg.Phrases = false
+	arg.MaxPrefixLen = -1
+	if _, err := s.RegisterTlfIfNotExists(context.Background(), arg); err == nil {
+		t.Fatalf("no error returned for negative maximum prefix length")
+	}
+	arg.MaxPrefixLen = 0
+	arg.FpRate = 0
+	if _, err := s.RegisterTlfIfNotExists(context.Background(), arg); err == nil {
+		t.Fatalf("no error returned for invalid false positive rate")
+	}
+}
+
+// TestIndexOperations tests the `WriteIndex`, `RenameIndex`, `DeleteIndex`,
+// `GetKeyGens` and `SearchWord` functions.  Checks that the correct set of
+// document IDs is returned after each operation.
+func TestIndexOperations(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{})
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], "a very secret pathname key")
+
+	contents := []string{"this is a test file", "this is another test file", "yet another file"}
+	docIDs := make([]sserver1.DocumentID, len(contents))
+	for i, content := range contents {
+		docIDs[i], err = libsearch.PathnameToDocID(libkbfs.KeyGen(1), "file"+strconv.Itoa(i), pathnameKey)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: buildTestIndex(t, sib, content), DocID: docIDs[i]}); err != nil {
+			t.Fatalf("error when writing the index: %s", err)
+		}
+	}
+
+	if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: "unregistered", SecureIndex: buildTestIndex(t, sib, "test"), DocID: docIDs[0]}); err == nil {
+		t.Fatalf("no error returned when writing to an unregistered TLF")
+	}
+	for _, invalidIndex := range buildInvalidTestIndexes(t, tlfInfo) {
+		if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: invalidIndex, DocID: docIDs[0]}); err == nil {
+			t.Fatalf("no error returned when writing an index of invalid size")
+		}
+	}
+	shortDocID := sserver1.DocumentID("c2hvcnQ")
+	if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: buildTestIndex(t, sib, "test"), DocID: shortDocID}); err == nil {
+		t.Fatalf("no error returned when writing an index with a short document ID")
+	}
+	if err := s.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: tlfID, Orig: docIDs[0], Curr: shortDocID}); err == nil {
+		t.Fatalf("no error returned when renaming an index to a short document ID")
+	}
+
+	keyGens, err := s.GetKeyGens(ctx, tlfID)
+	if err != nil {
+		t.Fatalf("error when getting the key generations: %s", err)
+	}
+	if !reflect.DeepEqual(keyGens, []int{1}) {
+		t.Fatalf("incorrect key generations: %v", keyGens)
+	}
+
+	search := func(word string) []sserver1.DocumentID {
+		result, err := s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: tlfID, Trapdoors: map[string]sserver1.Trapdoor{"1": {Codeword: sib.ComputeTrapdoors(word)}}})
+		if err != nil {
+			t.Fatalf("error when searching the word: %s", err)
+		}
+		return result
+	}
+
+	if result := search("another"); len(result) != 2 {
+		t.Fatalf("incorrect search result for \"another\": %v", result)
+	}
+	if result := search("nonexisting"); len(result) != 0 {
+		t.Fatalf("incorrect search result for \"nonexisting\": %v", result)
+	}
+
+	query := []sserver1.QueryToken{{Op: sserver1.QueryOp_TERM, Term: 0}, {Op: sserver1.QueryOp_TERM, Term: 1}, {Op: sserver1.QueryOp_NOT}, {Op: sserver1.QueryOp_AND}}
+	queryTrapdoors := []sserver1.Trapdoor{{Codeword: sib.ComputeTrapdoors("file")}, {Codeword: sib.ComputeTrapdoors("this")}}
+	result, err := s.SearchQuery(ctx, sserver1.SearchQueryArg{TlfID: tlfID, Query: query, Trapdoors: map[string][]sserver1.Trapdoor{"1": queryTrapdoors}})
+	if err != nil {
+		t.Fatalf("error when searching the query: %s", err)
+	}
+	if !reflect.DeepEqual(result, []sserver1.DocumentID{docIDs[2]}) {
+		t.Fatalf("incorrect search result for \"file AND NOT this\": %v", result)
+	}
+	if _, err := s.SearchQuery(ctx, sserver1.SearchQueryArg{TlfID: tlfID, Query: query[:2], Trapdoors: map[string][]sserver1.Trapdoor{"1": queryTrapdoors}}); err == nil {
+		t.Fatalf("no error returned for malformed query")
+	}
+
+	renamedDocID, err := libsearch.PathnameToDocID(libkbfs.KeyGen(1), "renamed", pathnameKey)
+	if err != nil {
+		t.Fatalf("error when computing the document ID: %s", err)
+	}
+	if err := s.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: tlfID, Orig: docIDs[0], Curr: renamedDocID}); err != nil {
+		t.Fatalf("error when renaming the index: %s", err)
+	}
+	if err := s.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: tlfID, Orig: docIDs[0], Curr: renamedDocID}); err != nil {
+		t.Fatalf("error when renaming a non-existing index: %s", err)
+	}
+	if result := search("this"); len(result) != 2 || (result[0] != renamedDocID && result[1] != renamedDocID) {
+		t.Fatalf("index not properly renamed: %v", result)
+	}
+
+	if err := s.DeleteIndex(ctx, sserver1.DeleteIndexArg{TlfID: tlfID, DocID: docIDs[1]}); err != nil {
+		t.Fatalf("error when deleting the index: %s", err)
+	}
+	if err := s.DeleteIndex(ctx, sserver1.DeleteIndexArg{TlfID: tlfID, DocID: docIDs[1]}); err != nil {
+		t.Fatalf("error when deleting a non-existing index: %s", err)
+	}
+	if result := search("another"); !reflect.DeepEqual(result, []sserver1.DocumentID{docIDs[2]}) {
+		t.Fatalf("index not properly deleted: %v", result)
+	}
+}
+
+// TestSearchRanked tests the `WriteTfSketch` and `SearchRanked` functions.
+// Checks that the matching documents are ranked by their term frequencies, that
+// the sketches follow the indexes when renamed, and that the sketches of the
+// overwritten indexes are dropped.
+func TestSearchRanked(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{})
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], "a very secret pathname key")
+
+	contents := []string{"apple banana", "apple apple apple apple banana", "cherry", "apple apple"}
+	docIDs := make([]sserver1.DocumentID, len(contents))
+	for i, content := range contents {
+		docIDs[i], err = libsearch.PathnameToDocID(libkbfs.KeyGen(1), "file"+strconv.Itoa(i), pathnameKey)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		secIndex, tfSketch := buildTestIndexWithTfSketch(t, sib, content)
+		if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: secIndex, DocID: docIDs[i]}); err != nil {
+			t.Fatalf("error when writing the index: %s", err)
+		}
+		if err := s.WriteTfSketch(ctx, sserver1.WriteTfSketchArg{TlfID: tlfID, TfSketch: tfSketch, DocID: docIDs[i]}); err != nil {
+			t.Fatalf("error when writing the term frequency sketch: %s", err)
+		}
+	}
+
+	if err := s.WriteTfSketch(ctx, sserver1.WriteTfSketchArg{TlfID: tlfID, TfSketch: []byte("invalid"), DocID: docIDs[0]}); err == nil {
+		t.Fatalf("no error returned for invalid term frequency sketch")
+	}
+	for _, invalidSketch := range buildInvalidTestIndexes(t, tlfInfo) {
+		if err := s.WriteTfSketch(ctx, sserver1.WriteTfSketchArg{TlfID: tlfID, TfSketch: invalidSketch, DocID: docIDs[0]}); err == nil {
+			t.Fatalf("no error returned for term frequency sketch of invalid size")
+		}
+	}
+
+	renamedDocID, err := libsearch.PathnameToDocID(libkbfs.KeyGen(1), "renamed", pathnameKey)
+	if err != nil {
+		t.Fatalf("error when computing the document ID: %s", err)
+	}
+	if err := s.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: tlfID, Orig: docIDs[1], Curr: renamedDocID}); err != nil {
+		t.Fatalf("error when renaming the index: %s", err)
+	}
+
+	query := []sserver1.QueryToken{{Op: sserver1.QueryOp_TERM, Term: 0}}
+	trapdoors := []sserver1.Trapdoor{{Codeword: sib.ComputeTrapdoors("apple")}}
+	result, err := s.SearchRanked(ctx, sserver1.SearchRankedArg{TlfID: tlfID, Query: query, Trapdoors: map[string][]sserver1.Trapdoor{"1": trapdoors}})
+	if err != nil {
+		t.Fatalf("error when searching the query: %s", err)
+	}
+	expected := []sserver1.RankedDocument{{DocID: renamedDocID, Score: 3}, {DocID: docIDs[3], Score: 2}, {DocID: docIDs[0], Score: 1}}
+	if !reflect.DeepEqual(result.Documents, expected) {
+		t.Fatalf("incorrect ranked documents: %v", result.Documents)
+	}
+	if !reflect.DeepEqual(result.DocFrequencies, []int{3}) || result.NumDocuments != 4 {
+		t.Fatalf("incorrect document statistics: %v", result)
+	}
+
+	// The negated terms do not contribute to the scores.
+	negatedQuery := []sserver1.QueryToken{{Op: sserver1.QueryOp_TERM, Term: 0}, {Op: sserver1.QueryOp_TERM, Term: 1}, {Op: sserver1.QueryOp_NOT}, {Op: sserver1.QueryOp_OR}}
+	negatedTrapdoors := []sserver1.Trapdoor{{Codeword: sib.ComputeTrapdoors("banana")}, {Codeword: sib.ComputeTrapdoors("apple")}}
+	result, err = s.SearchRanked(ctx, sserver1.SearchRankedArg{TlfID: tlfID, Query: negatedQuery, Trapdoors: map[string][]sserver1.Trapdoor{"1": negatedTrapdoors}})
+	if err != nil {
+		t.Fatalf("error when searching the query: %s", err)
+	}
+	scores := make(map[sserver1.DocumentID]int)
+	for _, document := range result.Documents {
+		scores[document.DocID] = document.Score
+	}
+	if len(scores) != 3 || scores[docIDs[2]] != 0 || scores[renamedDocID] != scores[docIDs[0]] {
+		t.Fatalf("negated term scored: %v", result.Documents)
+	}
+
+	// Rewriting an index without a sketch drops the previous sketch, and so
+	// does renaming an index without a sketch over one with a sketch.
+	if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: buildTestIndex(t, sib, contents[3]), DocID: docIDs[3]}); err != nil {
+		t.Fatalf("error when writing the index: %s", err)
+	}
+	if err := s.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: tlfID, Orig: docIDs[3], Curr: renamedDocID}); err != nil {
+		t.Fatalf("error when renaming the index: %s", err)
+	}
+	result, err = s.SearchRanked(ctx, sserver1.SearchRankedArg{TlfID: tlfID, Query: query, Trapdoors: map[string][]sserver1.Trapdoor{"1": trapdoors}})
+	if err != nil {
+		t.Fatalf("error when searching the query: %s", err)
+	}
+	for _, document := range result.Documents {
+		if document.DocID == docIDs[3] || document.Score != 1 {
+			t.Fatalf("stale term frequency sketch used: %v", result.Documents)
+		}
+	}
+}
+
+// testSearchFuzzyHelper tests the `SearchFuzzy` function of `s`.  Checks that
+// the documents matching enough of the trigrams of a misspelled word are
+// returned in decreasing order of the number of trigrams matched.
+func testSearchFuzzyHelper(t *testing.T, s sserver1.SearchServerInterface) {
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000, Fuzzy: true})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{Fuzzy: true})
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], "a very secret pathname key")
+
+	contents := []string{"necessary", "necessity", "unrelated"}
+	docIDs := make([]sserver1.DocumentID, len(contents))
+	for i, content := range contents {
+		docIDs[i], err = libsearch.PathnameToDocID(libkbfs.KeyGen(1), "file"+strconv.Itoa(i), pathnameKey)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: buildTestIndex(t, sib, content), DocID: docIDs[i]}); err != nil {
+			t.Fatalf("error when writing the index: %s", err)
+		}
+	}
+
+	// "neccessary" shares 8 of its 10 trigrams with "necessary", and 4 with
+	// "necessity".
+	trapdoors := map[string][]sserver1.Trapdoor{"1": sib.ComputeTrigramTrapdoors("neccessary")}
+	testCases := []struct {
+		minMatches int
+		expected   []sserver1.RankedDocument
+	}{
+		{7, []sserver1.RankedDocument{{DocID: docIDs[0], Score: 8}}},
+		{1, []sserver1.RankedDocument{{DocID: docIDs[0], Score: 8}, {DocID: docIDs[1], Score: 4}}},
+		{9, nil},
+	}
+	for _, testCase := range testCases {
+		actual, err := s.SearchFuzzy(ctx, sserver1.SearchFuzzyArg{TlfID: tlfID, Trapdoors: trapdoors, MinMatches: testCase.minMatches})
+		if err != nil {
+			t.Fatalf("error when searching the trigrams: %s", err)
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("incorrect fuzzy search result for %d matches: expected %v actual %v", testCase.minMatches, testCase.expected, actual)
+		}
+	}
+}
+
+// TestSearchFuzzy tests the `SearchFuzzy` function of both `Server` and
+// `MemoryServer`.
+func TestSearchFuzzy(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	testSearchFuzzyHelper(t, s)
+	testSearchFuzzyHelper(t, CreateMemoryServer())
+}
+
+// testListDocumentsHelper tests the `ListDocuments` function of `s`.  Checks
+// that all the document IDs are returned in order across the pages.
+func testListDocumentsHelper(t *testing.T, s sserver1.SearchServerInterface) {
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{})
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], "a very secret pathname key")
+
+	list, err := s.ListDocuments(ctx, sserver1.ListDocumentsArg{TlfID: tlfID, Limit: 2})
+	if err != nil {
+		t.Fatalf("error when listing the documents: %s", err)
+	}
+	if len(list.Documents) != 0 || list.NextCursor != "" {
+		t.Fatalf("incorrect documents listed for an empty TLF: %v", list)
+	}
+
+	secIndex := buildTestIndex(t, sib, "content")
+	var expected []sserver1.DocumentID
+	for i := 0; i < 5; i++ {
+		docID, err := libsearch.PathnameToDocID(libkbfs.KeyGen(1), "file"+strconv.Itoa(i), pathnameKey)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		if err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: tlfID, SecureIndex: secIndex, DocID: docID}); err != nil {
+			t.Fatalf("error when writing the index: %s", err)
+		}
+		expected = append(expected, docID)
+	}
+	sort.Sort(docIDSlice(expected))
+
+	var actual []sserver1.DocumentID
+	var cursor sserver1.DocumentID
+	for numPages := 1; ; numPages++ {
+		list, err := s.ListDocuments(ctx, sserver1.ListDocumentsArg{TlfID: tlfID, Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("error when listing the documents: %s", err)
+		}
+		if len(list.Documents) > 2 {
+			t.Fatalf("too many documents in a page: %v", list.Documents)
+		}
+		actual = append(actual, list.Documents...)
+		if list.NextCursor == "" {
+			if numPages != 3 {
+				t.Fatalf("incorrect number of pages: %d", numPages)
+			}
+			break
+		}
+		cursor = list.NextCursor
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("incorrect documents listed: expected %v actual %v", expected, actual)
+	}
+}
+
+// TestListDocuments tests the `ListDocuments` function of both `Server` and
+// `MemoryServer`.
+func TestListDocuments(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	testListDocumentsHelper(t, s)
+	testListDocumentsHelper(t, CreateMemoryServer())
+}
+
+// testWriteIndexesHelper tests the `WriteIndexes` function of `s`.  Checks that
+// each index succeeds or fails on its own.
+func testWriteIndexesHelper(t *testing.T, s sserver1.SearchServerInterface) {
+	ctx := context.Background()
+	tlfID := sserver1.FolderID("tlf")
+	tlfInfo, err := s.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 8, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+
+	sib := libsearch.CreateSecureIndexBuilder(sha256.New, []byte("test"), tlfInfo.Salts, uint64(tlfInfo.Size), libsearch.ProseAnalyzer, libsearch.IndexOptions{})
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], "a very secret pathname key")
+
+	docIDs := make([]sserver1.DocumentID, 3)
+	for i := range docIDs {
+		docIDs[i], err = libsearch.PathnameToDocID(libkbfs.KeyGen(1), "file"+strconv.Itoa(i), pathnameKey)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+	}
+	secIndex, tfSketch := buildTestIndexWithTfSketch(t, sib, "batch content")
+	invalidIndexes := buildInvalidTestIndexes(t, tlfInfo)
+	items := []sserver1.IndexItem{
+		{DocID: docIDs[0], SecureIndex: secIndex, TfSketch: tfSketch},
+		{DocID: docIDs[1], SecureIndex: []byte("invalid")},
+		{DocID: docIDs[2], SecureIndex: secIndex},
+		{DocID: "invalid", SecureIndex: secIndex},
+		{DocID: docIDs[0], SecureIndex: secIndex, TfSketch: tfSketch},
+		{DocID: docIDs[1], SecureIndex: invalidIndexes[0]},
+		{DocID: docIDs[2], SecureIndex: secIndex},
+		{DocID: docIDs[1], SecureIndex: secIndex, TfSketch: invalidIndexes[1]},
+	}
+
+	if _, err := s.WriteIndexes(ctx, sserver1.WriteIndexesArg{TlfID: "unregistered", Indexes: items}); err == nil {
+		t.Fatalf("no error returned when writing to an unregistered TLF")
+	}
+
+	results, err := s.WriteIndexes(ctx, sserver1.WriteIndexesArg{TlfID: tlfID, Indexes: items})
+	if err != nil {
+		t.Fatalf("error when writing the indexes: %s", err)
+	}
+	if len(results) != len(items) {
+		t.Fatalf("incorrect number of results: %v", results)
+	}
+	for i, result := range results {
+		if result.DocID != items[i].DocID || (result.Error == "") != (i%2 == 0) {
+			t.Fatalf("incorrect result for item %d: %v", i, result)
+		}
+	}
+
+	result, err := s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: tlfID, Trapdoors: map[string]sserver1.Trapdoor{"1": {Codeword: sib.ComputeTrapdoors("batch")}}})
+	if err != nil {
+		t.Fatalf("error when searching the word: %s", err)
+	}
+	expected := []sserver1.DocumentID{docIDs[0], docIDs[2]}
+	sort.Sort(docIDSlice(expected))
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("incorrect search result: expected %v actual %v", expected, result)
+	}
+}
+
+// TestWriteIndexes tests the `WriteIndexes` function of both `Server` and
+// `MemoryServer`.
+func TestWriteIndexes(t *testing.T) {
+	s, dir := startTestServer(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	testWriteIndexesHelper(t, s)
+	testWriteIndexesHelper(t, CreateMemoryServer())
+}